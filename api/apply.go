@@ -0,0 +1,405 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+
+	"github.com/docker/swarmkit/api"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// managedLabel marks the services and networks created by POST /apply or by
+// the sync controller, its value is the one that created them. Each only
+// prunes its own objects.
+const managedLabel = "com.swarmkit-client.managed-by"
+
+// objectResult is what a deploy did, or would do, to one object.
+type objectResult struct {
+	Kind    string       `json:"kind"` // service or network
+	Name    string       `json:"name"`
+	ID      string       `json:"id,omitempty"`
+	Action  string       `json:"action"`            // create, update, delete or unchanged
+	Changes []specChange `json:"changes,omitempty"` // fields changed by an update
+	Error   string       `json:"error,omitempty"`
+}
+
+// newServiceSpec returns the spec of a service created by cspec, with the
+// defaults of POST /services/create.
+func newServiceSpec(ctx ct.Context, c api.ControlClient, cspec *createSpec) (*api.ServiceSpec, error) {
+	spec := &api.ServiceSpec{
+		Mode: &api.ServiceSpec_Replicated{
+			Replicated: &api.ReplicatedService{
+				Replicas: 1,
+			},
+		},
+		Task: api.TaskSpec{
+			Runtime: &api.TaskSpec_Container{
+				Container: &api.ContainerSpec{},
+			},
+		},
+	}
+	if err := merge(ctx, cspec, spec, c); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+// planService returns the spec of cspec and the action turning current, nil
+// when the service does not exist, into it. The spec is nil on error.
+func planService(ctx ct.Context, c api.ControlClient, cspec *createSpec, current *api.Service) (*api.ServiceSpec, *objectResult) {
+	res := &objectResult{Kind: "service", Name: cspec.Name}
+	var currentSpec *api.ServiceSpec
+	if current != nil {
+		res.ID = current.ID
+		currentSpec = &current.Spec
+	}
+
+	spec, err := newServiceSpec(ctx, c, cspec)
+	if err == nil {
+		err = checkResources(ctx, c, spec, currentSpec, res.ID)
+	}
+	if err != nil {
+		res.Error = grpc.ErrorDesc(err)
+		return nil, res
+	}
+
+	switch {
+	case current == nil:
+		res.Action = "create"
+	case reflect.DeepEqual(spec, currentSpec):
+		res.Action = "unchanged"
+	default:
+		res.Action = "update"
+		res.Changes = diffSpecs(currentSpec, spec)
+	}
+	return spec, res
+}
+
+// applyService runs the action of res planned by planService.
+func applyService(c *context, r *http.Request, spec *api.ServiceSpec, current *api.Service, res *objectResult) {
+	switch res.Action {
+	case "create":
+		resp, err := c.swarmkitAPI.CreateService(r.Context(), &api.CreateServiceRequest{Spec: spec})
+		if err != nil {
+			res.Error = grpc.ErrorDesc(err)
+			return
+		}
+		res.ID = resp.Service.ID
+		recordRevision(c, r, resp.Service, nil, "create", 0)
+	case "update":
+		resp, err := c.swarmkitAPI.UpdateService(r.Context(), &api.UpdateServiceRequest{
+			ServiceID:      current.ID,
+			ServiceVersion: &current.Meta.Version,
+			Spec:           spec,
+		})
+		if err != nil {
+			res.Error = grpc.ErrorDesc(err)
+			return
+		}
+		recordRevision(c, r, resp.Service, current, "update", 0)
+	}
+}
+
+// removeServiceObject deletes a service and its revisions, the error is kept
+// in its result.
+func removeServiceObject(ctx ct.Context, c *context, s *api.Service) *objectResult {
+	res := &objectResult{Kind: "service", Name: s.Spec.Annotations.Name, ID: s.ID, Action: "delete"}
+	if _, err := c.swarmkitAPI.RemoveService(ctx, &api.RemoveServiceRequest{ServiceID: s.ID}); err != nil {
+		res.Error = grpc.ErrorDesc(err)
+		return res
+	}
+	forgetRevisions(c, s.ID)
+	return res
+}
+
+// removeNetworkObject deletes a network, the error is kept in its result.
+func removeNetworkObject(ctx ct.Context, c api.ControlClient, n *api.Network) *objectResult {
+	res := &objectResult{Kind: "network", Name: n.Spec.Annotations.Name, ID: n.ID, Action: "delete"}
+	if _, err := c.RemoveNetwork(ctx, &api.RemoveNetworkRequest{NetworkID: n.ID}); err != nil {
+		res.Error = grpc.ErrorDesc(err)
+	}
+	return res
+}
+
+// plannedNetworks resolves the names of the networks an apply is going to
+// create, so the services attached to them can be planned beforehand.
+type plannedNetworks struct {
+	api.ControlClient
+	networks map[string]*api.Network
+}
+
+// ListNetworks adds the planned networks matching a name filter.
+func (pn *plannedNetworks) ListNetworks(ctx ct.Context, in *api.ListNetworksRequest, opts ...grpc.CallOption) (*api.ListNetworksResponse, error) {
+	if in.Filters != nil && len(in.Filters.Names) > 0 {
+		resp := &api.ListNetworksResponse{}
+		for _, name := range in.Filters.Names {
+			if n, ok := pn.networks[name]; ok {
+				resp.Networks = append(resp.Networks, n)
+			}
+		}
+		if len(resp.Networks) > 0 {
+			return resp, nil
+		}
+	}
+	return pn.ControlClient.ListNetworks(ctx, in, opts...)
+}
+
+// applyDocument is the desired state of the services and networks.
+type applyDocument struct {
+	Networks []*networkInfo `json:"networks"` // as for POST /networks/creat
+	Services []*createSpec  `json:"services"` // as for POST /services/create
+	Prune    bool           `json:"prune"`    // delete the managed objects missing from the document
+	Adopt    bool           `json:"adopt"`    // take over the existing services not managed by this manager
+
+	manager string // value of managedLabel, apply when empty
+}
+
+// applyPlan is the ordered actions converging the cluster to a document.
+type applyPlan struct {
+	networks       []*objectResult
+	networkSpecs   map[string]*api.NetworkSpec
+	services       []*objectResult
+	serviceSpecs   map[string]*createSpec
+	liveServices   map[string]*api.Service
+	deleteServices []*api.Service
+	deleteNetworks []*api.Network
+}
+
+func (p *applyPlan) results() []*objectResult {
+	results := append([]*objectResult{}, p.networks...)
+	results = append(results, p.services...)
+	for _, s := range p.deleteServices {
+		results = append(results, &objectResult{Kind: "service", Name: s.Spec.Annotations.Name, ID: s.ID, Action: "delete"})
+	}
+	for _, n := range p.deleteNetworks {
+		results = append(results, &objectResult{Kind: "network", Name: n.Spec.Annotations.Name, ID: n.ID, Action: "delete"})
+	}
+	return results
+}
+
+func (p *applyPlan) failed() bool {
+	for _, res := range append(append([]*objectResult{}, p.networks...), p.services...) {
+		if len(res.Error) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sameNetworkSpec tells whether the live spec of a network is the desired
+// one. The managed label is ignored, so an existing network can be used
+// by a document that did not create it.
+func sameNetworkSpec(live, desired *api.NetworkSpec) bool {
+	normalize := func(spec *api.NetworkSpec) *api.NetworkSpec {
+		spec = spec.Copy()
+		delete(spec.Annotations.Labels, managedLabel)
+		if len(spec.Annotations.Labels) == 0 {
+			spec.Annotations.Labels = nil
+		}
+		if spec.DriverConfig != nil && len(spec.DriverConfig.Options) == 0 {
+			spec.DriverConfig.Options = nil
+		}
+		return spec
+	}
+	return reflect.DeepEqual(normalize(live), normalize(desired))
+}
+
+// planApply compares doc with the live services and networks.
+func planApply(ctx ct.Context, c api.ControlClient, doc *applyDocument) (*applyPlan, error) {
+	services, err := c.ListServices(ctx, &api.ListServicesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	networks, err := c.ListNetworks(ctx, &api.ListNetworksRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	p := &applyPlan{
+		networkSpecs: make(map[string]*api.NetworkSpec),
+		serviceSpecs: make(map[string]*createSpec),
+		liveServices: make(map[string]*api.Service),
+	}
+	liveNetworks := make(map[string]*api.Network)
+	for _, n := range networks.Networks {
+		liveNetworks[n.Spec.Annotations.Name] = n
+	}
+	for _, s := range services.Services {
+		p.liveServices[s.Spec.Annotations.Name] = s
+	}
+
+	manager := doc.manager
+	if len(manager) == 0 {
+		manager = "apply"
+	}
+
+	planned := &plannedNetworks{ControlClient: c, networks: make(map[string]*api.Network)}
+	for _, nwInfo := range doc.Networks {
+		labels := map[string]string{managedLabel: manager}
+		for k, v := range nwInfo.Labels {
+			labels[k] = v
+		}
+		nwInfo.Labels = labels
+
+		if _, ok := p.networkSpecs[nwInfo.Name]; ok {
+			return nil, grpc.Errorf(codes.InvalidArgument, "network %s is declared twice", nwInfo.Name)
+		}
+		res := &objectResult{Kind: "network", Name: nwInfo.Name, Action: "create"}
+		p.networks = append(p.networks, res)
+		spec, err := newNetworkSpec(nwInfo)
+		if err != nil {
+			res.Error = grpc.ErrorDesc(err)
+			continue
+		}
+		p.networkSpecs[nwInfo.Name] = spec
+		// networks cannot be updated, an existing one is kept when it matches
+		if n, ok := liveNetworks[nwInfo.Name]; ok {
+			res.ID, res.Action = n.ID, "unchanged"
+			if !sameNetworkSpec(&n.Spec, spec) {
+				res.Action = "update"
+				res.Error = fmt.Sprintf("network %s exists with another spec and networks cannot be updated, remove it first", nwInfo.Name)
+			}
+			continue
+		}
+		planned.networks[nwInfo.Name] = &api.Network{ID: "planned-" + nwInfo.Name, Spec: *spec}
+	}
+
+	for _, cspec := range doc.Services {
+		if len(cspec.Name) == 0 || len(cspec.Image) == 0 {
+			return nil, grpc.Errorf(codes.InvalidArgument, "name and image are mandatory")
+		}
+		if _, ok := p.serviceSpecs[cspec.Name]; ok {
+			return nil, grpc.Errorf(codes.InvalidArgument, "service %s is declared twice", cspec.Name)
+		}
+		labels := map[string]string{managedLabel: manager}
+		for k, v := range cspec.Labels {
+			labels[k] = v
+		}
+		cspec.Labels = labels
+		p.serviceSpecs[cspec.Name] = cspec
+
+		current := p.liveServices[cspec.Name]
+		if current != nil && current.Spec.Annotations.Labels[managedLabel] != manager && !doc.Adopt {
+			p.services = append(p.services, &objectResult{
+				Kind:   "service",
+				Name:   cspec.Name,
+				ID:     current.ID,
+				Action: "update",
+				Error:  fmt.Sprintf("service %s exists and is not managed by %s, set adopt to take it over", cspec.Name, manager),
+			})
+			continue
+		}
+		_, res := planService(ctx, planned, cspec, current)
+		p.services = append(p.services, res)
+	}
+
+	if doc.Prune {
+		for _, s := range services.Services {
+			if s.Spec.Annotations.Labels[managedLabel] == manager && p.serviceSpecs[s.Spec.Annotations.Name] == nil {
+				p.deleteServices = append(p.deleteServices, s)
+			}
+		}
+		for _, n := range networks.Networks {
+			if n.Spec.Annotations.Labels[managedLabel] == manager && p.networkSpecs[n.Spec.Annotations.Name] == nil {
+				p.deleteNetworks = append(p.deleteNetworks, n)
+			}
+		}
+	}
+
+	sort.Sort(resultsByName(p.networks))
+	sort.Sort(resultsByName(p.services))
+	return p, nil
+}
+
+type resultsByName []*objectResult
+
+func (rs resultsByName) Len() int           { return len(rs) }
+func (rs resultsByName) Less(i, j int) bool { return rs[i].Name < rs[j].Name }
+func (rs resultsByName) Swap(i, j int)      { rs[i], rs[j] = rs[j], rs[i] }
+
+// execute runs the plan: networks are created first, then services are
+// created or updated, then the pruned services and finally the pruned
+// networks are deleted.
+func (p *applyPlan) execute(c *context, r *http.Request) []*objectResult {
+	ctx := r.Context()
+	results := []*objectResult{}
+
+	for _, res := range p.networks {
+		if res.Action == "create" {
+			resp, err := c.swarmkitAPI.CreateNetwork(ctx, &api.CreateNetworkRequest{Spec: p.networkSpecs[res.Name]})
+			if err != nil {
+				res.Error = grpc.ErrorDesc(err)
+			} else {
+				res.ID = resp.Network.ID
+			}
+		}
+		results = append(results, res)
+	}
+
+	// the services are planned again to resolve the networks just created
+	for _, planned := range p.services {
+		current := p.liveServices[planned.Name]
+		spec, res := planService(ctx, c.swarmkitAPI, p.serviceSpecs[planned.Name], current)
+		if len(res.Error) == 0 {
+			applyService(c, r, spec, current, res)
+		}
+		results = append(results, res)
+	}
+
+	for _, s := range p.deleteServices {
+		results = append(results, removeServiceObject(ctx, c, s))
+	}
+	for _, n := range p.deleteNetworks {
+		results = append(results, removeNetworkObject(ctx, c.swarmkitAPI, n))
+	}
+	return results
+}
+
+// POST /apply?dry_run=1
+//    dry_run: return the plan without applying it
+// {
+//    networks: [],     // desired networks, as for POST /networks/creat
+//    services: [],     // desired services, as for POST /services/create
+//    prune: false,     // delete the services and networks created by /apply that are missing
+//    adopt: false,     // take over the existing services not created by /apply
+// }
+// Services are compared by name and replaced by their desired spec. A
+// service of the same name not created by /apply is an error unless adopt is
+// set, as is an existing network with another spec. Nothing is changed when
+// an object cannot be planned, the response then has applied false and the
+// errors of the plan.
+func applyState(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err    error
+		plan   *applyPlan
+		doc    = &applyDocument{}
+		dryRun = queryBool(r, "dry_run")
+	)
+
+	if err = DecoderRequest(r, doc); err != nil {
+		errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "Parse params for apply error:%v", err), c)
+		return
+	}
+
+	if plan, err = planApply(r.Context(), c.swarmkitAPI, doc); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+
+	if dryRun || plan.failed() {
+		c.render.JSON(w, http.StatusOK, map[string]interface{}{
+			"applied": false,
+			"results": plan.results(),
+		})
+		return
+	}
+
+	c.render.JSON(w, http.StatusOK, map[string]interface{}{
+		"applied": true,
+		"results": plan.execute(c, r),
+	})
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/docker/swarmkit/api"
+	ct "golang.org/x/net/context"
+)
+
+func parseApplyDocument(t *testing.T, s string) *applyDocument {
+	doc := &applyDocument{}
+	if err := json.Unmarshal([]byte(s), doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// planActions returns the results of p as "kind name action", followed by
+// the error if any.
+func planActions(results []*objectResult) []string {
+	actions := []string{}
+	for _, res := range results {
+		action := res.Kind + " " + res.Name + " " + res.Action
+		if len(res.Error) > 0 {
+			action += ": " + res.Error
+		}
+		actions = append(actions, action)
+	}
+	return actions
+}
+
+func TestApplyPlan(t *testing.T) {
+	applied := `{"networks": [{"name": "back", "driver": "overlay"}], "services": [{"name": "web", "image": "nginx"}, {"name": "db", "image": "redis"}]}`
+
+	tests := []struct {
+		name    string
+		setup   func(c *clusterTestClient)
+		doc     string
+		actions []string
+		failed  bool
+	}{
+		{
+			"empty cluster",
+			func(c *clusterTestClient) {},
+			applied,
+			[]string{"network back create", "service db create", "service web create"},
+			false,
+		},
+		{
+			"applied again",
+			nil,
+			applied,
+			[]string{"network back unchanged", "service db unchanged", "service web unchanged"},
+			false,
+		},
+		{
+			"changed image",
+			nil,
+			`{"networks": [{"name": "back", "driver": "overlay"}], "services": [{"name": "web", "image": "nginx:1.11"}, {"name": "db", "image": "redis"}]}`,
+			[]string{"network back unchanged", "service db unchanged", "service web update"},
+			false,
+		},
+		{
+			"missing objects without prune",
+			nil,
+			`{"services": [{"name": "web", "image": "nginx:1.11"}]}`,
+			[]string{"service web unchanged"},
+			false,
+		},
+		{
+			"missing objects with prune",
+			nil,
+			`{"services": [{"name": "web", "image": "nginx:1.11"}], "prune": true}`,
+			[]string{"service web unchanged", "service db delete", "network back delete"},
+			false,
+		},
+		{
+			"unmanaged objects are not pruned",
+			func(c *clusterTestClient) {
+				c.addService(testServiceSpec("web", "nginx", nil))
+				c.addNetwork(&api.NetworkSpec{Annotations: api.Annotations{Name: "back"}})
+			},
+			`{"prune": true}`,
+			[]string{},
+			false,
+		},
+		{
+			"unmanaged service",
+			func(c *clusterTestClient) { c.addService(testServiceSpec("web", "nginx", nil)) },
+			`{"services": [{"name": "web", "image": "nginx"}]}`,
+			[]string{"service web update: service web exists and is not managed by apply, set adopt to take it over"},
+			true,
+		},
+		{
+			"service of another manager",
+			func(c *clusterTestClient) {
+				c.addService(testServiceSpec("web", "nginx", map[string]string{managedLabel: "sync"}))
+			},
+			`{"services": [{"name": "web", "image": "nginx"}]}`,
+			[]string{"service web update: service web exists and is not managed by apply, set adopt to take it over"},
+			true,
+		},
+		{
+			"adopted service",
+			func(c *clusterTestClient) { c.addService(testServiceSpec("web", "nginx", nil)) },
+			`{"services": [{"name": "web", "image": "nginx"}], "adopt": true}`,
+			[]string{"service web update"},
+			false,
+		},
+		{
+			"existing network",
+			func(c *clusterTestClient) {
+				c.addNetwork(&api.NetworkSpec{Annotations: api.Annotations{Name: "back"}, DriverConfig: &api.Driver{Name: "overlay"}})
+			},
+			`{"networks": [{"name": "back", "driver": "overlay"}]}`,
+			[]string{"network back unchanged"},
+			false,
+		},
+		{
+			"network with another spec",
+			func(c *clusterTestClient) {
+				c.addNetwork(&api.NetworkSpec{Annotations: api.Annotations{Name: "back"}, DriverConfig: &api.Driver{Name: "bridge"}})
+			},
+			`{"networks": [{"name": "back", "driver": "overlay"}]}`,
+			[]string{"network back update: network back exists with another spec and networks cannot be updated, remove it first"},
+			true,
+		},
+		{
+			"declared twice",
+			func(c *clusterTestClient) {},
+			`{"services": [{"name": "web", "image": "nginx"}, {"name": "web", "image": "nginx"}]}`,
+			nil,
+			true,
+		},
+	}
+
+	var client *clusterTestClient
+	for _, test := range tests {
+		// a nil setup keeps the cluster of the previous test, once applied
+		if test.setup != nil {
+			client = newClusterTestClient()
+			test.setup(client)
+		}
+
+		plan, err := planApply(ct.Background(), client, parseApplyDocument(t, test.doc))
+		if test.actions == nil {
+			if err == nil {
+				t.Errorf("%s: got no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if actions := planActions(plan.results()); !reflect.DeepEqual(actions, test.actions) {
+			t.Errorf("%s: got plan %v, want %v", test.name, actions, test.actions)
+		}
+		if plan.failed() != test.failed {
+			t.Errorf("%s: got failed %v, want %v", test.name, plan.failed(), test.failed)
+		}
+
+		if !plan.failed() {
+			for _, res := range plan.execute(newTestContext(client), httptest.NewRequest(http.MethodPost, "/apply", nil)) {
+				if len(res.Error) > 0 {
+					t.Errorf("%s: %s %s: %s", test.name, res.Kind, res.Name, res.Error)
+				}
+			}
+		}
+	}
+}
+
+func TestApplyAdoptLabels(t *testing.T) {
+	client := newClusterTestClient()
+	client.addService(testServiceSpec("web", "nginx", map[string]string{"team": "front"}))
+
+	plan, err := planApply(ct.Background(), client, parseApplyDocument(t, `{"services": [{"name": "web", "image": "nginx", "labels": {"team": "front"}}], "adopt": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan.execute(newTestContext(client), httptest.NewRequest(http.MethodPost, "/apply", nil))
+
+	labels := client.service("web").Spec.Annotations.Labels
+	if want := map[string]string{"team": "front", managedLabel: "apply"}; !reflect.DeepEqual(labels, want) {
+		t.Errorf("got labels %v, want %v", labels, want)
+	}
+	if changes := client.changes(); !reflect.DeepEqual(changes, []string{"UpdateService web"}) {
+		t.Errorf("got changes %v, want an update of web", changes)
+	}
+}
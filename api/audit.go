@@ -0,0 +1,400 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// auditRecord is one line of the audit log.
+type auditRecord struct {
+	Time     time.Time   `json:"time"`
+	User     *Identity   `json:"user"`
+	Method   string      `json:"method"`
+	Route    string      `json:"route"`
+	URI      string      `json:"uri"`
+	ObjectID string      `json:"object_id,omitempty"` // resolved ID of the object the call changed
+	Body     interface{} `json:"body,omitempty"`      // request body, sensitive values redacted
+	Status   int         `json:"status"`
+	Error    string      `json:"error,omitempty"`
+	Before   interface{} `json:"before,omitempty"` // spec before a service update
+	After    interface{} `json:"after,omitempty"`  // spec after a service update
+}
+
+// AuditLog writes audit records to a json lines file, rotated when it grows
+// over maxSize bytes. maxBackups rotated files are kept.
+type AuditLog struct {
+	sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+// NewAuditLog opens or creates the audit log at path.
+func NewAuditLog(path string, maxSize int64, maxBackups int) (*AuditLog, error) {
+	a := &AuditLog{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AuditLog) open() error {
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	a.file, a.size = f, fi.Size()
+	return nil
+}
+
+// backup returns the path of the n-th rotated file, the current file being 0.
+func (a *AuditLog) backup(n int) string {
+	if n == 0 {
+		return a.path
+	}
+	return fmt.Sprintf("%s.%d", a.path, n)
+}
+
+func (a *AuditLog) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(a.backup(a.maxBackups))
+	for n := a.maxBackups - 1; n >= 0; n-- {
+		if err := os.Rename(a.backup(n), a.backup(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return a.open()
+}
+
+func (a *AuditLog) write(rec *auditRecord) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	a.Lock()
+	defer a.Unlock()
+	if a.maxSize > 0 && a.size > 0 && a.size+int64(len(b)) > a.maxSize {
+		if err = a.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := a.file.Write(b)
+	a.size += int64(n)
+	return err
+}
+
+// auditFilter selects records in query.
+type auditFilter struct {
+	since, until time.Time
+	object, user string
+	limit        int
+}
+
+func (f *auditFilter) match(rec *auditRecord) bool {
+	if !f.since.IsZero() && rec.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && rec.Time.After(f.until) {
+		return false
+	}
+	if len(f.object) > 0 && rec.ObjectID != f.object && !strings.Contains(rec.URI, f.object) {
+		return false
+	}
+	if len(f.user) > 0 && (rec.User == nil || rec.User.Name != f.user) {
+		return false
+	}
+	return true
+}
+
+// openFiles opens the log files, oldest first, and returns them with the size of
+// the current file. A rotation renames the files but the open files still
+// read the same records.
+func (a *AuditLog) openFiles() ([]*os.File, int64, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	files := []*os.File{}
+	for n := a.maxBackups; n >= 0; n-- {
+		file, err := os.Open(a.backup(n))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, 0, err
+		}
+		files = append(files, file)
+	}
+	return files, a.size, nil
+}
+
+// query returns the records matching f, oldest first. With a limit only the
+// most recent records are returned. The files are read without the lock, so
+// a query never blocks the audited calls; the current file is read up to its
+// size when it was opened, not into a record being written.
+func (a *AuditLog) query(f *auditFilter) ([]*auditRecord, error) {
+	files, size, err := a.openFiles()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+
+	records := []*auditRecord{}
+	for i, file := range files {
+		var r io.Reader = file
+		if i == len(files)-1 {
+			r = io.LimitReader(file, size)
+		}
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			rec := &auditRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+				log.WithField("file", file.Name()).Warnf("Skip invalid audit record: %v", err)
+				continue
+			}
+			if f.match(rec) {
+				records = append(records, rec)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.limit > 0 && len(records) > f.limit {
+		records = records[len(records)-f.limit:]
+	}
+	return records, nil
+}
+
+type auditKey struct{}
+
+// auditFromRequest returns the audit record of a mutating request, or nil
+// when the request is not audited.
+func auditFromRequest(r *http.Request) *auditRecord {
+	rec, _ := r.Context().Value(auditKey{}).(*auditRecord)
+	return rec
+}
+
+// auditObject records the resolved ID of the object changed by the request.
+func auditObject(r *http.Request, id string) {
+	if rec := auditFromRequest(r); rec != nil {
+		rec.ObjectID = id
+	}
+}
+
+// auditSpecs records the spec of an object before and after the request.
+func auditSpecs(r *http.Request, before, after interface{}) {
+	if rec := auditFromRequest(r); rec != nil {
+		rec.Before = redact(before)
+		rec.After = redact(after)
+	}
+}
+
+// statusRecorder remembers the status written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// Flush lets streamed responses through.
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// audited runs fct and writes an audit record of the call.
+func audited(c *context, route string, fct handler, w http.ResponseWriter, r *http.Request) {
+	rec := &auditRecord{
+		Time:   time.Now().UTC(),
+		User:   requestIdentity(r),
+		Method: r.Method,
+		Route:  route,
+		URI:    requestURI(r),
+	}
+
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			errResponse(w, r, err, c)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if len(bytes.TrimSpace(body)) > 0 {
+			var v interface{}
+			if err = json.Unmarshal(body, &v); err != nil {
+				// compose files and other yaml documents
+				v = redactText(string(body))
+			}
+			rec.Body = redactValue(v)
+		}
+	}
+
+	sw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	fct(c, sw, r.WithContext(ct.WithValue(r.Context(), auditKey{}, rec)))
+	rec.Status = sw.status
+
+	if err := c.audit.write(rec); err != nil {
+		log.WithFields(log.Fields{"method": rec.Method, "uri": rec.URI}).Errorf("Write audit record error: %v", err)
+	}
+}
+
+// sensitiveName matches keys and environment variables whose value must not
+// be written to the audit log.
+var sensitiveName = regexp.MustCompile(`(?i)(secret|passw(or)?d|token|credential|private|api[_-]?key)`)
+
+const redacted = "*****"
+
+// redact converts v to its json representation with sensitive values hidden.
+func redact(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var generic interface{}
+	if err = json.Unmarshal(b, &generic); err != nil {
+		return nil
+	}
+	return redactValue(generic)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if sensitiveName.MatchString(k) {
+				t[k] = redacted
+				continue
+			}
+			t[k] = redactValue(val)
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = redactValue(val)
+		}
+	case string:
+		// environment variables, KEY=value
+		if i := strings.Index(t, "="); i > 0 && sensitiveName.MatchString(t[:i]) {
+			return t[:i+1] + redacted
+		}
+	}
+	return v
+}
+
+// sensitiveLine matches the yaml lines and the environment variables of a
+// text body, "key: value", "- key=value" or "key=value", with the separator
+// as second group.
+var sensitiveLine = regexp.MustCompile(`^(\s*(?:-\s*)?["']?([^\s:="']+)["']?\s*)(:\s*|=)(.*)$`)
+
+// redactText hides the sensitive values of a text body, line by line.
+func redactText(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		m := sensitiveLine.FindStringSubmatch(line)
+		if m == nil || len(strings.TrimSpace(m[4])) == 0 || !sensitiveName.MatchString(m[2]) {
+			continue
+		}
+		lines[i] = m[1] + m[3] + redacted
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseTime accepts RFC 3339 times and unix timestamps.
+func parseTime(s string) (time.Time, error) {
+	if sec, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(sec, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// GET /audit?since=&until=&object=&user=&limit=
+//    since, until: RFC 3339 time or unix timestamp
+//    object:       object ID
+//    user:         caller name
+//    limit:        return only the most recent records
+func listAudit(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err    error
+		q      = r.URL.Query()
+		filter = &auditFilter{
+			object: q.Get("object"),
+			user:   q.Get("user"),
+		}
+	)
+
+	if c.audit == nil {
+		errResponse(w, r, grpc.Errorf(codes.Unimplemented, "audit log is not enabled"), c)
+		return
+	}
+
+	if s := q.Get("since"); len(s) > 0 {
+		if filter.since, err = parseTime(s); err != nil {
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid since: %v", err), c)
+			return
+		}
+	}
+	if s := q.Get("until"); len(s) > 0 {
+		if filter.until, err = parseTime(s); err != nil {
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid until: %v", err), c)
+			return
+		}
+	}
+	if s := q.Get("limit"); len(s) > 0 {
+		if filter.limit, err = strconv.Atoi(s); err != nil {
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid limit: %v", err), c)
+			return
+		}
+	}
+
+	records, err := c.audit.query(filter)
+	if err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	c.render.JSON(w, http.StatusOK, records)
+}
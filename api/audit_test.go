@@ -0,0 +1,89 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRedactText(t *testing.T) {
+	compose := `version: "3"
+services:
+  db:
+    image: postgres
+    environment:
+      POSTGRES_PASSWORD: s3cret
+      - API_KEY=abc
+      PGDATA: /data
+    labels:
+      "auth.token": 'xyz'
+`
+	want := `version: "3"
+services:
+  db:
+    image: postgres
+    environment:
+      POSTGRES_PASSWORD: *****
+      - API_KEY=*****
+      PGDATA: /data
+    labels:
+      "auth.token": *****
+`
+	if got := redactText(compose); got != want {
+		t.Errorf("redactText =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAuditQueryRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// every record rotates the file
+	a, err := NewAuditLog(filepath.Join(dir, "audit.log"), 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().UTC()
+	for i, uri := range []string{"/a", "/b", "/c", "/d"} {
+		rec := &auditRecord{Time: start.Add(time.Duration(i) * time.Second), Method: "POST", URI: uri}
+		if err = a.write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		filter *auditFilter
+		uris   []string
+	}{
+		// the oldest record was rotated out
+		{&auditFilter{}, []string{"/b", "/c", "/d"}},
+		{&auditFilter{limit: 2}, []string{"/c", "/d"}},
+		{&auditFilter{since: start.Add(2 * time.Second)}, []string{"/c", "/d"}},
+		{&auditFilter{object: "/b"}, []string{"/b"}},
+	}
+	for _, test := range tests {
+		records, err := a.query(test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uris := []string{}
+		for _, rec := range records {
+			uris = append(uris, rec.URI)
+		}
+		if len(uris) != len(test.uris) {
+			t.Errorf("query(%+v) = %v, want %v", test.filter, uris, test.uris)
+			continue
+		}
+		for i := range uris {
+			if uris[i] != test.uris[i] {
+				t.Errorf("query(%+v) = %v, want %v", test.filter, uris, test.uris)
+				break
+			}
+		}
+	}
+}
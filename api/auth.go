@@ -0,0 +1,258 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Role is the set of permissions given to a caller. Roles are ordered, each
+// one includes the permissions of the previous ones.
+type Role int
+
+const (
+	// RoleNone cannot call any route.
+	RoleNone Role = iota
+	// RoleViewer can read the cluster state.
+	RoleViewer
+	// RoleDeployer can also create, update and remove services and tasks.
+	RoleDeployer
+	// RoleAdmin can call every route, including node, network and cluster changes.
+	RoleAdmin
+)
+
+var roleNames = map[Role]string{
+	RoleNone:     "none",
+	RoleViewer:   "viewer",
+	RoleDeployer: "deployer",
+	RoleAdmin:    "admin",
+}
+
+func (r Role) String() string {
+	return roleNames[r]
+}
+
+// ParseRole returns the role named s.
+func ParseRole(s string) (Role, error) {
+	for r, name := range roleNames {
+		if name == s {
+			return r, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role %s", s)
+}
+
+// MarshalJSON encodes the role with its name.
+func (r Role) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// UnmarshalJSON decodes a role name.
+func (r *Role) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(b, &s); err != nil {
+		return
+	}
+	*r, err = ParseRole(s)
+	return
+}
+
+// Identity is the authenticated caller of a request.
+type Identity struct {
+	Name   string `json:"name"`   // user name or certificate common name
+	Role   Role   `json:"role"`   // granted role
+	Method string `json:"method"` // how the caller was authenticated (token, cert, anonymous)
+}
+
+// anonymous is the identity of every caller when authentication is disabled.
+var anonymous = &Identity{Name: "anonymous", Role: RoleAdmin, Method: "anonymous"}
+
+// unauthenticated is the identity recorded for the requests the
+// authenticators rejected.
+var unauthenticated = &Identity{Name: "unauthenticated", Role: RoleNone, Method: "none"}
+
+// Authenticator identifies the caller of a request. It returns a nil identity
+// and no error when the request carries no credentials it understands, so the
+// next authenticator can be tried.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Identity, error)
+}
+
+type identityKey struct{}
+
+// requestIdentity returns the identity attached to the request by
+// setupPrimaryRouter.
+func requestIdentity(r *http.Request) *Identity {
+	if id, ok := r.Context().Value(identityKey{}).(*Identity); ok {
+		return id
+	}
+	return anonymous
+}
+
+// authenticate runs the authenticators in order. Without authenticators every
+// caller is an anonymous admin.
+func authenticate(authenticators []Authenticator, r *http.Request) (*Identity, error) {
+	if len(authenticators) == 0 {
+		return anonymous, nil
+	}
+	for _, a := range authenticators {
+		id, err := a.Authenticate(r)
+		if err != nil {
+			return nil, grpc.Errorf(codes.Unauthenticated, "%v", err)
+		}
+		if id != nil {
+			return id, nil
+		}
+	}
+	return nil, grpc.Errorf(codes.Unauthenticated, "authentication required")
+}
+
+// authorize checks that id may call route with method.
+func authorize(id *Identity, method, route string) error {
+	required, ok := routeRoles[method][route]
+	if !ok {
+		required = RoleAdmin
+	}
+	if id.Role < required {
+		return grpc.Errorf(codes.PermissionDenied, "%s %s requires role %s, %s has role %s",
+			method, route, required, id.Name, id.Role)
+	}
+	return nil
+}
+
+func withIdentity(r *http.Request, id *Identity) *http.Request {
+	return r.WithContext(ct.WithValue(r.Context(), identityKey{}, id))
+}
+
+// authFile is the format of the file given to LoadAuthenticators.
+//
+//	{
+//	  "tokens": {"<token>": {"name": "ci", "role": "deployer"}},
+//	  "certs": {"dashboard.example.com": "viewer"}
+//	}
+type authFile struct {
+	Tokens map[string]struct {
+		Name string `json:"name"`
+		Role Role   `json:"role"`
+	} `json:"tokens"`
+	Certs map[string]Role `json:"certs"`
+}
+
+// LoadAuthenticators reads static bearer tokens and client certificate
+// common names with their roles from a json file.
+func LoadAuthenticators(path string) ([]Authenticator, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &authFile{}
+	if err = json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("parse auth file %s: %v", path, err)
+	}
+
+	tokens := &tokenAuthenticator{}
+	for token, user := range f.Tokens {
+		if len(token) == 0 {
+			return nil, fmt.Errorf("empty token for user %s", user.Name)
+		}
+		tokens.tokens = append(tokens.tokens, []byte(token))
+		tokens.identities = append(tokens.identities, &Identity{Name: user.Name, Role: user.Role, Method: "token"})
+	}
+
+	return []Authenticator{
+		&certAuthenticator{roles: f.Certs},
+		tokens,
+	}, nil
+}
+
+// queryTokenRoutes are the GET routes accepting the access_token query
+// parameter: the event streams, opened by browser EventSource and WebSocket
+// clients that cannot set headers.
+var queryTokenRoutes = map[string]bool{
+	"/events":    true,
+	"/events/ws": true,
+}
+
+type queryTokenKey struct{}
+
+// allowQueryToken marks r as sent to a route of queryTokenRoutes.
+func allowQueryToken(r *http.Request) *http.Request {
+	return r.WithContext(ct.WithValue(r.Context(), queryTokenKey{}, true))
+}
+
+// requestURI returns the URI of r without its access_token, for the logs and
+// the audit log.
+func requestURI(r *http.Request) string {
+	q := r.URL.Query()
+	if _, ok := q["access_token"]; !ok {
+		return r.RequestURI
+	}
+	q.Del("access_token")
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
+
+// tokenAuthenticator identifies callers by a static bearer token.
+type tokenAuthenticator struct {
+	tokens     [][]byte
+	identities []*Identity
+}
+
+// Authenticate reads the token from the Authorization header, or from the
+// access_token query parameter on the routes of queryTokenRoutes.
+func (a *tokenAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	var token []byte
+	if header := r.Header.Get("Authorization"); len(header) > 0 {
+		if !strings.HasPrefix(header, "Bearer ") {
+			return nil, fmt.Errorf("unsupported authorization scheme")
+		}
+		token = []byte(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	} else if t := r.URL.Query().Get("access_token"); len(t) > 0 {
+		if allowed, _ := r.Context().Value(queryTokenKey{}).(bool); !allowed {
+			return nil, fmt.Errorf("access_token is only accepted by the event streams, use the Authorization header")
+		}
+		token = []byte(t)
+	} else {
+		return nil, nil
+	}
+
+	var found *Identity
+	for i, t := range a.tokens {
+		// compare every token to not leak which one matched through timing
+		if subtle.ConstantTimeCompare(t, token) == 1 {
+			found = a.identities[i]
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return found, nil
+}
+
+// certAuthenticator identifies callers by the common name of their verified
+// client certificate. It only applies when the server requests client
+// certificates (mutual TLS).
+type certAuthenticator struct {
+	roles map[string]Role
+}
+
+func (a *certAuthenticator) Authenticate(r *http.Request) (*Identity, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.PeerCertificates) == 0 {
+		return nil, nil
+	}
+	cn := r.TLS.PeerCertificates[0].Subject.CommonName
+	role, ok := a.roles[cn]
+	if !ok {
+		// let the caller authenticate with a token instead
+		return nil, nil
+	}
+	return &Identity{Name: cn, Role: role, Method: "cert"}, nil
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testAuthenticators(t *testing.T) []Authenticator {
+	f, err := ioutil.TempFile("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"tokens": {
+		"viewer-token": {"name": "dashboard", "role": "viewer"},
+		"deployer-token": {"name": "ci", "role": "deployer"},
+		"admin-token": {"name": "ops", "role": "admin"}
+	}}`)
+	f.Close()
+
+	authenticators, err := LoadAuthenticators(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authenticators
+}
+
+func TestAuthenticate(t *testing.T) {
+	authenticators := testAuthenticators(t)
+
+	tests := []struct {
+		name       string
+		header     string
+		uri        string
+		queryToken bool // sent to a route of queryTokenRoutes
+		identity   *Identity
+		err        string
+	}{
+		{"unknown token", "Bearer ci-token", "/services", false, nil, "invalid token"},
+		{"deployer", "Bearer deployer-token", "/services", false, &Identity{Name: "ci", Role: RoleDeployer, Method: "token"}, ""},
+		{"other scheme", "Basic Y2k6Y2k=", "/services", false, nil, "unsupported authorization scheme"},
+		{"no credentials", "", "/services", false, nil, "authentication required"},
+		{"query token", "", "/events?access_token=viewer-token", true, &Identity{Name: "dashboard", Role: RoleViewer, Method: "token"}, ""},
+		{"query token of another route", "", "/services?access_token=viewer-token", false, nil, "access_token is only accepted by the event streams"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.uri, nil)
+		if len(test.header) > 0 {
+			r.Header.Set("Authorization", test.header)
+		}
+		if test.queryToken {
+			r = allowQueryToken(r)
+		}
+
+		id, err := authenticate(authenticators, r)
+		if len(test.err) > 0 {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(id, test.identity) {
+			t.Errorf("%s: got %+v, want %+v", test.name, id, test.identity)
+		}
+	}
+
+	if id, err := authenticate(nil, httptest.NewRequest(http.MethodGet, "/services", nil)); err != nil || id != anonymous {
+		t.Errorf("no authenticators: got %+v, %v, want the anonymous admin", id, err)
+	}
+}
+
+func TestRouteRoles(t *testing.T) {
+	client := newClusterTestClient()
+	client.addService(testServiceSpec("web", "nginx", nil))
+	router, err := NewPrimary(client, nil, false, &PrimaryOptions{Authenticators: testAuthenticators(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		uri    string
+		token  string
+		status int
+	}{
+		{"anonymous read", http.MethodGet, "/services", "", http.StatusUnauthorized},
+		{"invalid token", http.MethodGet, "/services", "other-token", http.StatusUnauthorized},
+		{"viewer read", http.MethodGet, "/services", "viewer-token", http.StatusOK},
+		{"viewer change", http.MethodDelete, "/services/web", "viewer-token", http.StatusForbidden},
+		{"deployer node change", http.MethodDelete, "/nodes/node1", "deployer-token", http.StatusForbidden},
+		{"deployer cluster change", http.MethodPost, "/clusters/default/update", "deployer-token", http.StatusForbidden},
+		{"deployer change", http.MethodDelete, "/services/web", "deployer-token", http.StatusOK},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.uri, nil)
+		if len(test.token) > 0 {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: %s %s got status %d, want %d: %s", test.name, test.method, test.uri, w.Code, test.status, w.Body.String())
+		}
+		if test.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: missing WWW-Authenticate header", test.name)
+		}
+	}
+
+	// only the last request reached the manager
+	if changes := client.changes(); !reflect.DeepEqual(changes, []string{"RemoveService web"}) {
+		t.Errorf("got changes %v, want the removal of web by the deployer", changes)
+	}
+}
@@ -0,0 +1,229 @@
+package api
+
+import (
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/docker/swarmkit/api"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// constraint is a placement expression, key==value or key!=value.
+type constraint struct {
+	key   string
+	equal bool
+	value string
+}
+
+func parseConstraint(expr string) (*constraint, error) {
+	for _, op := range []string{"==", "!="} {
+		if parts := strings.SplitN(expr, op, 2); len(parts) == 2 {
+			return &constraint{
+				key:   strings.TrimSpace(parts[0]),
+				equal: op == "==",
+				value: strings.TrimSpace(parts[1]),
+			}, nil
+		}
+	}
+	return nil, grpc.Errorf(codes.InvalidArgument, "invalid constraint %q, must be key==value or key!=value", expr)
+}
+
+// match reports whether node satisfies the constraint. Keys are node.id,
+// node.hostname, node.role, node.labels.<label> and engine.labels.<label>.
+func (cs *constraint) match(node *api.Node) bool {
+	var (
+		value string
+		found = true
+	)
+	switch key := strings.ToLower(cs.key); {
+	case key == "node.id":
+		value = node.ID
+	case key == "node.hostname":
+		if node.Description != nil {
+			value = node.Description.Hostname
+		}
+	case key == "node.role":
+		value = "worker"
+		if node.ManagerStatus != nil {
+			value = "manager"
+		}
+	case strings.HasPrefix(key, "node.labels."):
+		value, found = node.Spec.Annotations.Labels[cs.key[len("node.labels."):]]
+	case strings.HasPrefix(key, "engine.labels."):
+		if node.Description != nil && node.Description.Engine != nil {
+			value, found = node.Description.Engine.Labels[cs.key[len("engine.labels."):]]
+		} else {
+			found = false
+		}
+	default:
+		found = false
+	}
+
+	if !found {
+		return !cs.equal
+	}
+	return strings.EqualFold(value, cs.value) == cs.equal
+}
+
+// matchNodes returns the ready and active nodes matching constraints, the
+// ones tasks can be scheduled on.
+func matchNodes(nodes []*api.Node, constraints []string) ([]*api.Node, error) {
+	exprs := []*constraint{}
+	for _, expr := range constraints {
+		cs, err := parseConstraint(expr)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, cs)
+	}
+
+	matching := []*api.Node{}
+nodes:
+	for _, n := range nodes {
+		if n.Status.State != api.NodeStatus_READY || n.Spec.Availability != api.NodeAvailabilityActive {
+			continue
+		}
+		for _, cs := range exprs {
+			if !cs.match(n) {
+				continue nodes
+			}
+		}
+		matching = append(matching, n)
+	}
+	return matching, nil
+}
+
+// nodeCapacity is the unreserved CPU and memory of a node.
+type nodeCapacity struct {
+	node        *api.Node
+	nanoCPUs    int64
+	memoryBytes int64
+}
+
+// eligibleNodes returns the capacity of the ready and active nodes matching
+// constraints. Reservations of the tasks of ignoreService are not counted,
+// since an update replaces them.
+func eligibleNodes(ctx ct.Context, c api.ControlClient, constraints []string, ignoreService string) ([]*nodeCapacity, error) {
+	nodes, err := c.ListNodes(ctx, &api.ListNodesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	matching, err := matchNodes(nodes.Nodes, constraints)
+	if err != nil {
+		return nil, err
+	}
+	capacities := make(map[string]*nodeCapacity)
+	eligible := []*nodeCapacity{}
+	for _, n := range matching {
+		capacity := &nodeCapacity{node: n}
+		if n.Description != nil && n.Description.Resources != nil {
+			capacity.nanoCPUs = n.Description.Resources.NanoCPUs
+			capacity.memoryBytes = n.Description.Resources.MemoryBytes
+		}
+		capacities[n.ID] = capacity
+		eligible = append(eligible, capacity)
+	}
+
+	tasks, err := c.ListTasks(ctx, &api.ListTasksRequest{})
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tasks.Tasks {
+		capacity, ok := capacities[t.NodeID]
+		if !ok || t.ServiceID == ignoreService || t.DesiredState > api.TaskStateRunning || t.Status.State > api.TaskStateRunning {
+			continue
+		}
+		if t.Spec.Resources != nil && t.Spec.Resources.Reservations != nil {
+			capacity.nanoCPUs -= t.Spec.Resources.Reservations.NanoCPUs
+			capacity.memoryBytes -= t.Spec.Resources.Reservations.MemoryBytes
+		}
+	}
+	return eligible, nil
+}
+
+func formatCPU(nanoCPUs int64) string {
+	return big.NewRat(nanoCPUs, 1e9).FloatString(2)
+}
+
+// checkResources fails when the reservations of spec exceed its limits, or
+// when no eligible node has enough unreserved CPU and memory for one task.
+// current is the spec being updated, nil on create; the cluster is only
+// checked when the reservations or constraints change.
+func checkResources(ctx ct.Context, c api.ControlClient, spec, current *api.ServiceSpec, serviceID string) error {
+	resources := spec.Task.Resources
+	if resources == nil || resources.Reservations == nil {
+		return nil
+	}
+	reserved := resources.Reservations
+	if limits := resources.Limits; limits != nil {
+		if limits.NanoCPUs > 0 && reserved.NanoCPUs > limits.NanoCPUs {
+			return grpc.Errorf(codes.InvalidArgument, "cpu reservation %s is over the cpu limit %s",
+				formatCPU(reserved.NanoCPUs), formatCPU(limits.NanoCPUs))
+		}
+		if limits.MemoryBytes > 0 && reserved.MemoryBytes > limits.MemoryBytes {
+			return grpc.Errorf(codes.InvalidArgument, "memory reservation %s is over the memory limit %s",
+				units.BytesSize(float64(reserved.MemoryBytes)), units.BytesSize(float64(limits.MemoryBytes)))
+		}
+	}
+	if reserved.NanoCPUs == 0 && reserved.MemoryBytes == 0 {
+		return nil
+	}
+
+	var constraints []string
+	if spec.Task.Placement != nil {
+		constraints = spec.Task.Placement.Constraints
+	}
+	if current != nil && current.Task.Resources != nil && reflect.DeepEqual(current.Task.Resources.Reservations, reserved) {
+		var currentConstraints []string
+		if current.Task.Placement != nil {
+			currentConstraints = current.Task.Placement.Constraints
+		}
+		if reflect.DeepEqual(currentConstraints, constraints) {
+			return nil
+		}
+	}
+
+	nodes, err := eligibleNodes(ctx, c, constraints, serviceID)
+	if err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return grpc.Errorf(codes.FailedPrecondition, "no ready and active node matches the placement constraints %v", constraints)
+	}
+
+	// the most available node is the one fitting the largest share of a task
+	var (
+		best      *nodeCapacity
+		bestScore float64
+	)
+	for _, n := range nodes {
+		if n.nanoCPUs >= reserved.NanoCPUs && n.memoryBytes >= reserved.MemoryBytes {
+			return nil
+		}
+		score := 1.0
+		if reserved.NanoCPUs > 0 {
+			score = float64(n.nanoCPUs) / float64(reserved.NanoCPUs)
+		}
+		if reserved.MemoryBytes > 0 {
+			if m := float64(n.memoryBytes) / float64(reserved.MemoryBytes); m < score {
+				score = m
+			}
+		}
+		if best == nil || score > bestScore {
+			best, bestScore = n, score
+		}
+	}
+
+	name := best.node.ID
+	if best.node.Description != nil && len(best.node.Description.Hostname) > 0 {
+		name = best.node.Description.Hostname
+	}
+	return grpc.Errorf(codes.FailedPrecondition,
+		"no node can reserve %s cpus and %s of memory: %d eligible nodes, the most available one (%s) has %s cpus and %s free",
+		formatCPU(reserved.NanoCPUs), units.BytesSize(float64(reserved.MemoryBytes)), len(nodes), name,
+		formatCPU(best.nanoCPUs), units.BytesSize(float64(best.memoryBytes)))
+}
@@ -0,0 +1,283 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/unrolled/render"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// clusterTestClient is an in-memory cluster answering the control calls of
+// the tests. Objects are named <name>-id and their version increases with
+// every update, as in the manager. The changes it receives are recorded.
+type clusterTestClient struct {
+	api.ControlClient
+
+	sync.Mutex
+	services []*api.Service
+	networks []*api.Network
+	nodes    []*api.Node
+	tasks    []*api.Task
+	calls    []string // "CreateService web", "RemoveNetwork back", ...
+}
+
+func newClusterTestClient() *clusterTestClient {
+	return &clusterTestClient{}
+}
+
+func testServiceSpec(name, image string, labels map[string]string) *api.ServiceSpec {
+	return &api.ServiceSpec{
+		Annotations: api.Annotations{Name: name, Labels: labels},
+		Mode:        &api.ServiceSpec_Replicated{Replicated: &api.ReplicatedService{Replicas: 1}},
+		Task: api.TaskSpec{
+			Runtime: &api.TaskSpec_Container{Container: &api.ContainerSpec{Image: image}},
+		},
+	}
+}
+
+// addService creates a service without recording the call.
+func (c *clusterTestClient) addService(spec *api.ServiceSpec) *api.Service {
+	c.Lock()
+	defer c.Unlock()
+	s := &api.Service{ID: spec.Annotations.Name + "-id", Spec: *spec.Copy()}
+	s.Meta.Version.Index = 1
+	c.services = append(c.services, s)
+	return s.Copy()
+}
+
+// addNetwork creates a network without recording the call.
+func (c *clusterTestClient) addNetwork(spec *api.NetworkSpec) *api.Network {
+	c.Lock()
+	defer c.Unlock()
+	n := &api.Network{ID: spec.Annotations.Name + "-id", Spec: *spec.Copy()}
+	n.Meta.Version.Index = 1
+	c.networks = append(c.networks, n)
+	return n.Copy()
+}
+
+// service returns a copy of the service named name, nil when it does not exist.
+func (c *clusterTestClient) service(name string) *api.Service {
+	c.Lock()
+	defer c.Unlock()
+	for _, s := range c.services {
+		if s.Spec.Annotations.Name == name {
+			return s.Copy()
+		}
+	}
+	return nil
+}
+
+func (c *clusterTestClient) changes() []string {
+	c.Lock()
+	defer c.Unlock()
+	return append([]string{}, c.calls...)
+}
+
+func matchNames(names []string, name string) bool {
+	if len(names) == 0 {
+		return true
+	}
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *clusterTestClient) ListNodes(ctx ct.Context, r *api.ListNodesRequest, opts ...grpc.CallOption) (*api.ListNodesResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	resp := &api.ListNodesResponse{}
+	for _, n := range c.nodes {
+		resp.Nodes = append(resp.Nodes, n.Copy())
+	}
+	return resp, nil
+}
+
+func (c *clusterTestClient) ListTasks(ctx ct.Context, r *api.ListTasksRequest, opts ...grpc.CallOption) (*api.ListTasksResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	resp := &api.ListTasksResponse{}
+	for _, t := range c.tasks {
+		if r.Filters != nil && len(r.Filters.ServiceIDs) > 0 && !matchNames(r.Filters.ServiceIDs, t.ServiceID) {
+			continue
+		}
+		resp.Tasks = append(resp.Tasks, t.Copy())
+	}
+	return resp, nil
+}
+
+func (c *clusterTestClient) GetService(ctx ct.Context, r *api.GetServiceRequest, opts ...grpc.CallOption) (*api.GetServiceResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	for _, s := range c.services {
+		if s.ID == r.ServiceID {
+			return &api.GetServiceResponse{Service: s.Copy()}, nil
+		}
+	}
+	return nil, grpc.Errorf(codes.NotFound, "service %s not found", r.ServiceID)
+}
+
+func (c *clusterTestClient) ListServices(ctx ct.Context, r *api.ListServicesRequest, opts ...grpc.CallOption) (*api.ListServicesResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	resp := &api.ListServicesResponse{}
+	for _, s := range c.services {
+		if r.Filters != nil && !matchNames(r.Filters.Names, s.Spec.Annotations.Name) {
+			continue
+		}
+		resp.Services = append(resp.Services, s.Copy())
+	}
+	return resp, nil
+}
+
+func (c *clusterTestClient) CreateService(ctx ct.Context, r *api.CreateServiceRequest, opts ...grpc.CallOption) (*api.CreateServiceResponse, error) {
+	name := r.Spec.Annotations.Name
+	if c.service(name) != nil {
+		return nil, grpc.Errorf(codes.AlreadyExists, "name conflicts with an existing object")
+	}
+	s := c.addService(r.Spec)
+	c.Lock()
+	c.calls = append(c.calls, "CreateService "+name)
+	c.Unlock()
+	return &api.CreateServiceResponse{Service: s}, nil
+}
+
+func (c *clusterTestClient) UpdateService(ctx ct.Context, r *api.UpdateServiceRequest, opts ...grpc.CallOption) (*api.UpdateServiceResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	for _, s := range c.services {
+		if s.ID != r.ServiceID {
+			continue
+		}
+		if r.ServiceVersion == nil || r.ServiceVersion.Index != s.Meta.Version.Index {
+			// the error of manager/state/store, without a grpc code
+			return nil, errors.New("update out of sequence")
+		}
+		s.PreviousSpec = s.Spec.Copy()
+		s.Spec = *r.Spec.Copy()
+		s.Meta.Version.Index++
+		c.calls = append(c.calls, "UpdateService "+s.Spec.Annotations.Name)
+		return &api.UpdateServiceResponse{Service: s.Copy()}, nil
+	}
+	return nil, grpc.Errorf(codes.NotFound, "service %s not found", r.ServiceID)
+}
+
+func (c *clusterTestClient) RemoveService(ctx ct.Context, r *api.RemoveServiceRequest, opts ...grpc.CallOption) (*api.RemoveServiceResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	for i, s := range c.services {
+		if s.ID == r.ServiceID {
+			c.services = append(c.services[:i], c.services[i+1:]...)
+			c.calls = append(c.calls, "RemoveService "+s.Spec.Annotations.Name)
+			return &api.RemoveServiceResponse{}, nil
+		}
+	}
+	return nil, grpc.Errorf(codes.NotFound, "service %s not found", r.ServiceID)
+}
+
+func (c *clusterTestClient) GetNetwork(ctx ct.Context, r *api.GetNetworkRequest, opts ...grpc.CallOption) (*api.GetNetworkResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	for _, n := range c.networks {
+		if n.ID == r.NetworkID {
+			return &api.GetNetworkResponse{Network: n.Copy()}, nil
+		}
+	}
+	return nil, grpc.Errorf(codes.NotFound, "network %s not found", r.NetworkID)
+}
+
+func (c *clusterTestClient) ListNetworks(ctx ct.Context, r *api.ListNetworksRequest, opts ...grpc.CallOption) (*api.ListNetworksResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	resp := &api.ListNetworksResponse{}
+	for _, n := range c.networks {
+		if r.Filters != nil && !matchNames(r.Filters.Names, n.Spec.Annotations.Name) {
+			continue
+		}
+		resp.Networks = append(resp.Networks, n.Copy())
+	}
+	return resp, nil
+}
+
+func (c *clusterTestClient) CreateNetwork(ctx ct.Context, r *api.CreateNetworkRequest, opts ...grpc.CallOption) (*api.CreateNetworkResponse, error) {
+	n := c.addNetwork(r.Spec)
+	c.Lock()
+	c.calls = append(c.calls, "CreateNetwork "+r.Spec.Annotations.Name)
+	c.Unlock()
+	return &api.CreateNetworkResponse{Network: n}, nil
+}
+
+func (c *clusterTestClient) RemoveNetwork(ctx ct.Context, r *api.RemoveNetworkRequest, opts ...grpc.CallOption) (*api.RemoveNetworkResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	for i, n := range c.networks {
+		if n.ID == r.NetworkID {
+			c.networks = append(c.networks[:i], c.networks[i+1:]...)
+			c.calls = append(c.calls, "RemoveNetwork "+n.Spec.Annotations.Name)
+			return &api.RemoveNetworkResponse{}, nil
+		}
+	}
+	return nil, grpc.Errorf(codes.NotFound, "network %s not found", r.NetworkID)
+}
+
+func (c *clusterTestClient) ListClusters(ctx ct.Context, r *api.ListClustersRequest, opts ...grpc.CallOption) (*api.ListClustersResponse, error) {
+	return &api.ListClustersResponse{}, nil
+}
+
+// setTasks replaces the tasks of a service by n tasks in state, from the
+// current spec of the service.
+func (c *clusterTestClient) setTasks(serviceID string, n int, state api.TaskState) {
+	c.Lock()
+	defer c.Unlock()
+	tasks := []*api.Task{}
+	for _, t := range c.tasks {
+		if t.ServiceID != serviceID {
+			tasks = append(tasks, t)
+		}
+	}
+	for _, s := range c.services {
+		if s.ID != serviceID {
+			continue
+		}
+		for i := 0; i < n; i++ {
+			tasks = append(tasks, &api.Task{
+				ID:                 fmt.Sprintf("%s.%d.%d", serviceID, i+1, s.Meta.Version.Index),
+				ServiceID:          serviceID,
+				ServiceAnnotations: s.Spec.Annotations,
+				Slot:               uint64(i + 1),
+				Spec:               *s.Spec.Task.Copy(),
+				DesiredState:       api.TaskStateRunning,
+				Status:             api.TaskStatus{State: state},
+			})
+		}
+	}
+	c.tasks = tasks
+}
+
+// newTestContext returns the context of the handlers for client.
+func newTestContext(client api.ControlClient) *context {
+	return &context{
+		swarmkitAPI: client,
+		render:      render.New(),
+		options:     &PrimaryOptions{},
+	}
+}
+
+// setTaskState changes the state of a task, as the agent reports it.
+func (c *clusterTestClient) setTaskState(id string, state api.TaskState) {
+	c.Lock()
+	defer c.Unlock()
+	for _, t := range c.tasks {
+		if t.ID == id {
+			t.Status.State = state
+			t.Meta.Version.Index++
+		}
+	}
+}
@@ -0,0 +1,532 @@
+package api
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/swarmkit/api"
+	"gopkg.in/yaml.v2"
+)
+
+// stackLabel is the label holding the stack of the services and networks
+// deployed from a compose file.
+const stackLabel = "com.docker.stack.namespace"
+
+// composeFile is the part of a compose file, version 2 or 3, that maps onto
+// swarmkit objects.
+type composeFile struct {
+	Version  string                     `yaml:"version"`
+	Services map[string]*composeService `yaml:"services"`
+	Networks map[string]*composeNetwork `yaml:"networks"`
+	Volumes  map[string]*composeVolume  `yaml:"volumes"`
+}
+
+type composeService struct {
+	Image           string          `yaml:"image"`
+	Command         shellCommand    `yaml:"command"`
+	Entrypoint      shellCommand    `yaml:"entrypoint"`
+	Environment     environment     `yaml:"environment"`
+	Labels          mappingList     `yaml:"labels"`
+	Ports           []string        `yaml:"ports"`
+	Networks        serviceNetworks `yaml:"networks"`
+	Volumes         []string        `yaml:"volumes"`
+	WorkingDir      string          `yaml:"working_dir"`
+	User            string          `yaml:"user"`
+	StopGracePeriod string          `yaml:"stop_grace_period"`
+	Restart         string          `yaml:"restart"`         // version 2
+	MemLimit        string          `yaml:"mem_limit"`       // version 2
+	MemReservation  string          `yaml:"mem_reservation"` // version 2
+	Deploy          composeDeploy   `yaml:"deploy"`          // version 3
+}
+
+type composeDeploy struct {
+	Mode      string      `yaml:"mode"`
+	Replicas  *uint64     `yaml:"replicas"`
+	Labels    mappingList `yaml:"labels"`
+	Resources struct {
+		Limits       composeResources `yaml:"limits"`
+		Reservations composeResources `yaml:"reservations"`
+	} `yaml:"resources"`
+	RestartPolicy struct {
+		Condition   string `yaml:"condition"`
+		Delay       string `yaml:"delay"`
+		MaxAttempts uint64 `yaml:"max_attempts"`
+		Window      string `yaml:"window"`
+	} `yaml:"restart_policy"`
+	Placement struct {
+		Constraints []string `yaml:"constraints"`
+	} `yaml:"placement"`
+	UpdateConfig struct {
+		Parallelism uint64 `yaml:"parallelism"`
+		Delay       string `yaml:"delay"`
+	} `yaml:"update_config"`
+}
+
+type composeResources struct {
+	CPUs   string `yaml:"cpus"`
+	Memory string `yaml:"memory"`
+}
+
+type composeNetwork struct {
+	Driver     string            `yaml:"driver"`
+	DriverOpts map[string]string `yaml:"driver_opts"`
+	Labels     mappingList       `yaml:"labels"`
+	External   composeExternal   `yaml:"external"`
+}
+
+type composeVolume struct {
+	Driver     string            `yaml:"driver"`
+	DriverOpts map[string]string `yaml:"driver_opts"`
+	Labels     mappingList       `yaml:"labels"`
+	External   composeExternal   `yaml:"external"`
+}
+
+// composeExternal is "external: true" or "external: {name: ...}".
+type composeExternal struct {
+	External bool
+	Name     string
+}
+
+func (ce *composeExternal) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&ce.External); err == nil {
+		return nil
+	}
+	var named struct {
+		Name string `yaml:"name"`
+	}
+	if err := unmarshal(&named); err != nil {
+		return err
+	}
+	ce.External, ce.Name = true, named.Name
+	return nil
+}
+
+// mappingList is a map or a list of key=value strings.
+type mappingList map[string]string
+
+func (ml *mappingList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	m := make(map[string]string)
+	if err := unmarshal(&m); err == nil {
+		*ml = m
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	for _, kv := range list {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+		m[parts[0]] = parts[1]
+	}
+	*ml = m
+	return nil
+}
+
+// list returns the sorted key=value strings of ml.
+func (ml mappingList) list() []string {
+	list := []string{}
+	for k, v := range ml {
+		list = append(list, k+"="+v)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// environment is the mappingList of the variables of a service. A variable
+// without value, which compose takes from the shell, is rejected: there is no
+// shell to resolve it from.
+type environment mappingList
+
+func (e *environment) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		for _, kv := range list {
+			if !strings.Contains(kv, "=") {
+				return fmt.Errorf("environment variable %s has no value", kv)
+			}
+		}
+	}
+	var ml mappingList
+	if err := unmarshal(&ml); err != nil {
+		return err
+	}
+	*e = environment(ml)
+	return nil
+}
+
+func (e environment) list() []string {
+	return mappingList(e).list()
+}
+
+// shellCommand is a list of arguments or a string split like a shell does.
+type shellCommand []string
+
+func (sc *shellCommand) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		args, err := splitCommand(s)
+		*sc = args
+		return err
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+	*sc = list
+	return nil
+}
+
+// splitCommand splits s on spaces outside of single or double quotes.
+func splitCommand(s string) ([]string, error) {
+	var (
+		args  []string
+		arg   []rune
+		quote rune
+		inArg bool
+	)
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg = append(arg, r)
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, string(arg))
+				arg, inArg = nil, false
+			}
+		default:
+			arg, inArg = append(arg, r), true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in command %q", s)
+	}
+	if inArg {
+		args = append(args, string(arg))
+	}
+	return args, nil
+}
+
+// serviceNetworks is a list of network names or a map of networks with
+// their aliases.
+type serviceNetworks map[string][]string
+
+func (sn *serviceNetworks) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	networks := make(map[string][]string)
+	var list []string
+	if err := unmarshal(&list); err == nil {
+		for _, name := range list {
+			networks[name] = nil
+		}
+		*sn = networks
+		return nil
+	}
+	var m map[string]*struct {
+		Aliases []string `yaml:"aliases"`
+	}
+	if err := unmarshal(&m); err != nil {
+		return err
+	}
+	for name, n := range m {
+		if n != nil {
+			networks[name] = n.Aliases
+		} else {
+			networks[name] = nil
+		}
+	}
+	*sn = networks
+	return nil
+}
+
+// parseCompose parses and checks a compose file.
+func parseCompose(b []byte) (*composeFile, error) {
+	f := &composeFile{}
+	if err := yaml.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("invalid compose file: %v", err)
+	}
+	if len(f.Version) > 0 && !strings.HasPrefix(f.Version, "2") && !strings.HasPrefix(f.Version, "3") {
+		return nil, fmt.Errorf("unsupported compose file version %s, must be 2 or 3", f.Version)
+	}
+	if len(f.Services) == 0 {
+		return nil, fmt.Errorf("compose file has no services")
+	}
+	for name, svc := range f.Services {
+		if svc == nil || len(strings.TrimSpace(svc.Image)) == 0 {
+			return nil, fmt.Errorf("service %s: image is required", name)
+		}
+		for network := range svc.Networks {
+			if _, ok := f.Networks[network]; !ok && network != "default" {
+				return nil, fmt.Errorf("service %s: network %s is not declared", name, network)
+			}
+		}
+	}
+	return f, nil
+}
+
+// networkName returns the swarm name of the network declared as name.
+func (f *composeFile) networkName(stack, name string) string {
+	if n := f.Networks[name]; n != nil && n.External.External {
+		if len(n.External.Name) > 0 {
+			return n.External.Name
+		}
+		return name
+	}
+	return stack + "_" + name
+}
+
+// networkSpecs returns the specs of the networks to create for the stack,
+// by swarm name: the declared ones that are not external and the default
+// network when a service uses it.
+func (f *composeFile) networkSpecs(stack string) map[string]*api.NetworkSpec {
+	used := make(map[string]bool)
+	for _, svc := range f.Services {
+		if len(svc.Networks) == 0 {
+			used["default"] = true
+		}
+		for name := range svc.Networks {
+			used[name] = true
+		}
+	}
+
+	specs := make(map[string]*api.NetworkSpec)
+	for name := range used {
+		n := f.Networks[name]
+		if n == nil {
+			n = &composeNetwork{}
+		}
+		if n.External.External {
+			continue
+		}
+		labels := map[string]string{stackLabel: stack}
+		for k, v := range n.Labels {
+			labels[k] = v
+		}
+		driver := n.Driver
+		if len(driver) == 0 {
+			driver = "overlay"
+		}
+		specs[f.networkName(stack, name)] = &api.NetworkSpec{
+			Annotations:  api.Annotations{Name: f.networkName(stack, name), Labels: labels},
+			DriverConfig: &api.Driver{Name: driver, Options: n.DriverOpts},
+		}
+	}
+	return specs
+}
+
+// createSpec translates the compose service name into the request creating
+// it in stack.
+func (f *composeFile) createSpec(stack, name string) (*createSpec, error) {
+	svc := f.Services[name]
+	deploy := svc.Deploy
+	cspec := &createSpec{
+		Name:              stack + "_" + name,
+		Image:             svc.Image,
+		Labels:            map[string]string{stackLabel: stack},
+		ContainerLabels:   map[string]string{stackLabel: stack},
+		Command:           svc.Entrypoint,
+		Args:              svc.Command,
+		Env:               svc.Environment.list(),
+		Dir:               svc.WorkingDir,
+		User:              svc.User,
+		StopGracePeriod:   svc.StopGracePeriod,
+		Mode:              deploy.Mode,
+		Replicas:          deploy.Replicas,
+		MemoryLimit:       svc.MemLimit,
+		MemoryReservation: svc.MemReservation,
+		Constraint:        deploy.Placement.Constraints,
+		UpdateParallelism: deploy.UpdateConfig.Parallelism,
+		UpdateDelay:       deploy.UpdateConfig.Delay,
+	}
+	for k, v := range deploy.Labels {
+		cspec.Labels[k] = v
+	}
+	for k, v := range svc.Labels {
+		cspec.ContainerLabels[k] = v
+	}
+	if len(cspec.Mode) == 0 {
+		cspec.Mode = "replicated"
+	}
+
+	// deploy.resources overrides the version 2 memory settings
+	resources := deploy.Resources
+	cspec.CPULimit, cspec.CPUReservation = resources.Limits.CPUs, resources.Reservations.CPUs
+	if len(resources.Limits.Memory) > 0 {
+		cspec.MemoryLimit = resources.Limits.Memory
+	}
+	if len(resources.Reservations.Memory) > 0 {
+		cspec.MemoryReservation = resources.Reservations.Memory
+	}
+
+	restart := deploy.RestartPolicy
+	condition := restart.Condition
+	if len(condition) == 0 {
+		condition = svc.Restart
+	}
+	switch condition {
+	case "":
+	case "any", "always", "unless-stopped":
+		cspec.RestartCondition = "any"
+	case "on-failure", "failure":
+		cspec.RestartCondition = "failure"
+	case "none", "no":
+		cspec.RestartCondition = "none"
+	default:
+		return nil, fmt.Errorf("service %s: invalid restart condition %s", name, condition)
+	}
+	cspec.RestartDelay, cspec.RestartMaxAttempts, cspec.RestartWindow = restart.Delay, restart.MaxAttempts, restart.Window
+
+	for _, p := range svc.Ports {
+		ports, err := composePorts(p)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %v", name, err)
+		}
+		cspec.Ports = append(cspec.Ports, ports...)
+	}
+
+	networks := svc.Networks
+	if len(networks) == 0 {
+		networks = serviceNetworks{"default": nil}
+	}
+	names := []string{}
+	for n := range networks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		cspec.Networks = append(cspec.Networks, networkSpec{Target: f.networkName(stack, n), Aliases: networks[n]})
+	}
+
+	for _, v := range svc.Volumes {
+		ms, err := f.composeMount(stack, v)
+		if err != nil {
+			return nil, fmt.Errorf("service %s: %v", name, err)
+		}
+		cspec.Mounts = append(cspec.Mounts, *ms)
+	}
+
+	return cspec, nil
+}
+
+// composePorts translates "[ip:][published:]target[/protocol]", where the
+// ports may be ranges, into the ports syntax of createSpec.
+func composePorts(s string) ([]string, error) {
+	spec, protocol := s, "tcp"
+	if i := strings.LastIndex(s, "/"); i >= 0 {
+		spec, protocol = s[:i], s[i+1:]
+	}
+	parts := strings.Split(spec, ":")
+	if len(parts) == 3 {
+		// the host ip has no meaning for the routing mesh
+		parts = parts[1:]
+	}
+	var published, target string
+	switch len(parts) {
+	case 1:
+		target = parts[0]
+	case 2:
+		published, target = parts[0], parts[1]
+	default:
+		return nil, fmt.Errorf("invalid port %q", s)
+	}
+
+	targetStart, targetEnd, err := portRange(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q: %v", s, err)
+	}
+	var publishedStart, publishedEnd uint64
+	if len(published) > 0 {
+		if publishedStart, publishedEnd, err = portRange(published); err != nil {
+			return nil, fmt.Errorf("invalid port %q: %v", s, err)
+		}
+		if publishedEnd-publishedStart != targetEnd-targetStart {
+			return nil, fmt.Errorf("invalid port %q: ranges must have the same size", s)
+		}
+	}
+
+	ports := []string{}
+	for i := uint64(0); i <= targetEnd-targetStart; i++ {
+		port := fmt.Sprintf(":%d/%s", targetStart+i, protocol)
+		if len(published) > 0 {
+			port += fmt.Sprintf(":%d/%s", publishedStart+i, protocol)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
+
+func portRange(s string) (uint64, uint64, error) {
+	parts := strings.SplitN(s, "-", 2)
+	start, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, 0, err
+	}
+	end := start
+	if len(parts) == 2 {
+		if end, err = strconv.ParseUint(parts[1], 10, 16); err != nil {
+			return 0, 0, err
+		}
+		if end < start {
+			return 0, 0, fmt.Errorf("invalid range %s", s)
+		}
+	}
+	return start, end, nil
+}
+
+// composeMount translates "[source:]target[:mode]". Sources that are not
+// absolute paths are volumes, declared in the volumes of the file and
+// prefixed by the stack unless external.
+func (f *composeFile) composeMount(stack, s string) (*mountSpec, error) {
+	parts := strings.Split(s, ":")
+	ms := &mountSpec{}
+	if n := len(parts); n > 1 && (parts[n-1] == "ro" || parts[n-1] == "rw") {
+		ms.ReadOnly = parts[n-1] == "ro"
+		parts = parts[:n-1]
+	}
+	switch len(parts) {
+	case 1:
+		ms.Type, ms.Target = "volume", parts[0]
+		return ms, nil
+	case 2:
+		ms.Source, ms.Target = parts[0], parts[1]
+	default:
+		return nil, fmt.Errorf("invalid volume %q", s)
+	}
+
+	switch {
+	case path.IsAbs(ms.Source):
+		ms.Type = "bind"
+	case strings.HasPrefix(ms.Source, ".") || strings.HasPrefix(ms.Source, "~"):
+		return nil, fmt.Errorf("volume %q: relative bind sources cannot be resolved on the nodes, use an absolute path", s)
+	default:
+		ms.Type = "volume"
+		v, ok := f.Volumes[ms.Source]
+		if !ok {
+			return nil, fmt.Errorf("volume %q: volume %s is not declared", s, ms.Source)
+		}
+		if v == nil {
+			v = &composeVolume{}
+		}
+		if v.External.External {
+			if len(v.External.Name) > 0 {
+				ms.Source = v.External.Name
+			}
+			return ms, nil
+		}
+		ms.Source = stack + "_" + ms.Source
+		ms.VolumeDriver, ms.VolumeOpts = v.Driver, v.DriverOpts
+		ms.VolumeLabels = map[string]string{stackLabel: stack}
+		for k, val := range v.Labels {
+			ms.VolumeLabels[k] = val
+		}
+	}
+	return ms, nil
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/swarmkit/api"
+	"gopkg.in/yaml.v2"
+)
+
+func TestComposePorts(t *testing.T) {
+	tests := []struct {
+		port  string
+		ports []string
+		err   bool
+	}{
+		{port: "80", ports: []string{":80/tcp"}},
+		{port: "8080:80", ports: []string{":80/tcp:8080/tcp"}},
+		{port: "53:53/udp", ports: []string{":53/udp:53/udp"}},
+		{port: "127.0.0.1:8080:80", ports: []string{":80/tcp:8080/tcp"}},
+		{port: "127.0.0.1:5353:53/udp", ports: []string{":53/udp:5353/udp"}},
+		{port: "3000-3002", ports: []string{":3000/tcp", ":3001/tcp", ":3002/tcp"}},
+		{port: "9000-9001:3000-3001/udp", ports: []string{":3000/udp:9000/udp", ":3001/udp:9001/udp"}},
+		{port: "9000-9002:3000-3001", err: true},
+		{port: "3001-3000", err: true},
+		{port: "a:b:c:d", err: true},
+		{port: "http", err: true},
+	}
+
+	for _, test := range tests {
+		ports, err := composePorts(test.port)
+		if test.err {
+			if err == nil {
+				t.Errorf("composePorts(%q) = %v, want an error", test.port, ports)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("composePorts(%q) error: %v", test.port, err)
+			continue
+		}
+		if !reflect.DeepEqual(ports, test.ports) {
+			t.Errorf("composePorts(%q) = %v, want %v", test.port, ports, test.ports)
+		}
+		for _, p := range ports {
+			if _, _, _, _, err := parsePortConfig(p); err != nil {
+				t.Errorf("composePorts(%q): parsePortConfig(%q) error: %v", test.port, p, err)
+			}
+		}
+	}
+}
+
+func TestComposePortsProtocol(t *testing.T) {
+	ports, err := composePorts("53:53/udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, protocol, port, published, err := parsePortConfig(ports[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if protocol != api.ProtocolUDP || port != 53 || published != 53 {
+		t.Errorf("parsePortConfig(%q) = %v %d %d, want udp 53 53", ports[0], protocol, port, published)
+	}
+}
+
+func TestComposeEnvironment(t *testing.T) {
+	var svc composeService
+	if err := yaml.Unmarshal([]byte("environment: [FOO=bar, BAZ=]"), &svc); err != nil {
+		t.Fatal(err)
+	}
+	if env, want := svc.Environment.list(), []string{"BAZ=", "FOO=bar"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("environment = %v, want %v", env, want)
+	}
+
+	if err := yaml.Unmarshal([]byte("environment: [FOO]"), &svc); err == nil {
+		t.Error("environment without value: want an error")
+	}
+}
@@ -0,0 +1,529 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/swarmkit/api"
+	"github.com/shenshouer/swarmkit-client/swarmkit"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Labels of the services created by a deployment.
+const (
+	deploymentLabel     = "com.swarmkit-client.deployment"      // deployment ID
+	deploymentRoleLabel = "com.swarmkit-client.deployment.role" // canary or green
+)
+
+// Strategies of a deployment.
+const (
+	strategyCanary    = "canary"
+	strategyBlueGreen = "blue-green"
+)
+
+// State of a deployment.
+const (
+	deploymentDeploying = "deploying" // the candidate service is created and checked
+	deploymentWaiting   = "waiting"   // the candidate is healthy, waiting to be promoted or aborted
+	deploymentPromoting = "promoting"
+	deploymentCompleted = "completed"
+	deploymentAborted   = "aborted"
+	deploymentFailed    = "failed"
+)
+
+// deployment releases a new version of a service through a candidate
+// service. A canary runs the new spec with a few replicas next to the
+// service, a blue-green candidate runs every replica without serving. Once
+// the candidate is verified the service is updated in place, so it keeps its
+// ID, its name and its published ports, and the candidate is removed.
+type deployment struct {
+	ID              string      `json:"id"`
+	Service         string      `json:"service"`  // name or ID given on create
+	Strategy        string      `json:"strategy"` // canary or blue-green
+	Spec            *createSpec `json:"spec"`     // changes of the new version, as for an update
+	CanaryReplicas  uint64      `json:"canary_replicas,omitempty"`
+	HealthTimeout   string      `json:"health_timeout"` // time for the candidate tasks to run
+	StablePeriod    string      `json:"stable_period"`  // time they must then run without failing
+	MaxFailureRatio float64     `json:"max_failure_ratio"`
+	AutoPromote     bool        `json:"auto_promote"`
+
+	ServiceID      string            `json:"service_id"`
+	ServiceName    string            `json:"service_name"`
+	ServiceVersion uint64            `json:"service_version"` // version of the service the deployment started from
+	CandidateID    string            `json:"candidate_id,omitempty"`
+	CandidateName  string            `json:"candidate_name"`
+	State          string            `json:"state"`
+	Message        string            `json:"message,omitempty"`
+	Progress       *rolloutProgress  `json:"progress,omitempty"` // of the candidate, then of the promotion
+	History        []*deploymentStep `json:"history"`
+	User           string            `json:"user"`
+	CreatedAt      time.Time         `json:"created_at"`
+	UpdatedAt      time.Time         `json:"updated_at"`
+}
+
+// deploymentStep is a state change of a deployment.
+type deploymentStep struct {
+	Time    time.Time `json:"time"`
+	State   string    `json:"state"`
+	Message string    `json:"message,omitempty"`
+}
+
+func (d *deployment) done() bool {
+	return d.State == deploymentCompleted || d.State == deploymentAborted || d.State == deploymentFailed
+}
+
+// deploymentRun is a deployment in progress.
+type deploymentRun struct {
+	d        *deployment
+	previous *api.Service     // the service when the deployment started
+	spec     *api.ServiceSpec // the new spec of the service
+	user     *Identity
+	promote  chan struct{}
+	cancel   ct.CancelFunc
+}
+
+// deploymentManager runs the deployments and keeps them, persisted in a json
+// file of the data directory when there is one.
+type deploymentManager struct {
+	c       *context
+	path    string
+	maxKept int           // finished deployments kept, 0 = unlimited
+	maxAge  time.Duration // age after which a finished deployment is forgotten, 0 = never
+
+	sync.RWMutex
+	runs map[string]*deploymentRun
+}
+
+func newDeploymentManager(c *context, dataDir string, maxKept int, maxAge time.Duration) (*deploymentManager, error) {
+	m := &deploymentManager{c: c, maxKept: maxKept, maxAge: maxAge, runs: make(map[string]*deploymentRun)}
+	if len(dataDir) == 0 {
+		return m, nil
+	}
+	m.path = filepath.Join(dataDir, "deployments.json")
+
+	b, err := ioutil.ReadFile(m.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		deployments := []*deployment{}
+		if err = json.Unmarshal(b, &deployments); err != nil {
+			return nil, fmt.Errorf("parse %s: %v", m.path, err)
+		}
+		for _, d := range deployments {
+			if !d.done() {
+				// the run is lost with the process, the candidate is left for the operator
+				d.step(deploymentFailed, fmt.Sprintf("interrupted by a restart, the candidate service %s was left in place", d.CandidateName))
+			}
+			m.runs[d.ID] = &deploymentRun{d: d}
+		}
+		m.prune()
+	}
+	return m, nil
+}
+
+// prune forgets the finished deployments older than maxAge and the oldest
+// finished ones over maxKept. The caller holds the lock.
+func (m *deploymentManager) prune() {
+	done := []*deployment{}
+	for _, dr := range m.runs {
+		if dr.d.done() {
+			done = append(done, dr.d)
+		}
+	}
+	sort.Sort(deploymentsByAge(done))
+	for i, d := range done {
+		if (m.maxKept > 0 && i >= m.maxKept) || (m.maxAge > 0 && time.Since(d.UpdatedAt) > m.maxAge) {
+			delete(m.runs, d.ID)
+		}
+	}
+}
+
+// save prunes the deployments, then writes them to the json file. The caller
+// holds the lock.
+func (m *deploymentManager) save() error {
+	m.prune()
+	if len(m.path) == 0 {
+		return nil
+	}
+	deployments := make([]*deployment, 0, len(m.runs))
+	for _, dr := range m.runs {
+		deployments = append(deployments, dr.d)
+	}
+	b, err := json.MarshalIndent(deployments, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.path, b, 0600)
+}
+
+func (d *deployment) step(state, message string) {
+	d.State, d.Message, d.UpdatedAt = state, message, time.Now().UTC()
+	d.History = append(d.History, &deploymentStep{Time: d.UpdatedAt, State: state, Message: message})
+}
+
+// update changes the deployment of dr with fn and saves it.
+func (m *deploymentManager) update(dr *deploymentRun, fn func(d *deployment)) {
+	m.Lock()
+	defer m.Unlock()
+	fn(dr.d)
+	if err := m.save(); err != nil {
+		log.WithField("deployment", dr.d.ID).Errorf("Save deployments error: %v", err)
+	}
+}
+
+func (m *deploymentManager) setState(dr *deploymentRun, state, message string) {
+	m.update(dr, func(d *deployment) { d.step(state, message) })
+	log.WithFields(log.Fields{"deployment": dr.d.ID, "service": dr.d.ServiceName, "state": state}).Info(message)
+}
+
+func validateDeployment(d *deployment) error {
+	switch d.Strategy {
+	case strategyCanary:
+		if d.CanaryReplicas == 0 {
+			return errors.New("canary_replicas must be at least 1")
+		}
+	case strategyBlueGreen:
+		d.CanaryReplicas = 0
+	default:
+		return fmt.Errorf("invalid strategy %q, must be canary or blue-green", d.Strategy)
+	}
+	if len(d.Service) == 0 {
+		return errors.New("service is required")
+	}
+	if d.Spec == nil {
+		return errors.New("spec is required")
+	}
+	if v, err := time.ParseDuration(d.HealthTimeout); err != nil || v <= 0 {
+		return fmt.Errorf("invalid health_timeout %q", d.HealthTimeout)
+	}
+	if v, err := time.ParseDuration(d.StablePeriod); err != nil || v < 0 {
+		return fmt.Errorf("invalid stable_period %q", d.StablePeriod)
+	}
+	if d.MaxFailureRatio < 0 || d.MaxFailureRatio > 1 {
+		return errors.New("max_failure_ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// create resolves the service and the new spec of d, then starts it.
+func (m *deploymentManager) create(r *http.Request, d *deployment) error {
+	if err := validateDeployment(d); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "%v", err)
+	}
+	ctx := r.Context()
+	client := m.c.swarmkitAPI
+
+	service, err := swarmkit.GetService(ctx, client, d.Service)
+	if err != nil {
+		return err
+	}
+	spec := service.Spec.Copy()
+	if err = merge(ctx, d.Spec, spec, client); err == nil {
+		err = checkResources(ctx, client, spec, &service.Spec, service.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if spec.Annotations.Name != service.Spec.Annotations.Name {
+		return grpc.Errorf(codes.InvalidArgument, "a deployment cannot rename the service")
+	}
+	if reflect.DeepEqual(spec, &service.Spec) {
+		return grpc.Errorf(codes.InvalidArgument, "no changes detected")
+	}
+
+	id := requestIdentity(r)
+	d.ID = newID()
+	d.ServiceID, d.ServiceName, d.ServiceVersion = service.ID, service.Spec.Annotations.Name, service.Meta.Version.Index
+	role := "canary"
+	if d.Strategy == strategyBlueGreen {
+		role = "green"
+	}
+	d.CandidateID, d.CandidateName = "", fmt.Sprintf("%s-%s-%s", d.ServiceName, role, d.ID[:8])
+	d.Progress, d.History, d.User = nil, []*deploymentStep{}, id.Name
+	d.CreatedAt = time.Now().UTC()
+	d.step(deploymentDeploying, "creating the candidate service")
+
+	runCtx, cancel := ct.WithCancel(ct.Background())
+	dr := &deploymentRun{
+		d:        d,
+		previous: service,
+		spec:     spec,
+		user:     id,
+		promote:  make(chan struct{}, 1),
+		cancel:   cancel,
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	for _, other := range m.runs {
+		if other.d.ServiceID == d.ServiceID && !other.d.done() {
+			cancel()
+			return grpc.Errorf(codes.FailedPrecondition, "deployment %s of service %s is %s", other.d.ID, d.ServiceName, other.d.State)
+		}
+	}
+	m.runs[d.ID] = dr
+	if err := m.save(); err != nil {
+		delete(m.runs, d.ID)
+		cancel()
+		return err
+	}
+	go m.run(runCtx, dr)
+	return nil
+}
+
+// get returns a copy of the deployment with id.
+func (m *deploymentManager) get(id string) (*deployment, error) {
+	m.RLock()
+	defer m.RUnlock()
+	dr, ok := m.runs[id]
+	if !ok {
+		return nil, notFound("deployment", id)
+	}
+	d := *dr.d
+	d.History = append([]*deploymentStep{}, d.History...)
+	return &d, nil
+}
+
+// list returns the deployments of service, every deployment when empty,
+// newest first.
+func (m *deploymentManager) list(service string) []*deployment {
+	m.RLock()
+	defer m.RUnlock()
+	deployments := []*deployment{}
+	for _, dr := range m.runs {
+		if len(service) == 0 || dr.d.ServiceID == service || dr.d.ServiceName == service {
+			d := *dr.d
+			d.History = append([]*deploymentStep{}, d.History...)
+			deployments = append(deployments, &d)
+		}
+	}
+	sort.Sort(deploymentsByAge(deployments))
+	return deployments
+}
+
+type deploymentsByAge []*deployment
+
+func (ds deploymentsByAge) Len() int           { return len(ds) }
+func (ds deploymentsByAge) Less(i, j int) bool { return ds[i].CreatedAt.After(ds[j].CreatedAt) }
+func (ds deploymentsByAge) Swap(i, j int)      { ds[i], ds[j] = ds[j], ds[i] }
+
+// promote promotes a deployment waiting for it.
+func (m *deploymentManager) promote(id string) error {
+	m.Lock()
+	defer m.Unlock()
+	dr, ok := m.runs[id]
+	if !ok {
+		return notFound("deployment", id)
+	}
+	if dr.d.State != deploymentWaiting {
+		return grpc.Errorf(codes.FailedPrecondition, "deployment %s is %s, only a waiting deployment can be promoted", id, dr.d.State)
+	}
+	select {
+	case dr.promote <- struct{}{}:
+	default:
+		// already promoted
+	}
+	return nil
+}
+
+// abort stops a deployment before its promotion, its candidate is removed.
+func (m *deploymentManager) abort(id string) error {
+	m.Lock()
+	defer m.Unlock()
+	dr, ok := m.runs[id]
+	if !ok {
+		return notFound("deployment", id)
+	}
+	if dr.d.State != deploymentDeploying && dr.d.State != deploymentWaiting {
+		return grpc.Errorf(codes.FailedPrecondition, "deployment %s is %s, it can no longer be aborted", id, dr.d.State)
+	}
+	dr.cancel()
+	return nil
+}
+
+// run deploys the candidate, waits for the promotion and promotes it.
+func (m *deploymentManager) run(ctx ct.Context, dr *deploymentRun) {
+	d := dr.d
+	if err := m.deployCandidate(ctx, dr); err != nil {
+		m.finish(ctx, dr, err)
+		return
+	}
+
+	if !d.AutoPromote {
+		m.setState(dr, deploymentWaiting, "the candidate is healthy, waiting to be promoted or aborted")
+		select {
+		case <-dr.promote:
+		case <-ctx.Done():
+			m.finish(ctx, dr, ctx.Err())
+			return
+		}
+	}
+
+	m.Lock()
+	if ctx.Err() != nil {
+		// aborted just before the promotion
+		m.Unlock()
+		m.finish(ctx, dr, ctx.Err())
+		return
+	}
+	d.step(deploymentPromoting, "")
+	if err := m.save(); err != nil {
+		log.WithField("deployment", d.ID).Errorf("Save deployments error: %v", err)
+	}
+	m.Unlock()
+
+	m.finish(ctx, dr, m.promoteService(dr))
+}
+
+// finish records the outcome of a deployment and removes the candidate. A
+// deployment is aborted when ctx was canceled, whatever the error wrapping
+// the cancellation.
+func (m *deploymentManager) finish(ctx ct.Context, dr *deploymentRun, err error) {
+	d := dr.d
+
+	state, message := deploymentCompleted, ""
+	switch {
+	case err != nil && ctx.Err() == ct.Canceled:
+		state, message = deploymentAborted, "aborted"
+	case err != nil:
+		state, message = deploymentFailed, err.Error()
+	}
+	dr.cancel()
+
+	if len(d.CandidateID) > 0 {
+		_, rerr := m.c.swarmkitAPI.RemoveService(ct.Background(), &api.RemoveServiceRequest{ServiceID: d.CandidateID})
+		if rerr != nil {
+			message += fmt.Sprintf(", remove candidate %s error: %v", d.CandidateName, rerr)
+		} else {
+			forgetRevisions(m.c, d.CandidateID)
+		}
+	}
+	m.setState(dr, state, message)
+}
+
+// candidateSpec returns the spec of the candidate service: the new spec under
+// another name, without published ports. A canary runs its replicas and
+// answers to the name of the service on its networks.
+func candidateSpec(d *deployment, spec *api.ServiceSpec) *api.ServiceSpec {
+	candidate := spec.Copy()
+	candidate.Annotations.Name = d.CandidateName
+	labels := map[string]string{}
+	for k, v := range spec.Annotations.Labels {
+		// the candidate must not be pruned by apply, sync or a stack deploy
+		if k != managedLabel && k != stackLabel {
+			labels[k] = v
+		}
+	}
+	labels[deploymentLabel] = d.ID
+	labels[deploymentRoleLabel] = "green"
+	if d.Strategy == strategyCanary {
+		labels[deploymentRoleLabel] = "canary"
+	}
+	candidate.Annotations.Labels = labels
+
+	if candidate.Endpoint != nil {
+		candidate.Endpoint.Ports = nil
+	}
+	if d.Strategy == strategyCanary {
+		candidate.Mode = &api.ServiceSpec_Replicated{
+			Replicated: &api.ReplicatedService{Replicas: d.CanaryReplicas},
+		}
+		for _, a := range candidate.Networks {
+			a.Aliases = append(a.Aliases, d.ServiceName)
+		}
+	}
+	return candidate
+}
+
+func (m *deploymentManager) reportProgress(dr *deploymentRun) func(*rolloutProgress) {
+	return func(p *rolloutProgress) {
+		m.update(dr, func(d *deployment) { d.Progress = p })
+	}
+}
+
+// deployCandidate creates the candidate service and waits until its tasks
+// run, then checks they kept running for the stable period.
+func (m *deploymentManager) deployCandidate(ctx ct.Context, dr *deploymentRun) error {
+	d, client := dr.d, m.c.swarmkitAPI
+	healthTimeout, _ := time.ParseDuration(d.HealthTimeout)
+	stablePeriod, _ := time.ParseDuration(d.StablePeriod)
+
+	created := time.Now()
+	resp, err := client.CreateService(ctx, &api.CreateServiceRequest{Spec: candidateSpec(d, dr.spec)})
+	if err != nil {
+		return fmt.Errorf("create candidate %s: %v", d.CandidateName, err)
+	}
+	m.update(dr, func(d *deployment) { d.CandidateID = resp.Service.ID })
+	m.setState(dr, deploymentDeploying, fmt.Sprintf("waiting for the tasks of %s", d.CandidateName))
+
+	opts := &rolloutOptions{TaskTimeout: healthTimeout, Timeout: healthTimeout, MaxFailureRatio: d.MaxFailureRatio}
+	if result := monitorRollout(ctx, client, resp.Service, opts, m.reportProgress(dr)); result.State != rolloutCompleted {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("candidate %s is unhealthy: %s", d.CandidateName, result.Message)
+	}
+
+	if stablePeriod > 0 {
+		m.setState(dr, deploymentDeploying, fmt.Sprintf("checking that %s stays healthy for %s", d.CandidateName, stablePeriod))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(stablePeriod):
+		}
+		p, err := rolloutStatus(ctx, client, resp.Service, created)
+		if err != nil {
+			return err
+		}
+		m.update(dr, func(d *deployment) { d.Progress = p })
+		if p.Updated < p.Desired || (p.Desired > 0 && float64(p.Failed)/float64(p.Desired) > d.MaxFailureRatio) {
+			return fmt.Errorf("candidate %s did not stay healthy: %d of %d tasks running, %d failed: %s",
+				d.CandidateName, p.Updated, p.Desired, p.Failed, p.Message)
+		}
+	}
+	return nil
+}
+
+// promoteService updates the service in place to the new spec and waits for
+// its rollout, rolled back on failure. The service keeps its published ports,
+// so they are served throughout. The update fails if the service changed
+// since the deployment started.
+func (m *deploymentManager) promoteService(dr *deploymentRun) error {
+	d, client := dr.d, m.c.swarmkitAPI
+	opts := &rolloutOptions{TaskTimeout: defaultTaskTimeout, MaxFailureRatio: d.MaxFailureRatio}
+	ctx, cancel := rolloutContext(ct.Background(), client, dr.spec, opts)
+	defer cancel()
+	r := backgroundRequest(ctx, dr.user)
+
+	resp, err := client.UpdateService(ctx, &api.UpdateServiceRequest{
+		ServiceID:      d.ServiceID,
+		ServiceVersion: &dr.previous.Meta.Version,
+		Spec:           dr.spec,
+	})
+	if err != nil {
+		return fmt.Errorf("update %s: %v", d.ServiceName, err)
+	}
+	recordRevision(m.c, r, resp.Service, dr.previous, "deployment", 0)
+	m.setState(dr, deploymentPromoting, fmt.Sprintf("waiting for the rollout of %s", d.ServiceName))
+
+	if result := monitorRollout(ctx, client, resp.Service, opts, m.reportProgress(dr)); result.State != rolloutCompleted {
+		if err := rollbackRollout(ctx, m.c, r, dr.previous); err != nil {
+			return fmt.Errorf("rollout of %s failed: %s, rollback error: %v", d.ServiceName, result.Message, err)
+		}
+		return fmt.Errorf("rollout of %s failed: %s, the previous spec was re-applied", d.ServiceName, result.Message)
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/swarmkit/api"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// deploymentTestClient starts the tasks of the services it creates, and
+// sets the tasks of the services it updates to state.
+type deploymentTestClient struct {
+	*clusterTestClient
+	state api.TaskState
+}
+
+func (c *deploymentTestClient) CreateService(ctx ct.Context, r *api.CreateServiceRequest, opts ...grpc.CallOption) (*api.CreateServiceResponse, error) {
+	resp, err := c.clusterTestClient.CreateService(ctx, r, opts...)
+	if err == nil {
+		c.setTasks(resp.Service.ID, int(resp.Service.Spec.GetReplicated().Replicas), api.TaskStateRunning)
+	}
+	return resp, err
+}
+
+func (c *deploymentTestClient) UpdateService(ctx ct.Context, r *api.UpdateServiceRequest, opts ...grpc.CallOption) (*api.UpdateServiceResponse, error) {
+	resp, err := c.clusterTestClient.UpdateService(ctx, r, opts...)
+	if err == nil {
+		states := make([]api.TaskState, resp.Service.Spec.GetReplicated().Replicas)
+		for i := range states {
+			states[i] = c.state
+		}
+		// failed after the start of the rollout
+		setTaskStates(c.clusterTestClient, resp.Service, time.Now().Add(time.Hour), states...)
+	}
+	return resp, err
+}
+
+// newTestDeployment adds to m a deployment of web to nginx:2, web running
+// two replicas of nginx:1.
+func newTestDeployment(m *deploymentManager, client *deploymentTestClient, strategy string, autoPromote bool) (ct.Context, *deploymentRun) {
+	spec := testServiceSpec("web", "nginx:1", nil)
+	spec.GetReplicated().Replicas = 2
+	previous := client.addService(spec)
+	client.setTasks(previous.ID, 2, api.TaskStateRunning)
+	spec = spec.Copy()
+	spec.Task.GetContainer().Image = "nginx:2"
+
+	role := "canary"
+	if strategy == strategyBlueGreen {
+		role = "green"
+	}
+	d := &deployment{
+		ID:             "0123456789abcdef",
+		Service:        "web",
+		Strategy:       strategy,
+		Spec:           &createSpec{},
+		CanaryReplicas: 1,
+		HealthTimeout:  "1m",
+		StablePeriod:   "0s",
+		AutoPromote:    autoPromote,
+		ServiceID:      previous.ID,
+		ServiceName:    "web",
+		CandidateName:  "web-" + role + "-01234567",
+		History:        []*deploymentStep{},
+		CreatedAt:      time.Now().UTC(),
+	}
+	if err := validateDeployment(d); err != nil {
+		panic(err)
+	}
+	d.step(deploymentDeploying, "creating the candidate service")
+
+	ctx, cancel := ct.WithCancel(ct.Background())
+	dr := &deploymentRun{d: d, previous: previous, spec: spec, promote: make(chan struct{}, 1), cancel: cancel}
+	m.runs[d.ID] = dr
+	return ctx, dr
+}
+
+func TestValidateDeployment(t *testing.T) {
+	valid := func() *deployment {
+		return &deployment{Service: "web", Strategy: strategyCanary, Spec: &createSpec{}, CanaryReplicas: 1, HealthTimeout: "2m", StablePeriod: "30s"}
+	}
+	tests := []struct {
+		name   string
+		change func(d *deployment)
+		err    string
+	}{
+		{"valid", func(d *deployment) {}, ""},
+		{"blue-green", func(d *deployment) { d.Strategy, d.CanaryReplicas = strategyBlueGreen, 0 }, ""},
+		{"no stable period", func(d *deployment) { d.StablePeriod = "0s" }, ""},
+		{"invalid strategy", func(d *deployment) { d.Strategy = "rolling" }, `invalid strategy "rolling", must be canary or blue-green`},
+		{"no canary", func(d *deployment) { d.CanaryReplicas = 0 }, "canary_replicas must be at least 1"},
+		{"no service", func(d *deployment) { d.Service = "" }, "service is required"},
+		{"no spec", func(d *deployment) { d.Spec = nil }, "spec is required"},
+		{"invalid health timeout", func(d *deployment) { d.HealthTimeout = "0s" }, `invalid health_timeout "0s"`},
+		{"invalid stable period", func(d *deployment) { d.StablePeriod = "soon" }, `invalid stable_period "soon"`},
+		{"invalid failure ratio", func(d *deployment) { d.MaxFailureRatio = 1.5 }, "max_failure_ratio must be between 0 and 1"},
+	}
+	for _, test := range tests {
+		d := valid()
+		test.change(d)
+		err := validateDeployment(d)
+		if (err == nil && len(test.err) > 0) || (err != nil && err.Error() != test.err) {
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestDeploymentPrune(t *testing.T) {
+	now := time.Now().UTC()
+	deployments := []*deployment{
+		// running for long, never pruned
+		{ID: "a", State: deploymentPromoting, CreatedAt: now.Add(-3 * time.Hour), UpdatedAt: now.Add(-2 * time.Hour)},
+		{ID: "b", State: deploymentCompleted, CreatedAt: now.Add(-time.Minute), UpdatedAt: now},
+		{ID: "c", State: deploymentAborted, CreatedAt: now.Add(-2 * time.Minute), UpdatedAt: now},
+		{ID: "d", State: deploymentFailed, CreatedAt: now.Add(-3 * time.Minute), UpdatedAt: now},
+		{ID: "e", State: deploymentCompleted, CreatedAt: now.Add(-3 * time.Hour), UpdatedAt: now.Add(-2 * time.Hour)},
+	}
+
+	tests := []struct {
+		name    string
+		maxKept int
+		maxAge  time.Duration
+		kept    []string
+	}{
+		{"unlimited", 0, 0, []string{"a", "b", "c", "d", "e"}},
+		{"newest kept", 2, 0, []string{"a", "b", "c"}},
+		{"old forgotten", 0, time.Hour, []string{"a", "b", "c", "d"}},
+		{"both", 2, time.Hour, []string{"a", "b", "c"}},
+	}
+	for _, test := range tests {
+		m := &deploymentManager{maxKept: test.maxKept, maxAge: test.maxAge, runs: make(map[string]*deploymentRun)}
+		for _, d := range deployments {
+			m.runs[d.ID] = &deploymentRun{d: d}
+		}
+		m.prune()
+
+		kept := []string{}
+		for id := range m.runs {
+			kept = append(kept, id)
+		}
+		sort.Strings(kept)
+		if !reflect.DeepEqual(kept, test.kept) {
+			t.Errorf("%s: got %v, want %v", test.name, kept, test.kept)
+		}
+	}
+}
+
+func TestDeploymentFinish(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		canceled  bool
+		candidate bool // the candidate service exists
+		state     string
+		message   string // prefix of the message of the deployment
+	}{
+		{"completed", nil, false, true, deploymentCompleted, ""},
+		{"failed", errors.New("candidate web-canary-01234567 is unhealthy"), false, true, deploymentFailed, "candidate web-canary-01234567 is unhealthy"},
+		{"aborted", ct.Canceled, true, true, deploymentAborted, "aborted"},
+		{"aborted with a wrapped error", fmt.Errorf("create candidate web-canary-01234567: %v", ct.Canceled), true, true, deploymentAborted, "aborted"},
+		{
+			"candidate already removed",
+			errors.New("update web: update out of sequence"),
+			false, false, deploymentFailed,
+			"update web: update out of sequence, remove candidate web-canary-01234567 error: ",
+		},
+	}
+	for _, test := range tests {
+		client := &deploymentTestClient{clusterTestClient: newClusterTestClient()}
+		m, _ := newDeploymentManager(newTestContext(client), "", 0, 0)
+		ctx, dr := newTestDeployment(m, client, strategyCanary, true)
+		dr.d.CandidateID = dr.d.CandidateName + "-id"
+		if test.candidate {
+			client.addService(testServiceSpec(dr.d.CandidateName, "nginx:2", nil))
+		}
+		if test.canceled {
+			dr.cancel()
+		}
+		m.finish(ctx, dr, test.err)
+
+		if dr.d.State != test.state || !strings.HasPrefix(dr.d.Message, test.message) {
+			t.Errorf("%s: got %s %q, want %s %q", test.name, dr.d.State, dr.d.Message, test.state, test.message)
+		}
+		if ctx.Err() == nil {
+			t.Errorf("%s: the run context was not canceled", test.name)
+		}
+		if client.service(dr.d.CandidateName) != nil {
+			t.Errorf("%s: the candidate was not removed", test.name)
+		}
+	}
+}
+
+func TestDeploymentRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		state    api.TaskState // of the tasks of web once updated
+		change   bool          // web is updated once the deployment started
+		result   string
+		message  string // prefix of the message of the result
+		image    string // of web once the deployment finished
+		changes  []string
+	}{
+		{
+			"canary promoted", strategyCanary, api.TaskStateRunning, false,
+			deploymentCompleted, "", "nginx:2",
+			[]string{"CreateService web-canary-01234567", "UpdateService web", "RemoveService web-canary-01234567"},
+		},
+		{
+			"blue-green promoted", strategyBlueGreen, api.TaskStateRunning, false,
+			deploymentCompleted, "", "nginx:2",
+			[]string{"CreateService web-green-01234567", "UpdateService web", "RemoveService web-green-01234567"},
+		},
+		{
+			"promotion rolled back", strategyBlueGreen, api.TaskStateFailed, false,
+			deploymentFailed, "rollout of web failed: 2 new tasks failed", "nginx:1",
+			[]string{"CreateService web-green-01234567", "UpdateService web", "UpdateService web", "RemoveService web-green-01234567"},
+		},
+		{
+			"service changed", strategyCanary, api.TaskStateRunning, true,
+			deploymentFailed, "update web: update out of sequence", "nginx:3",
+			[]string{"UpdateService web", "CreateService web-canary-01234567", "RemoveService web-canary-01234567"},
+		},
+	}
+	for _, test := range tests {
+		client := &deploymentTestClient{clusterTestClient: newClusterTestClient(), state: test.state}
+		m, _ := newDeploymentManager(newTestContext(client), "", 0, 0)
+		ctx, dr := newTestDeployment(m, client, test.strategy, true)
+		if test.change {
+			spec := testServiceSpec("web", "nginx:3", nil)
+			spec.GetReplicated().Replicas = 2
+			web := client.service("web")
+			client.clusterTestClient.UpdateService(ct.Background(), &api.UpdateServiceRequest{ServiceID: web.ID, ServiceVersion: &web.Meta.Version, Spec: spec})
+		}
+		m.run(ctx, dr)
+
+		if dr.d.State != test.result || !strings.HasPrefix(dr.d.Message, test.message) {
+			t.Errorf("%s: got %s %q, want %s %q", test.name, dr.d.State, dr.d.Message, test.result, test.message)
+		}
+		if image := client.service("web").Spec.Task.GetContainer().Image; image != test.image {
+			t.Errorf("%s: got image %s, want %s", test.name, image, test.image)
+		}
+		if changes := client.changes(); !reflect.DeepEqual(changes, test.changes) {
+			t.Errorf("%s: got changes %v, want %v", test.name, changes, test.changes)
+		}
+	}
+}
+
+func TestDeploymentPromoteAbort(t *testing.T) {
+	tests := []struct {
+		name    string
+		abort   bool
+		result  string
+		image   string
+		changes []string
+	}{
+		{
+			"promoted", false, deploymentCompleted, "nginx:2",
+			[]string{"CreateService web-canary-01234567", "UpdateService web", "RemoveService web-canary-01234567"},
+		},
+		{
+			"aborted", true, deploymentAborted, "nginx:1",
+			[]string{"CreateService web-canary-01234567", "RemoveService web-canary-01234567"},
+		},
+	}
+	for _, test := range tests {
+		client := &deploymentTestClient{clusterTestClient: newClusterTestClient(), state: api.TaskStateRunning}
+		m, _ := newDeploymentManager(newTestContext(client), "", 0, 0)
+		ctx, dr := newTestDeployment(m, client, strategyCanary, false)
+		id := dr.d.ID
+
+		// a deploying deployment can be aborted, not promoted
+		if err := m.promote(id); grpc.Code(err) != codes.FailedPrecondition {
+			t.Errorf("%s: got promote error %v before the candidate is healthy", test.name, err)
+		}
+		done := make(chan struct{})
+		go func() {
+			m.run(ctx, dr)
+			close(done)
+		}()
+		if state := waitDeploymentState(t, m, id, deploymentWaiting); state != deploymentWaiting {
+			t.Fatalf("%s: got state %s, want %s", test.name, state, deploymentWaiting)
+		}
+
+		var err error
+		if test.abort {
+			err = m.abort(id)
+		} else {
+			err = m.promote(id)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		<-done
+
+		d, _ := m.get(id)
+		if d.State != test.result {
+			t.Errorf("%s: got state %s %q, want %s", test.name, d.State, d.Message, test.result)
+		}
+		if image := client.service("web").Spec.Task.GetContainer().Image; image != test.image {
+			t.Errorf("%s: got image %s, want %s", test.name, image, test.image)
+		}
+		if changes := client.changes(); !reflect.DeepEqual(changes, test.changes) {
+			t.Errorf("%s: got changes %v, want %v", test.name, changes, test.changes)
+		}
+
+		// a finished deployment can be neither promoted nor aborted
+		if err := m.promote(id); grpc.Code(err) != codes.FailedPrecondition {
+			t.Errorf("%s: got promote error %v once finished", test.name, err)
+		}
+		if err := m.abort(id); grpc.Code(err) != codes.FailedPrecondition {
+			t.Errorf("%s: got abort error %v once finished", test.name, err)
+		}
+	}
+
+	m, _ := newDeploymentManager(newTestContext(newClusterTestClient()), "", 0, 0)
+	if err := m.abort("missing"); grpc.Code(err) != codes.NotFound {
+		t.Errorf("got abort error %v for a missing deployment", err)
+	}
+}
+
+// waitDeploymentState waits for the deployment id to be in state, or to be
+// done, and returns its state.
+func waitDeploymentState(t *testing.T, m *deploymentManager, id, state string) string {
+	for start := time.Now(); time.Since(start) < 10*time.Second; time.Sleep(10 * time.Millisecond) {
+		d, err := m.get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.State == state || d.done() {
+			return d.State
+		}
+	}
+	t.Fatalf("deployment %s is still not %s", id, state)
+	return ""
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// specChange is one field that differs between two specs.
+type specChange struct {
+	Path string      `json:"path"`          // field path, e.g. Task.Runtime.Container.Image
+	Old  interface{} `json:"old,omitempty"` // nil when the field was added
+	New  interface{} `json:"new,omitempty"` // nil when the field was removed
+}
+
+// String formats the change for humans, e.g. "~ Task.Runtime.Container.Image: redis:3.0.5 -> redis:3.0.7".
+func (sc specChange) String() string {
+	switch {
+	case sc.Old == nil:
+		return fmt.Sprintf("+ %s: %v", sc.Path, sc.New)
+	case sc.New == nil:
+		return fmt.Sprintf("- %s: %v", sc.Path, sc.Old)
+	}
+	return fmt.Sprintf("~ %s: %v -> %v", sc.Path, sc.Old, sc.New)
+}
+
+// diffSpecs returns the field level differences between old and cur, which
+// must be of the same type. Slices are compared by index, maps by key.
+func diffSpecs(old, cur interface{}) []specChange {
+	changes := []specChange{}
+	diffValues("", reflect.ValueOf(old), reflect.ValueOf(cur), &changes)
+	return changes
+}
+
+func joinPath(path, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
+
+// isEmpty reports whether v holds no data: invalid, nil, empty or zero.
+func isEmpty(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// leaf returns the value reported in a change, nil when there is none.
+func leaf(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+	case reflect.Struct:
+	default:
+		// enums are reported with their name
+		if s, ok := v.Interface().(fmt.Stringer); ok {
+			return s.String()
+		}
+	}
+	return v.Interface()
+}
+
+func diffValues(path string, old, cur reflect.Value, changes *[]specChange) {
+	oldEmpty, curEmpty := isEmpty(old), isEmpty(cur)
+	if oldEmpty && curEmpty {
+		return
+	}
+	if oldEmpty || curEmpty {
+		*changes = append(*changes, specChange{Path: path, Old: leaf(old), New: leaf(cur)})
+		return
+	}
+
+	switch old.Kind() {
+	case reflect.Ptr:
+		diffValues(path, old.Elem(), cur.Elem(), changes)
+	case reflect.Interface:
+		// protobuf oneof: a different concrete type replaces the whole value
+		if old.Elem().Type() != cur.Elem().Type() {
+			*changes = append(*changes, specChange{Path: path, Old: leaf(old.Elem()), New: leaf(cur.Elem())})
+			return
+		}
+		diffValues(path, old.Elem(), cur.Elem(), changes)
+	case reflect.Struct:
+		t := old.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if len(f.PkgPath) > 0 || strings.HasPrefix(f.Name, "XXX_") {
+				continue
+			}
+			name := f.Name
+			if f.Anonymous {
+				name = ""
+			}
+			p := path
+			if len(name) > 0 {
+				p = joinPath(path, name)
+			}
+			diffValues(p, old.Field(i), cur.Field(i), changes)
+		}
+	case reflect.Slice, reflect.Array:
+		if old.Type().Elem().Kind() == reflect.Uint8 {
+			// []byte is a value, not a list
+			if !reflect.DeepEqual(old.Interface(), cur.Interface()) {
+				*changes = append(*changes, specChange{Path: path, Old: leaf(old), New: leaf(cur)})
+			}
+			return
+		}
+		n := old.Len()
+		if cur.Len() > n {
+			n = cur.Len()
+		}
+		for i := 0; i < n; i++ {
+			p := fmt.Sprintf("%s[%d]", path, i)
+			var o, c reflect.Value
+			if i < old.Len() {
+				o = old.Index(i)
+			}
+			if i < cur.Len() {
+				c = cur.Index(i)
+			}
+			diffValues(p, o, c, changes)
+		}
+	case reflect.Map:
+		keys := map[string]reflect.Value{}
+		for _, k := range old.MapKeys() {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+		for _, k := range cur.MapKeys() {
+			keys[fmt.Sprint(k.Interface())] = k
+		}
+		names := make([]string, 0, len(keys))
+		for name := range keys {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			k := keys[name]
+			diffValues(fmt.Sprintf("%s[%s]", path, name), old.MapIndex(k), cur.MapIndex(k), changes)
+		}
+	default:
+		if !reflect.DeepEqual(old.Interface(), cur.Interface()) {
+			*changes = append(*changes, specChange{Path: path, Old: leaf(old), New: leaf(cur)})
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/swarmkit/api"
+)
+
+func diffTestSpec() *api.ServiceSpec {
+	return &api.ServiceSpec{
+		Annotations: api.Annotations{
+			Name:   "redis",
+			Labels: map[string]string{"tier": "back"},
+		},
+		Mode: &api.ServiceSpec_Replicated{
+			Replicated: &api.ReplicatedService{Replicas: 1},
+		},
+		Task: api.TaskSpec{
+			Runtime: &api.TaskSpec_Container{
+				Container: &api.ContainerSpec{
+					Image: "redis:3.0.5",
+					Env:   []string{"A=1"},
+				},
+			},
+		},
+	}
+}
+
+func TestDiffSpecs(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(spec *api.ServiceSpec)
+		changes []specChange
+	}{
+		{
+			name:    "unchanged",
+			change:  func(spec *api.ServiceSpec) {},
+			changes: []specChange{},
+		},
+		{
+			name:    "field",
+			change:  func(spec *api.ServiceSpec) { spec.Task.GetContainer().Image = "redis:3.0.7" },
+			changes: []specChange{{Path: "Task.Runtime.Container.Image", Old: "redis:3.0.5", New: "redis:3.0.7"}},
+		},
+		{
+			name:    "oneof field",
+			change:  func(spec *api.ServiceSpec) { spec.GetReplicated().Replicas = 3 },
+			changes: []specChange{{Path: "Mode.Replicated.Replicas", Old: uint64(1), New: uint64(3)}},
+		},
+		{
+			name: "map key",
+			change: func(spec *api.ServiceSpec) {
+				spec.Annotations.Labels = map[string]string{"tier": "front", "env": "prod"}
+			},
+			changes: []specChange{
+				{Path: "Annotations.Labels[env]", New: "prod"},
+				{Path: "Annotations.Labels[tier]", Old: "back", New: "front"},
+			},
+		},
+		{
+			name:    "removed map",
+			change:  func(spec *api.ServiceSpec) { spec.Annotations.Labels = nil },
+			changes: []specChange{{Path: "Annotations.Labels", Old: map[string]string{"tier": "back"}}},
+		},
+		{
+			name: "slice",
+			change: func(spec *api.ServiceSpec) {
+				spec.Task.GetContainer().Env = []string{"A=2", "B=1"}
+			},
+			changes: []specChange{
+				{Path: "Task.Runtime.Container.Env[0]", Old: "A=1", New: "A=2"},
+				{Path: "Task.Runtime.Container.Env[1]", New: "B=1"},
+			},
+		},
+		{
+			name: "added struct",
+			change: func(spec *api.ServiceSpec) {
+				spec.Endpoint = &api.EndpointSpec{Mode: api.ResolutionModeDNSRoundRobin}
+			},
+			changes: []specChange{{Path: "Endpoint", New: &api.EndpointSpec{Mode: api.ResolutionModeDNSRoundRobin}}},
+		},
+	}
+
+	for _, test := range tests {
+		old, cur := diffTestSpec(), diffTestSpec()
+		test.change(cur)
+		changes := diffSpecs(old, cur)
+		if !reflect.DeepEqual(changes, test.changes) {
+			t.Errorf("%s: diffSpecs = %v, want %v", test.name, changes, test.changes)
+		}
+	}
+}
+
+func TestDiffSpecsEnum(t *testing.T) {
+	old, cur := diffTestSpec(), diffTestSpec()
+	old.Endpoint = &api.EndpointSpec{Mode: api.ResolutionModeVirtualIP, Ports: []*api.PortConfig{{TargetPort: 80}}}
+	cur.Endpoint = &api.EndpointSpec{Mode: api.ResolutionModeDNSRoundRobin, Ports: []*api.PortConfig{{TargetPort: 80}}}
+
+	want := []specChange{{
+		Path: "Endpoint.Mode",
+		Old:  api.ResolutionModeVirtualIP.String(),
+		New:  api.ResolutionModeDNSRoundRobin.String(),
+	}}
+	if changes := diffSpecs(old, cur); !reflect.DeepEqual(changes, want) {
+		t.Errorf("diffSpecs = %v, want %v", changes, want)
+	}
+}
+
+func TestDiffSpecsOneofType(t *testing.T) {
+	old, cur := diffTestSpec(), diffTestSpec()
+	cur.Mode = &api.ServiceSpec_Global{Global: &api.GlobalService{}}
+
+	changes := diffSpecs(old, cur)
+	if len(changes) != 1 || changes[0].Path != "Mode" {
+		t.Fatalf("diffSpecs = %v, want one change of Mode", changes)
+	}
+	if _, ok := changes[0].Old.(*api.ServiceSpec_Replicated); !ok {
+		t.Errorf("old = %T, want *api.ServiceSpec_Replicated", changes[0].Old)
+	}
+	if _, ok := changes[0].New.(*api.ServiceSpec_Global); !ok {
+		t.Errorf("new = %T, want *api.ServiceSpec_Global", changes[0].New)
+	}
+}
+
+func TestSpecChangeString(t *testing.T) {
+	tests := []struct {
+		change specChange
+		s      string
+	}{
+		{specChange{Path: "Task.Runtime.Container.Image", Old: "redis:3.0.5", New: "redis:3.0.7"}, "~ Task.Runtime.Container.Image: redis:3.0.5 -> redis:3.0.7"},
+		{specChange{Path: "Annotations.Labels[env]", New: "prod"}, "+ Annotations.Labels[env]: prod"},
+		{specChange{Path: "Task.Runtime.Container.Env[1]", Old: "B=1"}, "- Task.Runtime.Container.Env[1]: B=1"},
+	}
+	for _, test := range tests {
+		if s := test.change.String(); s != test.s {
+			t.Errorf("String() = %q, want %q", s, test.s)
+		}
+	}
+}
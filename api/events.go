@@ -1,38 +1,167 @@
 package api
 
 import (
+	"encoding/json"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
+// Event is a change of a cluster object observed by the watcher.
+type Event struct {
+	ID       uint64            `json:"id"`             // sequence number, increasing
+	Time     time.Time         `json:"time"`           // when the change was observed
+	Type     string            `json:"type"`           // service, task, node, network or cluster
+	Action   string            `json:"action"`         // create, update, delete or state
+	ObjectID string            `json:"object_id"`      // ID of the object
+	Name     string            `json:"name,omitempty"` // name of the object, service name for tasks
+	Labels   map[string]string `json:"labels,omitempty"`
+	Version  uint64            `json:"version"`        // Meta.Version.Index of the object
+	From     string            `json:"from,omitempty"` // previous state, for state events
+	To       string            `json:"to,omitempty"`   // new state, for state events
+}
+
+// eventFilter selects the events sent to a listener. Values of the same
+// field are or-ed, fields are and-ed.
+type eventFilter struct {
+	types   []string
+	ids     []string
+	names   []string
+	labels  []string // key or key=value
+	actions []string
+	states  []string // new state of state events
+}
+
+// newEventFilter reads the type, id, name, label, action and state query
+// parameters.
+func newEventFilter(q url.Values) *eventFilter {
+	return &eventFilter{
+		types:   q["type"],
+		ids:     q["id"],
+		names:   q["name"],
+		labels:  q["label"],
+		actions: q["action"],
+		states:  q["state"],
+	}
+}
+
+func matchAny(values []string, fn func(string) bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *eventFilter) match(e *Event) bool {
+	return matchAny(f.types, func(t string) bool { return t == e.Type }) &&
+		matchAny(f.ids, func(id string) bool { return strings.HasPrefix(e.ObjectID, id) }) &&
+		matchAny(f.names, func(n string) bool { return n == e.Name }) &&
+		matchAny(f.labels, func(l string) bool {
+			parts := strings.SplitN(l, "=", 2)
+			v, ok := e.Labels[parts[0]]
+			return ok && (len(parts) == 1 || v == parts[1])
+		}) &&
+		matchAny(f.actions, func(a string) bool { return a == e.Action }) &&
+		matchAny(f.states, func(st string) bool { return strings.EqualFold(st, e.To) })
+}
+
+// eventMatcher selects the events sent to a listener.
+type eventMatcher interface {
+	match(e *Event) bool
+}
+
+// eventWriter encodes events for one transport.
+type eventWriter interface {
+	WriteEvent(e *Event) error
+	// Ping keeps an idle connection open.
+	Ping() error
+}
+
+// listener is a consumer of the events. Events are queued in a buffered
+// channel so a slow consumer never blocks the others; when the queue is full
+// the listener is dropped and the channel closed.
+type listener struct {
+	matcher eventMatcher
+	events  chan *Event
+	writer  eventWriter
+	replay  []*Event
+}
+
 // EventsHandler broadcasts events to multiple client listeners.
 type eventsHandler struct {
 	sync.RWMutex
-	ws map[string]io.Writer
-	cs map[string]chan struct{}
+	listeners map[string]*listener
+
+	// recent events, replayed to listeners asking for past events
+	history    []*Event
+	maxHistory int
+	queueSize  int
 }
 
 // NewEventsHandler creates a new EventsHandler for a cluster.
-// The new eventsHandler is initialized with no writers or channels.
-func newEventsHandler() *eventsHandler {
+// The new eventsHandler is initialized with no listeners.
+func newEventsHandler(maxHistory, queueSize int) *eventsHandler {
 	return &eventsHandler{
-		ws: make(map[string]io.Writer),
-		cs: make(map[string]chan struct{}),
+		listeners:  make(map[string]*listener),
+		maxHistory: maxHistory,
+		queueSize:  queueSize,
 	}
 }
 
-// Add adds the writer and a new channel for the remote address.
-func (eh *eventsHandler) Add(remoteAddr string, w io.Writer) {
+// subscribe registers a listener for the remote address. It returns the
+// listener and the recent events matching m that were observed after the
+// event afterID or since the given time, to be sent before the queued ones.
+func (eh *eventsHandler) subscribe(remoteAddr string, m eventMatcher, afterID uint64, since time.Time) *listener {
 	eh.Lock()
-	eh.ws[remoteAddr] = w
-	eh.cs[remoteAddr] = make(chan struct{})
-	eh.Unlock()
+	defer eh.Unlock()
+
+	l := &listener{
+		matcher: m,
+		events:  make(chan *Event, eh.queueSize),
+	}
+	if afterID > 0 || !since.IsZero() {
+		l.replay = eh.since(m, afterID, since)
+	}
+	eh.listeners[remoteAddr] = l
+	return l
 }
 
-// Wait waits on a signal from the remote address.
-func (eh *eventsHandler) Wait(remoteAddr string, until int64) {
+// since returns the recent events matching m observed after the event afterID
+// or since the given time. The caller holds the lock.
+func (eh *eventsHandler) since(m eventMatcher, afterID uint64, since time.Time) []*Event {
+	events := []*Event{}
+	for _, e := range eh.history {
+		if e.ID <= afterID || e.Time.Before(since) || !m.match(e) {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// Add adds a listener writing the events with w for the remote address.
+func (eh *eventsHandler) Add(remoteAddr string, w eventWriter, m eventMatcher, afterID uint64, since time.Time) {
+	l := eh.subscribe(remoteAddr, m, afterID, since)
+	l.writer = w
+}
+
+// Wait writes the events of the remote address listener until the client
+// goes away, the until time is reached or the listener is dropped.
+func (eh *eventsHandler) Wait(remoteAddr string, until int64, closeNotify <-chan bool) {
+	defer eh.cleanupHandler(remoteAddr)
 
 	timer := time.NewTimer(0)
 	timer.Stop()
@@ -40,28 +169,163 @@ func (eh *eventsHandler) Wait(remoteAddr string, until int64) {
 		dur := time.Unix(until, 0).Sub(time.Now())
 		timer = time.NewTimer(dur)
 	}
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
 
-	// subscribe to http client close event
-	w := eh.ws[remoteAddr]
-	var closeNotify <-chan bool
-	if closeNotifier, ok := w.(http.CloseNotifier); ok {
-		closeNotify = closeNotifier.CloseNotify()
+	eh.RLock()
+	l := eh.listeners[remoteAddr]
+	eh.RUnlock()
+	if l == nil {
+		return
 	}
 
-	select {
-	case <-eh.cs[remoteAddr]:
-	case <-closeNotify:
-	case <-timer.C: // `--until` timeout
-		close(eh.cs[remoteAddr])
+	for _, e := range l.replay {
+		if err := l.writer.WriteEvent(e); err != nil {
+			return
+		}
+	}
+	for {
+		var err error
+		select {
+		case e, ok := <-l.events:
+			if !ok {
+				log.WithField("remote", remoteAddr).Warn("Events listener too slow, dropped")
+				return
+			}
+			err = l.writer.WriteEvent(e)
+		case <-ping.C:
+			err = l.writer.Ping()
+		case <-closeNotify:
+			return
+		case <-timer.C: // `--until` timeout
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Handle records the event and queues it for every listener whose filter
+// matches. A listener with a full queue is dropped.
+func (eh *eventsHandler) Handle(e *Event) {
+	eh.Lock()
+	defer eh.Unlock()
+
+	eh.history = append(eh.history, e)
+	if len(eh.history) > eh.maxHistory {
+		eh.history = eh.history[len(eh.history)-eh.maxHistory:]
+	}
+
+	for key, l := range eh.listeners {
+		if !l.matcher.match(e) {
+			continue
+		}
+		select {
+		case l.events <- e:
+		default:
+			close(l.events)
+			delete(eh.listeners, key)
+		}
 	}
-	eh.cleanupHandler(remoteAddr)
 }
 
 func (eh *eventsHandler) cleanupHandler(remoteAddr string) {
 	eh.Lock()
-	// the maps are expected to have the same keys
-	delete(eh.cs, remoteAddr)
-	delete(eh.ws, remoteAddr)
+	delete(eh.listeners, remoteAddr)
 	eh.Unlock()
+}
 
+// jsonEventWriter writes events as json lines.
+type jsonEventWriter struct {
+	w io.Writer
+}
+
+func (jw *jsonEventWriter) WriteEvent(e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = jw.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if f, ok := jw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (jw *jsonEventWriter) Ping() error {
+	return nil
+}
+
+// GET /events?since=&until=&type=&id=&name=&label=&action=&state=
+//    since:  replay the events observed since this time (RFC 3339 or unix timestamp)
+//    until:  stop streaming at this time
+//    type:   service, task, node, network or cluster
+//    id:     object ID prefix
+//    name:   object name, service name for tasks
+//    label:  key or key=value
+//    action: create, update, delete or state
+//    state:  new state of state events, e.g. FAILED
+// Each filter can be repeated. Clients accepting text/event-stream get
+// Server-Sent Events and can resume with the Last-Event-ID header.
+func getEvents(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		since   time.Time
+		until   time.Time
+		afterID uint64
+		q       = r.URL.Query()
+		writer  eventWriter
+	)
+
+	if c.eventsHandler == nil {
+		errResponse(w, r, grpc.Errorf(codes.Unimplemented, "events are not enabled"), c)
+		return
+	}
+
+	if s := q.Get("since"); len(s) > 0 {
+		if since, err = parseTime(s); err != nil {
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid since: %v", err), c)
+			return
+		}
+	}
+	if s := q.Get("until"); len(s) > 0 {
+		if until, err = parseTime(s); err != nil {
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid until: %v", err), c)
+			return
+		}
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		if s := r.Header.Get("Last-Event-ID"); len(s) > 0 {
+			if afterID, err = strconv.ParseUint(s, 10, 64); err != nil {
+				errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid Last-Event-ID: %v", err), c)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		writer = &sseEventWriter{w: w}
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		writer = &jsonEventWriter{w: w}
+	}
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	var closeNotify <-chan bool
+	if closeNotifier, ok := w.(http.CloseNotifier); ok {
+		closeNotify = closeNotifier.CloseNotify()
+	}
+
+	c.eventsHandler.Add(r.RemoteAddr, writer, newEventFilter(q), afterID, since)
+	var untilUnix int64
+	if !until.IsZero() {
+		untilUnix = until.Unix()
+	}
+	c.eventsHandler.Wait(r.RemoteAddr, untilUnix, closeNotify)
 }
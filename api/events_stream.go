@@ -0,0 +1,256 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// pingInterval is the period of the keepalive messages sent on idle streams,
+// so proxies do not close them.
+const pingInterval = 15 * time.Second
+
+// sseEventWriter writes events as Server-Sent Events. The event ID is sent so
+// browsers resume with Last-Event-ID after a reconnection.
+type sseEventWriter struct {
+	w io.Writer
+}
+
+func (sw *sseEventWriter) WriteEvent(e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err = fmt.Fprintf(sw.w, "id: %d\ndata: %s\n\n", e.ID, b); err != nil {
+		return err
+	}
+	sw.flush()
+	return nil
+}
+
+func (sw *sseEventWriter) Ping() error {
+	if _, err := io.WriteString(sw.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	sw.flush()
+	return nil
+}
+
+func (sw *sseEventWriter) flush() {
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// wsMessage is exchanged on the events websocket.
+//
+// client -> server:
+//
+//	{"type": "subscribe", "id": "s1", "filters": {"type": ["task"], "name": ["redis"]}, "last_event_id": 12}
+//	{"type": "unsubscribe", "id": "s1"}
+//
+// server -> client:
+//
+//	{"type": "subscribed", "id": "s1"}
+//	{"type": "unsubscribed", "id": "s1"}
+//	{"type": "event", "subscriptions": ["s1"], "event": {...}}
+//	{"type": "error", "id": "s1", "message": "..."}
+type wsMessage struct {
+	Type          string              `json:"type"`
+	ID            string              `json:"id,omitempty"`
+	Filters       map[string][]string `json:"filters,omitempty"`
+	Since         string              `json:"since,omitempty"`
+	LastEventID   uint64              `json:"last_event_id,omitempty"`
+	Subscriptions []string            `json:"subscriptions,omitempty"`
+	Event         *Event              `json:"event,omitempty"`
+	Message       string              `json:"message,omitempty"`
+}
+
+// wsSubscriptions are the filters of a websocket connection. An event is sent
+// when it matches at least one of them.
+type wsSubscriptions struct {
+	sync.RWMutex
+	filters map[string]*eventFilter
+}
+
+func (ws *wsSubscriptions) match(e *Event) bool {
+	return len(ws.matching(e)) > 0
+}
+
+// matching returns the IDs of the subscriptions matching e.
+func (ws *wsSubscriptions) matching(e *Event) []string {
+	ws.RLock()
+	defer ws.RUnlock()
+	ids := []string{}
+	for id, f := range ws.filters {
+		if f.match(e) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (ws *wsSubscriptions) set(id string, f *eventFilter) {
+	ws.Lock()
+	ws.filters[id] = f
+	ws.Unlock()
+}
+
+func (ws *wsSubscriptions) remove(id string) bool {
+	ws.Lock()
+	defer ws.Unlock()
+	_, ok := ws.filters[id]
+	delete(ws.filters, id)
+	return ok
+}
+
+// checkOrigin reports whether a websocket may be opened from the Origin of
+// r: the api itself or one of allowed. Browsers send the client certificate
+// and the cookies of the api with any cross-site upgrade, so the origin must
+// be checked even with CORS enabled. Clients other than browsers send no
+// Origin and are allowed.
+func checkOrigin(r *http.Request, allowed []string) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(a, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// GET /events/ws
+// Upgrades to a websocket, see wsMessage for the protocol.
+func getEventsWebsocket(c *context, w http.ResponseWriter, r *http.Request) {
+	if c.eventsHandler == nil {
+		errResponse(w, r, grpc.Errorf(codes.Unimplemented, "events are not enabled"), c)
+		return
+	}
+
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return checkOrigin(r, c.options.WebsocketOrigins) },
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader already replied
+		log.WithFields(log.Fields{"method": r.Method, "route": requestURI(r)}).Errorln(err)
+		return
+	}
+	defer conn.Close()
+
+	subs := &wsSubscriptions{filters: make(map[string]*eventFilter)}
+	l := c.eventsHandler.subscribe(r.RemoteAddr, subs, 0, time.Time{})
+	defer c.eventsHandler.cleanupHandler(r.RemoteAddr)
+
+	// the reader turns client messages into replies and replays, which are
+	// written by this goroutine only, as websocket writes are not concurrent
+	replies := make(chan *wsMessage, 16)
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(done)
+		for {
+			msg := &wsMessage{}
+			if err := conn.ReadJSON(msg); err != nil {
+				return
+			}
+			for _, reply := range c.eventsHandler.wsHandle(subs, msg) {
+				select {
+				case replies <- reply:
+				case <-stop:
+					return
+				}
+			}
+		}
+	}()
+
+	ping := time.NewTicker(pingInterval)
+	defer ping.Stop()
+	for {
+		var msg *wsMessage
+		select {
+		case <-done:
+			return
+		case msg = <-replies:
+		case e, ok := <-l.events:
+			if !ok {
+				log.WithField("remote", r.RemoteAddr).Warn("Events listener too slow, dropped")
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow"), time.Now().Add(time.Second))
+				return
+			}
+			ids := subs.matching(e)
+			if len(ids) == 0 {
+				continue
+			}
+			msg = &wsMessage{Type: "event", Subscriptions: ids, Event: e}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(pingInterval)); err != nil {
+				return
+			}
+			continue
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(pingInterval))
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// wsHandle applies a client message and returns the messages to send back.
+func (eh *eventsHandler) wsHandle(subs *wsSubscriptions, msg *wsMessage) []*wsMessage {
+	switch msg.Type {
+	case "subscribe":
+		if len(msg.ID) == 0 {
+			return []*wsMessage{{Type: "error", Message: "subscription id is required"}}
+		}
+		var since time.Time
+		if len(msg.Since) > 0 {
+			var err error
+			if since, err = parseTime(msg.Since); err != nil {
+				return []*wsMessage{{Type: "error", ID: msg.ID, Message: fmt.Sprintf("invalid since: %v", err)}}
+			}
+		}
+		f := newEventFilter(msg.Filters)
+		subs.set(msg.ID, f)
+
+		replies := []*wsMessage{{Type: "subscribed", ID: msg.ID}}
+		if msg.LastEventID > 0 || !since.IsZero() {
+			eh.RLock()
+			past := eh.since(f, msg.LastEventID, since)
+			eh.RUnlock()
+			for _, e := range past {
+				replies = append(replies, &wsMessage{Type: "event", Subscriptions: []string{msg.ID}, Event: e})
+			}
+		}
+		return replies
+	case "unsubscribe":
+		if !subs.remove(msg.ID) {
+			return []*wsMessage{{Type: "error", ID: msg.ID, Message: "unknown subscription"}}
+		}
+		return []*wsMessage{{Type: "unsubscribed", ID: msg.ID}}
+	}
+	return []*wsMessage{{Type: "error", ID: msg.ID, Message: fmt.Sprintf("unknown message type %q", msg.Type)}}
+}
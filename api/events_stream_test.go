@@ -0,0 +1,35 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	allowed := []string{"https://dashboard.example.com/"}
+	tests := []struct {
+		origin string
+		ok     bool
+	}{
+		{"", true},
+		{"https://api.example.com:8888", true},
+		{"http://API.example.com:8888", true},
+		{"https://dashboard.example.com", true},
+		{"https://evil.example.com", false},
+		{"https://api.example.com", false},
+		{"http://dashboard.example.com", false},
+		{"null", false},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest(http.MethodGet, "https://api.example.com:8888/events/ws", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(test.origin) > 0 {
+			r.Header.Set("Origin", test.origin)
+		}
+		if ok := checkOrigin(r, allowed); ok != test.ok {
+			t.Errorf("checkOrigin(%q) = %v, want %v", test.origin, ok, test.ok)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var sseEventID = regexp.MustCompile(`(?m)^id: (\d+)$`)
+
+func TestEventsResume(t *testing.T) {
+	c := newTestContext(newClusterTestClient())
+	c.eventsHandler = newEventsHandler(100, 10)
+	start := time.Now().UTC().Add(-time.Minute)
+	for i, name := range []string{"web", "db", "web", "db", "web"} {
+		c.eventsHandler.Handle(&Event{ID: uint64(i + 1), Time: start.Add(time.Duration(i) * time.Second), Type: "service", Action: "update", Name: name})
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		lastEventID string
+		status      int
+		ids         []string // of the replayed events
+	}{
+		{"no resume", "", "", http.StatusOK, nil},
+		{"resume", "", "2", http.StatusOK, []string{"3", "4", "5"}},
+		{"resume with a filter", "&name=web", "1", http.StatusOK, []string{"3", "5"}},
+		{"resume after the last event", "", "5", http.StatusOK, nil},
+		{"invalid Last-Event-ID", "", "last", http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		// until in the past ends the stream once the replay is sent
+		r := httptest.NewRequest(http.MethodGet, "/events?until=1"+test.query, nil)
+		r.Header.Set("Accept", "text/event-stream")
+		if len(test.lastEventID) > 0 {
+			r.Header.Set("Last-Event-ID", test.lastEventID)
+		}
+		w := httptest.NewRecorder()
+		getEvents(c, w, r)
+
+		if w.Code != test.status {
+			t.Errorf("%s: got status %d, want %d: %s", test.name, w.Code, test.status, w.Body.String())
+			continue
+		}
+		var ids []string
+		for _, m := range sseEventID.FindAllStringSubmatch(w.Body.String(), -1) {
+			ids = append(ids, m[1])
+		}
+		if !reflect.DeepEqual(ids, test.ids) {
+			t.Errorf("%s: got events %v, want %v", test.name, ids, test.ids)
+		}
+	}
+}
@@ -2,13 +2,149 @@ package api
 
 import (
 	"net/http"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"github.com/shenshouer/swarmkit-client/swarmkit"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
+// errorBody is the json document returned for a failed request.
+type errorBody struct {
+	Code    string `json:"code"`           // gRPC code name, e.g. NotFound
+	Message string `json:"message"`        // human readable error
+	Kind    string `json:"kind,omitempty"` // kind of the object the request was about
+	ID      string `json:"id,omitempty"`   // ID or name of the object the request was about
+}
+
+// resourceKinds maps the first segment of a route to an object kind.
+var resourceKinds = map[string]string{
+	"webhooks":    "webhook",
+	"nodes":       "node",
+	"services":    "service",
+	"tasks":       "task",
+	"tasts":       "task",
+	"networks":    "network",
+	"clusters":    "cluster",
+	"stacks":      "stack",
+	"deployments": "deployment",
+}
+
+// resourceIDVars are the route variables holding the ID or name of the object
+// of a route, by preference: a revision route also holds the revision number.
+var resourceIDVars = []string{"serviceid", "nodeid", "taskid", "networkid", "clusterid", "webhookid", "deploymentid", "name"}
+
+// httpStatusCodes maps gRPC codes returned by the manager to http status codes.
+var httpStatusCodes = map[codes.Code]int{
+	codes.OK:                 http.StatusOK,
+	codes.Canceled:           http.StatusRequestTimeout,
+	codes.InvalidArgument:    http.StatusBadRequest,
+	codes.OutOfRange:         http.StatusBadRequest,
+	codes.DeadlineExceeded:   http.StatusGatewayTimeout,
+	codes.NotFound:           http.StatusNotFound,
+	codes.AlreadyExists:      http.StatusConflict,
+	codes.Aborted:            http.StatusConflict,
+	codes.FailedPrecondition: http.StatusConflict,
+	codes.PermissionDenied:   http.StatusForbidden,
+	codes.Unauthenticated:    http.StatusUnauthorized,
+	codes.ResourceExhausted:  http.StatusTooManyRequests,
+	codes.Unimplemented:      http.StatusNotImplemented,
+	codes.Unavailable:        http.StatusServiceUnavailable,
+	codes.Internal:           http.StatusInternalServerError,
+	codes.DataLoss:           http.StatusInternalServerError,
+	// Unknown is also what grpc.Code returns for errors that did not come
+	// from the manager: the validation errors are given InvalidArgument.
+	codes.Unknown: http.StatusInternalServerError,
+}
+
+// errorStatus returns the http status and the code name for err.
+func errorStatus(err error) (int, string) {
+	switch err {
+	case ct.DeadlineExceeded:
+		return http.StatusGatewayTimeout, codes.DeadlineExceeded.String()
+	case ct.Canceled:
+		return http.StatusRequestTimeout, codes.Canceled.String()
+	}
+
+	switch err.(type) {
+	case *swarmkit.NotFoundError:
+		return http.StatusNotFound, codes.NotFound.String()
+	case *swarmkit.AmbiguousError:
+		return http.StatusBadRequest, "Ambiguous"
+	}
+
+	code := grpc.Code(err)
+	// the store of the manager returns version conflicts as the plain
+	// ErrSequenceConflict of manager/state/store, "update out of sequence",
+	// which reaches the client without a gRPC code
+	if code == codes.Unknown && strings.Contains(grpc.ErrorDesc(err), "update out of sequence") {
+		return http.StatusConflict, codes.Aborted.String()
+	}
+	if status, ok := httpStatusCodes[code]; ok {
+		return status, code.String()
+	}
+	return http.StatusInternalServerError, code.String()
+}
+
+// newErrorBody describes err, using the route of r to name the object when
+// the error itself does not.
+func newErrorBody(r *http.Request, err error) *errorBody {
+	_, code := errorStatus(err)
+	body := &errorBody{
+		Code:    code,
+		Message: grpc.ErrorDesc(err),
+	}
+
+	switch e := err.(type) {
+	case *swarmkit.NotFoundError:
+		body.Kind, body.ID = e.Kind, e.Input
+		return body
+	case *swarmkit.AmbiguousError:
+		body.Kind, body.ID = e.Kind, e.Input
+		return body
+	}
+
+	segments := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	body.Kind = resourceKinds[segments[0]]
+	vars := mux.Vars(r)
+	for _, name := range resourceIDVars {
+		if id, ok := vars[name]; ok {
+			body.ID = id
+			break
+		}
+	}
+	return body
+}
+
+// notFound returns the error of a missing object, mapped to a 404.
+func notFound(kind, id string) error {
+	return &swarmkit.NotFoundError{Kind: kind, Input: id}
+}
+
+// invalidArgument returns err, a validation error without code, as an
+// InvalidArgument error mapped to a 400. Errors with a code and the lookup
+// errors are returned unchanged.
+func invalidArgument(err error) error {
+	switch err.(type) {
+	case nil, *swarmkit.NotFoundError, *swarmkit.AmbiguousError:
+		return err
+	}
+	if grpc.Code(err) != codes.Unknown {
+		return err
+	}
+	return grpc.Errorf(codes.InvalidArgument, "%v", err)
+}
+
 func errResponse(w http.ResponseWriter, r *http.Request, err error, c *context) {
-	log.WithFields(log.Fields{"method": r.Method, "route": r.RequestURI}).Errorln(err)
-	c.render.JSON(w, http.StatusBadRequest, map[string]interface{}{"msg": err})
+	status, _ := errorStatus(err)
+	if rec := auditFromRequest(r); rec != nil {
+		rec.Error = err.Error()
+	}
+	log.WithFields(log.Fields{"method": r.Method, "route": requestURI(r), "status": status}).Errorln(err)
+	c.render.JSON(w, status, newErrorBody(r, err))
 }
 
 // Emit an HTTP error and log it.
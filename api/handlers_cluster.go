@@ -1,7 +1,6 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
 	"strings"
 
@@ -11,7 +10,8 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/shenshouer/swarmkit-client/swarmkit"
 	"golang.org/x/crypto/bcrypt"
-	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 // GET /clusters
@@ -21,7 +21,7 @@ func listClusters(c *context, w http.ResponseWriter, r *http.Request) {
 		listClusterResp *api.ListClustersResponse
 	)
 
-	if listClusterResp, err = c.swarmkitAPI.ListClusters(ct.TODO(), &api.ListClustersRequest{}); err != nil {
+	if listClusterResp, err = c.swarmkitAPI.ListClusters(r.Context(), &api.ListClustersRequest{}); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
@@ -36,7 +36,7 @@ func inspectClusters(c *context, w http.ResponseWriter, r *http.Request) {
 		clusterid = mux.Vars(r)["clusterid"]
 	)
 
-	if cluster, err = swarmkit.GetCluster(ct.TODO(), c.swarmkitAPI, clusterid); err != nil {
+	if cluster, err = swarmkit.GetCluster(r.Context(), c.swarmkitAPI, clusterid); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
@@ -68,15 +68,17 @@ func updateClusters(c *context, w http.ResponseWriter, r *http.Request) {
 	)
 
 	if err = DecoderRequest(r, cInfo); err != nil {
+		err = grpc.Errorf(codes.InvalidArgument, "Parse params for update cluster error:%v", err)
 		errResponse(w, r, err, c)
 		return
 	}
 
-	if cluster, err = swarmkit.GetCluster(ct.TODO(), c.swarmkitAPI, clusterid); err != nil {
+	if cluster, err = swarmkit.GetCluster(r.Context(), c.swarmkitAPI, clusterid); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
 
+	auditObject(r, cluster.ID)
 	spec := &cluster.Spec
 	if len(cInfo.Autoaccept) > 0 {
 		// We are getting a whitelist, so make all of the autoaccepts false
@@ -89,7 +91,7 @@ func updateClusters(c *context, w http.ResponseWriter, r *http.Request) {
 			// Convert the role into a proto role
 			apiRole, err := ca.FormatRole("swarm-" + role)
 			if err != nil {
-				err = fmt.Errorf("unrecognized role %s", role)
+				err = grpc.Errorf(codes.InvalidArgument, "unrecognized role %s", role)
 				errResponse(w, r, err, c)
 				return
 			}
@@ -118,6 +120,7 @@ func updateClusters(c *context, w http.ResponseWriter, r *http.Request) {
 		hashedSecret, err := bcrypt.GenerateFromPassword([]byte(cInfo.Secret[0]), 0)
 		if err != nil {
 			errResponse(w, r, err, c)
+			return
 		}
 		for _, policy := range spec.AcceptancePolicy.Policies {
 			policy.Secret = &api.AcceptancePolicy_RoleAdmissionPolicy_HashedSecret{
@@ -130,7 +133,7 @@ func updateClusters(c *context, w http.ResponseWriter, r *http.Request) {
 	if len(cInfo.Certexpiry) > 1 {
 		duration, err := ParseString(cInfo.Certexpiry)
 		if err != nil {
-			errResponse(w, r, err, c)
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "%v", err), c)
 			return
 		}
 		ceProtoPeriod := ptypes.DurationProto(duration.Duration())
@@ -145,14 +148,14 @@ func updateClusters(c *context, w http.ResponseWriter, r *http.Request) {
 	if len(strings.TrimSpace(cInfo.Heartbeatperiod)) > 1 {
 		duration, err := ParseString(cInfo.Certexpiry)
 		if err != nil {
-			errResponse(w, r, err, c)
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "%v", err), c)
 			return
 		}
 
 		spec.Dispatcher.HeartbeatPeriod = uint64(duration.Duration())
 	}
 
-	if updateClusterResp, err = c.swarmkitAPI.UpdateCluster(ct.TODO(), &api.UpdateClusterRequest{
+	if updateClusterResp, err = c.swarmkitAPI.UpdateCluster(r.Context(), &api.UpdateClusterRequest{
 		ClusterID:      cluster.ID,
 		ClusterVersion: &cluster.Meta.Version,
 		Spec:           spec,
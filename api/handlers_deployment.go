@@ -0,0 +1,95 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// GET /deployments?service=
+//    service: only the deployments of this service name or ID
+// Newest first.
+func listDeployments(c *context, w http.ResponseWriter, r *http.Request) {
+	c.render.JSON(w, http.StatusOK, c.deployments.list(r.URL.Query().Get("service")))
+}
+
+// GET /deployments/{deploymentid:[^/]+}
+func inspectDeployment(c *context, w http.ResponseWriter, r *http.Request) {
+	d, err := c.deployments.get(mux.Vars(r)["deploymentid"])
+	if err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	c.render.JSON(w, http.StatusOK, d)
+}
+
+// POST /deployments/create
+// {
+//    service: "web",                   // service name or ID
+//    strategy: "canary",               // canary or blue-green
+//    spec: {image: "nginx:1.11"},      // changes of the new version, as for POST /services/{id}/update
+//    canary_replicas: 1,               // replicas of the canary
+//    health_timeout: "2m",             // time for the tasks of the candidate to run
+//    stable_period: "30s",             // time they must then run without failing
+//    max_failure_ratio: 0,             // share of the candidate tasks allowed to fail
+//    auto_promote: true,               // promote once healthy, otherwise wait for /promote
+// }
+// The candidate service, <service>-canary-<id> or <service>-green-<id>, runs
+// the new spec without published ports; a canary also answers to the name of
+// the service on its networks, a green candidate runs every replica. The
+// promotion updates the service in place, rolled back if its tasks fail, and
+// removes the candidate: the service keeps its name and serves its ports
+// throughout.
+func createDeployment(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err error
+		d   = &deployment{
+			Strategy:       strategyCanary,
+			CanaryReplicas: 1,
+			HealthTimeout:  "2m",
+			StablePeriod:   "30s",
+			AutoPromote:    true,
+		}
+	)
+
+	if err = DecoderRequest(r, d); err != nil {
+		err = grpc.Errorf(codes.InvalidArgument, "Parse params for create deployment error:%v", err)
+		errResponse(w, r, err, c)
+		return
+	}
+
+	if err = c.deployments.create(r, d); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+
+	auditObject(r, d.ID)
+	created, _ := c.deployments.get(d.ID)
+	c.render.JSON(w, http.StatusOK, created)
+}
+
+// POST /deployments/{deploymentid:[^/]+}/promote
+// Promotes a deployment waiting for it, created with auto_promote false.
+func promoteDeployment(c *context, w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["deploymentid"]
+	auditObject(r, id)
+	if err := c.deployments.promote(id); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	c.render.JSON(w, http.StatusOK, id)
+}
+
+// POST /deployments/{deploymentid:[^/]+}/abort
+// Stops a deployment before its promotion and removes its candidate service.
+func abortDeployment(c *context, w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["deploymentid"]
+	auditObject(r, id)
+	if err := c.deployments.abort(id); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	c.render.JSON(w, http.StatusOK, id)
+}
@@ -1,7 +1,6 @@
 package api
 
 import (
-	"errors"
 	"net"
 	"net/http"
 	"strings"
@@ -9,7 +8,8 @@ import (
 	"github.com/docker/swarmkit/api"
 	"github.com/gorilla/mux"
 	"github.com/shenshouer/swarmkit-client/swarmkit"
-	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 // GET /networks
@@ -18,7 +18,7 @@ func listNetworks(c *context, w http.ResponseWriter, r *http.Request) {
 		err              error
 		listNetworksResp *api.ListNetworksResponse
 	)
-	if listNetworksResp, err = c.swarmkitAPI.ListNetworks(ct.TODO(), &api.ListNetworksRequest{}); err != nil {
+	if listNetworksResp, err = c.swarmkitAPI.ListNetworks(r.Context(), &api.ListNetworksRequest{}); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
@@ -33,7 +33,7 @@ func inspectNetworks(c *context, w http.ResponseWriter, r *http.Request) {
 		network   *api.Network
 		networkid = mux.Vars(r)["networkid"]
 	)
-	if network, err = swarmkit.GetNetwork(ct.TODO(), c.swarmkitAPI, networkid); err != nil {
+	if network, err = swarmkit.GetNetwork(r.Context(), c.swarmkitAPI, networkid); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
@@ -44,6 +44,7 @@ func inspectNetworks(c *context, w http.ResponseWriter, r *http.Request) {
 // POST /networks/creat
 //{
 //	name: "",
+//	labels: {},
 //	driver: "",
 //	opts: {},
 //	ipam_driver: "",
@@ -54,26 +55,44 @@ func inspectNetworks(c *context, w http.ResponseWriter, r *http.Request) {
 //}
 func createNetworks(c *context, w http.ResponseWriter, r *http.Request) {
 	var (
-		err      error
-		driver   *api.Driver
-		ipamOpts *api.IPAMOptions
-		nwInfo   = &networkInfo{}
+		err    error
+		spec   *api.NetworkSpec
+		nwInfo = &networkInfo{}
 	)
 
 	if err = DecoderRequest(r, nwInfo); err != nil {
+		err = grpc.Errorf(codes.InvalidArgument, "Parse params for create network error:%v", err)
 		errResponse(w, r, err, c)
 		return
 	}
-	// parse api.Driver
-	if len(strings.TrimSpace(nwInfo.Name)) == 0 {
-		err = errors.New("name is required")
+
+	if spec, err = newNetworkSpec(nwInfo); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
 
+	var cnResp *api.CreateNetworkResponse
+	if cnResp, err = c.swarmkitAPI.CreateNetwork(r.Context(), &api.CreateNetworkRequest{Spec: spec}); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+
+	auditObject(r, cnResp.Network.ID)
+	c.render.JSON(w, http.StatusOK, cnResp.Network.ID)
+}
+
+// newNetworkSpec returns the spec of the network described by nwInfo.
+func newNetworkSpec(nwInfo *networkInfo) (*api.NetworkSpec, error) {
+	var driver *api.Driver
+
+	// parse api.Driver
+	if len(strings.TrimSpace(nwInfo.Name)) == 0 {
+		return nil, grpc.Errorf(codes.InvalidArgument, "name is required")
+	}
+
 	if len(strings.TrimSpace(nwInfo.Driver)) > 1 {
 		driver = new(api.Driver)
-		driver.Name = nwInfo.Name
+		driver.Name = nwInfo.Driver
 
 		if len(nwInfo.Opts) > 0 {
 			driver.Options = nwInfo.Opts
@@ -82,26 +101,19 @@ func createNetworks(c *context, w http.ResponseWriter, r *http.Request) {
 	}
 
 	// parse api.IPAMOptions
-	if ipamOpts, err = processIPAMOptions(nwInfo); err != nil {
-		errResponse(w, r, err, c)
-		return
+	ipamOpts, err := processIPAMOptions(nwInfo)
+	if err != nil {
+		return nil, invalidArgument(err)
 	}
 
-	spec := &api.NetworkSpec{
+	return &api.NetworkSpec{
 		Annotations: api.Annotations{
-			Name: nwInfo.Name,
+			Name:   nwInfo.Name,
+			Labels: nwInfo.Labels,
 		},
 		DriverConfig: driver,
 		IPAM:         ipamOpts,
-	}
-
-	var cnResp *api.CreateNetworkResponse
-	if cnResp, err = c.swarmkitAPI.CreateNetwork(ct.TODO(), &api.CreateNetworkRequest{Spec: spec}); err != nil {
-		errResponse(w, r, err, c)
-		return
-	}
-
-	c.render.JSON(w, http.StatusOK, cnResp.Network.ID)
+	}, nil
 }
 
 // DELETE /networks/{networkid:.*}
@@ -111,12 +123,13 @@ func removeNetworks(c *context, w http.ResponseWriter, r *http.Request) {
 		network   *api.Network
 		networkid = mux.Vars(r)["networkid"]
 	)
-	if network, err = swarmkit.GetNetwork(ct.TODO(), c.swarmkitAPI, networkid); err != nil {
+	if network, err = swarmkit.GetNetwork(r.Context(), c.swarmkitAPI, networkid); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
 
-	if _, err = c.swarmkitAPI.RemoveNetwork(ct.TODO(), &api.RemoveNetworkRequest{NetworkID: network.ID}); err != nil {
+	auditObject(r, network.ID)
+	if _, err = c.swarmkitAPI.RemoveNetwork(r.Context(), &api.RemoveNetworkRequest{NetworkID: network.ID}); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
@@ -125,6 +138,7 @@ func removeNetworks(c *context, w http.ResponseWriter, r *http.Request) {
 
 type networkInfo struct {
 	Name       string            `json:"name"`
+	Labels     map[string]string `json:"labels"`
 	Driver     string            `json:"driver"`
 	Opts       map[string]string `json:"opts"`
 	IpamDriver string            `json:"ipam_driver"`
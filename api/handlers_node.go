@@ -1,23 +1,21 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
 	"strings"
 
-	log "github.com/Sirupsen/logrus"
 	"github.com/docker/swarmkit/api"
 	"github.com/gorilla/mux"
 	"github.com/shenshouer/swarmkit-client/swarmkit"
-	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 // GET /nodes
 func listNodes(c *context, w http.ResponseWriter, r *http.Request) {
-	lsNodeRes, err := c.swarmkitAPI.ListNodes(ct.TODO(), &api.ListNodesRequest{})
+	lsNodeRes, err := c.swarmkitAPI.ListNodes(r.Context(), &api.ListNodesRequest{})
 	if err != nil {
-		log.WithFields(log.Fields{"method": r.Method, "route": r.RequestURI}).Errorln(err)
-		c.render.JSON(w, http.StatusBadRequest, map[string]interface{}{"msg": err})
+		errResponse(w, r, err, c)
 		return
 	}
 	c.render.JSON(w, http.StatusOK, lsNodeRes.Nodes)
@@ -39,16 +37,15 @@ func inspectNode(c *context, w http.ResponseWriter, r *http.Request) {
 		all = true
 	}
 
-	if node, err = swarmkit.GetNode(ct.TODO(), c.swarmkitAPI, nodeid); err != nil {
+	if node, err = swarmkit.GetNode(r.Context(), c.swarmkitAPI, nodeid); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
 
 	// TODO(aluzzardi): This should be implemented as a ListOptions filter.
-	ltRes, err := c.swarmkitAPI.ListTasks(ct.TODO(), &api.ListTasksRequest{})
+	ltRes, err := c.swarmkitAPI.ListTasks(r.Context(), &api.ListTasksRequest{})
 	if err != nil {
-		log.WithFields(log.Fields{"method": r.Method, "route": r.RequestURI}).Errorln(err)
-		c.render.JSON(w, http.StatusBadRequest, map[string]interface{}{"msg": err})
+		errResponse(w, r, err, c)
 		return
 	}
 
@@ -63,7 +60,7 @@ func inspectNode(c *context, w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	c.render.JSON(w, http.StatusBadRequest, map[string]interface{}{"node": node, "tasks": tasks})
+	c.render.JSON(w, http.StatusOK, map[string]interface{}{"node": node, "tasks": tasks})
 }
 
 // POST /nodes/{nodeid:.*}/accept
@@ -73,17 +70,19 @@ func acceptNode(c *context, w http.ResponseWriter, r *http.Request) {
 		node   *api.Node
 		nodeid = mux.Vars(r)["nodeid"]
 	)
-	if node, err = swarmkit.GetNode(ct.TODO(), c.swarmkitAPI, nodeid); err != nil {
+	if node, err = swarmkit.GetNode(r.Context(), c.swarmkitAPI, nodeid); err != nil {
 		errResponse(w, r, err, c)
+		return
 	}
+	auditObject(r, node.ID)
 	spec := &node.Spec
 	if spec.Membership == api.NodeMembershipAccepted {
-		errResponse(w, r, fmt.Errorf("Node %s was already accepted", nodeid), c)
+		errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "Node %s was already accepted", nodeid), c)
 		return
 	}
 
 	spec.Membership = api.NodeMembershipAccepted
-	if _, err = c.swarmkitAPI.UpdateNode(ct.TODO(), &api.UpdateNodeRequest{
+	if _, err = c.swarmkitAPI.UpdateNode(r.Context(), &api.UpdateNodeRequest{
 		NodeID:      node.ID,
 		NodeVersion: &node.Meta.Version,
 		Spec:        spec,
@@ -102,12 +101,13 @@ func removeNode(c *context, w http.ResponseWriter, r *http.Request) {
 		nodeid = mux.Vars(r)["nodeid"]
 	)
 
-	if node, err = swarmkit.GetNode(ct.TODO(), c.swarmkitAPI, nodeid); err != nil {
+	if node, err = swarmkit.GetNode(r.Context(), c.swarmkitAPI, nodeid); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
 
-	if _, err = c.swarmkitAPI.RemoveNode(ct.TODO(), &api.RemoveNodeRequest{NodeID: node.ID}); err != nil {
+	auditObject(r, node.ID)
+	if _, err = c.swarmkitAPI.RemoveNode(r.Context(), &api.RemoveNodeRequest{NodeID: node.ID}); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
@@ -121,18 +121,19 @@ func activateNode(c *context, w http.ResponseWriter, r *http.Request) {
 		node   *api.Node
 		nodeid = mux.Vars(r)["nodeid"]
 	)
-	if node, err = swarmkit.GetNode(ct.TODO(), c.swarmkitAPI, nodeid); err != nil {
+	if node, err = swarmkit.GetNode(r.Context(), c.swarmkitAPI, nodeid); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
 
+	auditObject(r, node.ID)
 	spec := &node.Spec
 	if spec.Availability == api.NodeAvailabilityActive {
-		errResponse(w, r, fmt.Errorf("Node %s is already active", nodeid), c)
+		errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "Node %s is already active", nodeid), c)
 		return
 	}
 
-	if _, err = c.swarmkitAPI.UpdateNode(ct.TODO(), &api.UpdateNodeRequest{
+	if _, err = c.swarmkitAPI.UpdateNode(r.Context(), &api.UpdateNodeRequest{
 		NodeID:      node.ID,
 		NodeVersion: &node.Meta.Version,
 		Spec:        spec,
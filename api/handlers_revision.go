@@ -0,0 +1,181 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/gorilla/mux"
+	"github.com/shenshouer/swarmkit-client/swarmkit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func revisionsEnabled(c *context, w http.ResponseWriter, r *http.Request) bool {
+	if c.revisions == nil {
+		errResponse(w, r, grpc.Errorf(codes.Unimplemented, "service revisions need a data directory"), c)
+		return false
+	}
+	return true
+}
+
+// GET /services/{serviceid:.*}/revisions
+// Revisions are numbered from 1, oldest first. A service changed outside of
+// this api before its first change here gets an "observed" revision 1.
+func listRevisions(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err       error
+		service   *api.Service
+		revs      []*serviceRevision
+		serviceid = mux.Vars(r)["serviceid"]
+	)
+
+	if !revisionsEnabled(c, w, r) {
+		return
+	}
+	if service, err = swarmkit.GetService(r.Context(), c.swarmkitAPI, serviceid); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	if revs, err = c.revisions.list(service.ID); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+
+	c.render.JSON(w, http.StatusOK, revs)
+}
+
+// GET /services/{serviceid:.*}/revisions/{revision:[0-9]+}/diff?against=
+//    against: revision compared to, default the previous one
+func diffRevision(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err       error
+		service   *api.Service
+		n, from   uint64
+		rev       *serviceRevision
+		old       = &api.ServiceSpec{}
+		serviceid = mux.Vars(r)["serviceid"]
+	)
+
+	if !revisionsEnabled(c, w, r) {
+		return
+	}
+	if n, err = strconv.ParseUint(mux.Vars(r)["revision"], 10, 64); err != nil {
+		errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid revision: %v", err), c)
+		return
+	}
+	from = n - 1
+	if s := r.URL.Query().Get("against"); len(s) > 0 {
+		if from, err = strconv.ParseUint(s, 10, 64); err != nil {
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid against: %v", err), c)
+			return
+		}
+	}
+
+	if service, err = swarmkit.GetService(r.Context(), c.swarmkitAPI, serviceid); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	if rev, err = c.revisions.get(service.ID, n); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	// revision 0 is the empty spec, so the first revision shows every field
+	if from > 0 {
+		against, err := c.revisions.get(service.ID, from)
+		if err != nil {
+			errResponse(w, r, err, c)
+			return
+		}
+		old = against.Spec
+	}
+
+	c.render.JSON(w, http.StatusOK, map[string]interface{}{
+		"service_id": service.ID,
+		"from":       from,
+		"to":         n,
+		"changes":    diffSpecs(old, rev.Spec),
+	})
+}
+
+// POST /services/{serviceid:.*}/rollback?to=&version=
+//    to:      revision to restore, default the latest one whose spec is not the
+//             current spec of the service
+//    version: current Meta.Version.Index of the service, the rollback fails
+//             with 409 if the service changed since
+// The restored spec is recorded as a new revision.
+func rollbackService(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err       error
+		service   *api.Service
+		n         uint64
+		rev       *serviceRevision
+		q         = r.URL.Query()
+		serviceid = mux.Vars(r)["serviceid"]
+	)
+
+	if !revisionsEnabled(c, w, r) {
+		return
+	}
+	if service, err = swarmkit.GetService(r.Context(), c.swarmkitAPI, serviceid); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	auditObject(r, service.ID)
+
+	version := service.Meta.Version
+	if s := q.Get("version"); len(s) > 0 {
+		if version.Index, err = strconv.ParseUint(s, 10, 64); err != nil {
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid version: %v", err), c)
+			return
+		}
+	}
+
+	if s := q.Get("to"); len(s) > 0 {
+		if n, err = strconv.ParseUint(s, 10, 64); err != nil {
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid to: %v", err), c)
+			return
+		}
+	} else {
+		// the latest revision is not the current spec when the service was
+		// changed outside of this api or rolled back by swarm
+		other, err := c.revisions.latestOther(service.ID, &service.Spec)
+		if err != nil {
+			errResponse(w, r, err, c)
+			return
+		}
+		if other == nil {
+			errResponse(w, r, grpc.Errorf(codes.FailedPrecondition, "service %s has no previous revision", serviceid), c)
+			return
+		}
+		n = other.Revision
+	}
+	if rev, err = c.revisions.get(service.ID, n); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+
+	if reflect.DeepEqual(rev.Spec, &service.Spec) {
+		errResponse(w, r, grpc.Errorf(codes.FailedPrecondition, "service %s already runs revision %d", serviceid, n), c)
+		return
+	}
+	auditSpecs(r, &service.Spec, rev.Spec)
+
+	var usResp *api.UpdateServiceResponse
+	if usResp, err = c.swarmkitAPI.UpdateService(r.Context(), &api.UpdateServiceRequest{
+		ServiceID:      service.ID,
+		ServiceVersion: &version,
+		Spec:           rev.Spec,
+	}); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	recordRevision(c, r, usResp.Service, service, "rollback", n)
+
+	c.render.JSON(w, http.StatusOK, map[string]interface{}{
+		"id":          usResp.Service.ID,
+		"rollback_of": n,
+		"changes":     diffSpecs(&service.Spec, rev.Spec),
+	})
+}
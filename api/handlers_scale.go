@@ -0,0 +1,189 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/gorilla/mux"
+	"github.com/shenshouer/swarmkit-client/swarmkit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// replicaCount is an absolute number of replicas (3 or "3") or a change of
+// the current number ("+2", "-1").
+type replicaCount string
+
+// UnmarshalJSON accepts json numbers and strings.
+func (rc *replicaCount) UnmarshalJSON(b []byte) error {
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err == nil {
+		*rc = replicaCount(n.String())
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("replicas must be a number or a string like \"+2\"")
+	}
+	*rc = replicaCount(strings.TrimSpace(s))
+	return nil
+}
+
+// apply returns the number of replicas after applying rc to current.
+func (rc replicaCount) apply(current uint64) (uint64, error) {
+	s := string(rc)
+	if len(s) == 0 {
+		return 0, grpc.Errorf(codes.InvalidArgument, "replicas is required")
+	}
+	if s[0] != '+' && s[0] != '-' {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return 0, grpc.Errorf(codes.InvalidArgument, "invalid replicas %q", s)
+		}
+		return n, nil
+	}
+
+	delta, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, grpc.Errorf(codes.InvalidArgument, "invalid replicas %q", s)
+	}
+	if delta < 0 && uint64(-delta) > current {
+		return 0, grpc.Errorf(codes.InvalidArgument, "cannot scale %d replicas by %s", current, s)
+	}
+	return uint64(int64(current) + delta), nil
+}
+
+// scaleResult is the outcome of scaling one service.
+type scaleResult struct {
+	Service     string `json:"service"` // service as given in the request
+	ID          string `json:"id,omitempty"`
+	OldReplicas uint64 `json:"old_replicas"`
+	NewReplicas uint64 `json:"new_replicas"`
+	Error       string `json:"error,omitempty"`
+}
+
+// scale sets the replicas of the service named or identified by input and
+// records the new spec as a revision.
+func scale(c *context, r *http.Request, input string, count replicaCount) (*scaleResult, *api.Service, error) {
+	service, err := swarmkit.GetService(r.Context(), c.swarmkitAPI, input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res := &scaleResult{Service: input, ID: service.ID}
+	replicated := service.Spec.GetReplicated()
+	if replicated == nil {
+		return res, service, grpc.Errorf(codes.FailedPrecondition,
+			"service %s is a global service, only replicated services can be scaled", input)
+	}
+
+	res.OldReplicas = replicated.Replicas
+	if res.NewReplicas, err = count.apply(replicated.Replicas); err != nil {
+		return res, service, err
+	}
+	if res.NewReplicas == res.OldReplicas {
+		return res, service, nil
+	}
+
+	spec := service.Spec.Copy()
+	spec.GetReplicated().Replicas = res.NewReplicas
+	usResp, err := c.swarmkitAPI.UpdateService(r.Context(), &api.UpdateServiceRequest{
+		ServiceID:      service.ID,
+		ServiceVersion: &service.Meta.Version,
+		Spec:           spec,
+	})
+	if err != nil {
+		return res, service, err
+	}
+	recordRevision(c, r, usResp.Service, service, "scale", 0)
+	return res, usResp.Service, nil
+}
+
+// POST /services/{serviceid:.*}/scale
+// {
+//    replicas: 3,      // absolute number of replicas, or a change like "+2" or "-1"
+// }
+func scaleService(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err       error
+		res       *scaleResult
+		service   *api.Service
+		serviceid = mux.Vars(r)["serviceid"]
+		body      = &struct {
+			Replicas replicaCount `json:"replicas"`
+		}{}
+	)
+
+	if err = DecoderRequest(r, body); err != nil {
+		err = grpc.Errorf(codes.InvalidArgument, "Parse params for scale service error:%v", err)
+		errResponse(w, r, err, c)
+		return
+	}
+
+	res, service, err = scale(c, r, serviceid, body.Replicas)
+	if service != nil {
+		auditObject(r, service.ID)
+	}
+	if err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+
+	c.render.JSON(w, http.StatusOK, res)
+}
+
+// POST /services/scale
+// {
+//    services: {"redis": 3, "web": "+2"},    // service name or ID and replicas, as for a single service
+// }
+// Every service is scaled even if another one fails, each result carries its
+// own error. The services are scaled in the order of their sorted inputs.
+func scaleServices(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		results = []*scaleResult{}
+		body    = &struct {
+			Services map[string]replicaCount `json:"services"`
+		}{}
+	)
+
+	if err = DecoderRequest(r, body); err != nil {
+		err = grpc.Errorf(codes.InvalidArgument, "Parse params for scale services error:%v", err)
+		errResponse(w, r, err, c)
+		return
+	}
+	if len(body.Services) == 0 {
+		errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "services are mandatory"), c)
+		return
+	}
+
+	// scale in a stable order, not the random order of the map
+	inputs := []string{}
+	for input := range body.Services {
+		inputs = append(inputs, input)
+	}
+	sort.Strings(inputs)
+
+	ids := []string{}
+	for _, input := range inputs {
+		res, service, err := scale(c, r, input, body.Services[input])
+		if res == nil {
+			res = &scaleResult{Service: input}
+		}
+		if service != nil {
+			ids = append(ids, service.ID)
+		}
+		if err != nil {
+			res.Error = grpc.ErrorDesc(err)
+		}
+		results = append(results, res)
+	}
+	auditObject(r, strings.Join(ids, ","))
+
+	c.render.JSON(w, http.StatusOK, results)
+}
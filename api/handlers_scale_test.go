@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestReplicaCountApply(t *testing.T) {
+	tests := []struct {
+		count   replicaCount
+		current uint64
+		n       uint64
+		err     bool
+	}{
+		{"3", 1, 3, false},
+		{"0", 4, 0, false},
+		{"+2", 1, 3, false},
+		{"+0", 2, 2, false},
+		{"-1", 3, 2, false},
+		{"-3", 3, 0, false},
+		{"-4", 3, 0, true},
+		{"", 1, 0, true},
+		{"three", 1, 0, true},
+		{"+two", 1, 0, true},
+		{"1.5", 1, 0, true},
+	}
+	for _, test := range tests {
+		n, err := test.count.apply(test.current)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q.apply(%d) = %d, want an error", test.count, test.current, n)
+			} else if code := grpc.Code(err); code != codes.InvalidArgument {
+				t.Errorf("%q.apply(%d) error code = %s, want InvalidArgument", test.count, test.current, code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q.apply(%d): %v", test.count, test.current, err)
+			continue
+		}
+		if n != test.n {
+			t.Errorf("%q.apply(%d) = %d, want %d", test.count, test.current, n, test.n)
+		}
+	}
+}
+
+func TestReplicaCountUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json  string
+		count replicaCount
+		err   bool
+	}{
+		{`3`, "3", false},
+		{`"3"`, "3", false},
+		{`"+2"`, "+2", false},
+		{`true`, "", true},
+	}
+	for _, test := range tests {
+		var count replicaCount
+		err := json.Unmarshal([]byte(test.json), &count)
+		if test.err {
+			if err == nil {
+				t.Errorf("unmarshal %s = %q, want an error", test.json, count)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unmarshal %s: %v", test.json, err)
+		} else if count != test.count {
+			t.Errorf("unmarshal %s = %q, want %q", test.json, count, test.count)
+		}
+	}
+}
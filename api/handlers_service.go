@@ -1,8 +1,6 @@
 package api
 
 import (
-	"errors"
-	"fmt"
 	"net/http"
 	"reflect"
 	"strings"
@@ -10,18 +8,31 @@ import (
 	"github.com/docker/swarmkit/api"
 	"github.com/gorilla/mux"
 	"github.com/shenshouer/swarmkit-client/swarmkit"
-	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 // GET /services
+// Every service has a status: desired and running replicas, current tasks by
+// state, node coverage in global mode, the last failure and a health of
+// converged, updating, degraded or failing.
 func listService(c *context, w http.ResponseWriter, r *http.Request) {
-	sresp, err := c.swarmkitAPI.ListServices(ct.TODO(), &api.ListServicesRequest{})
+	sresp, err := c.swarmkitAPI.ListServices(r.Context(), &api.ListServicesRequest{})
 	if err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
 
-	c.render.JSON(w, http.StatusOK, sresp.Services)
+	statuses, err := serviceStatuses(r.Context(), c.swarmkitAPI, sresp.Services)
+	if err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	services := []*serviceWithStatus{}
+	for _, s := range sresp.Services {
+		services = append(services, &serviceWithStatus{Service: s, Status: statuses[s.ID]})
+	}
+	c.render.JSON(w, http.StatusOK, services)
 }
 
 // POST /services/create
@@ -29,17 +40,30 @@ func listService(c *context, w http.ResponseWriter, r *http.Request) {
 //    name:"redis",
 //    image:"redis:3.0.5",
 //    labels:{"com.docker.test":"test"},        // service label (key=value)
+//    label-add:[], label-rm:[],                // service labels to set (key=value) or remove (key) on update
 //    mode:"",                                  // one of replicated, global
 //    replicas: 1,                              // number of replicas for the service (only works in replicated service mode)
 //    image: "redis:3.0.5",                     // container image
+//    command: [],                              // override entrypoint
 //    args: [],                                 // container args
 //    env: [],                                  // container env
+//    env-add: [], env-rm: [],                  // env variables to set (KEY=VALUE) or remove (KEY) on update
+//    dir: "",                                  // working directory of the container
+//    user: "",                                 // user running the container (name, uid or uid:gid)
+//    container-labels: {},                     // container labels (key=value)
+//    stop-grace-period: "10s",                 // time to wait before force killing a container
 //    ports: [],                                // ports
+//    publish-add: [], publish-rm: [],          // ports to publish (as ports) or unpublish (80 or 53/udp) on update
+//    endpoint-mode:"vip",                      // service discovery mode (vip or dnsrr)
 //    network:"",                               // network name
+//    networks:[],                              // network name or ID, or {target, aliases} objects
+//    network-add:[],                           // networks to attach on update, as networks
+//    network-rm:[],                            // networks to detach on update
 //    memory-reservation: "",                   // amount of reserved memory (e.g. 512m)
 //    memory-limit: "",                         // memory limit (e.g. 512m)
 //    cpu-reservation:"",                       // number of CPU cores reserved (e.g. 0.5)
 //    cpu-limit:"",                             // CPU cores limit (e.g. 0.5)
+//                                              // reservations fail with 409 when no eligible node has them free
 //    update-parallelism:0,                     // task update parallelism (0 = all at once)
 //    update-delay:"0s",                        // delay between task updates (0s = none)
 //    restart-condition:"any",                  // condition to restart the task (any, failure, none)
@@ -47,24 +71,37 @@ func listService(c *context, w http.ResponseWriter, r *http.Request) {
 //    restart-max-attempts:0,                   // maximum number of restart attempts (0 = unlimited)
 //    restart-window:"0s",                      // time window to evaluate restart attempts (0 = unbound)
 //    constraint:[],                            // Placement constraint (node.labels.key==value)
-//    bind:[],                                  // define a bind mount
-//    volume:[],                                // define a volume mount
+//    constraint-add:[], constraint-rm:[],      // placement constraints to add or remove on update
+//    bind:[],                                  // define a bind mount (source:target[:ro])
+//    volume:[],                                // define a volume mount ([name:]target[:ro])
+//    mounts:[],                                // mounts as objects ({type, source, target, readonly, ...})
+//    mount:[],                                 // mounts with the docker --mount syntax (type=volume,source=data,target=/data)
+//    mount-add:[], mount-rm:[],                // mounts to add (as mount) or targets of the mounts to remove on update
 // }
+// The -add and -rm fields change the current value on update, a key cannot be
+// both added and removed nor changed while the whole field is replaced (409).
+// With ?dry_run=1 nothing is created: the response is the resolved spec, its
+// diff against an empty spec and the validation errors.
 func createService(c *context, w http.ResponseWriter, r *http.Request) {
 	var (
-		err   error
-		cspec = &createSpec{}
+		err    error
+		cspec  = &createSpec{}
+		dryRun = queryBool(r, "dry_run")
+		errs   = []string{}
 	)
 	if err = DecoderRequest(r, cspec); err != nil {
-		err = fmt.Errorf("Parse params for create service error:%v", err)
+		err = grpc.Errorf(codes.InvalidArgument, "Parse params for create service error:%v", err)
 		errResponse(w, r, err, c)
 		return
 	}
 
 	if len(strings.TrimSpace(cspec.Name)) == 0 || len(strings.TrimSpace(cspec.Image)) == 0 {
-		err = fmt.Errorf("name and image are mandatory")
-		errResponse(w, r, err, c)
-		return
+		err = grpc.Errorf(codes.InvalidArgument, "name and image are mandatory")
+		if !dryRun {
+			errResponse(w, r, err, c)
+			return
+		}
+		errs = append(errs, grpc.ErrorDesc(err))
 	}
 
 	spec := &api.ServiceSpec{
@@ -80,24 +117,62 @@ func createService(c *context, w http.ResponseWriter, r *http.Request) {
 		},
 	}
 
-	if err = merge(cspec, spec, c.swarmkitAPI); err != nil {
-		errResponse(w, r, err, c)
+	if err = merge(r.Context(), cspec, spec, c.swarmkitAPI); err == nil {
+		err = checkResources(r.Context(), c.swarmkitAPI, spec, nil, "")
+	}
+	if err != nil {
+		if !dryRun {
+			errResponse(w, r, err, c)
+			return
+		}
+		errs = append(errs, grpc.ErrorDesc(err))
+	}
+
+	if dryRun {
+		c.render.JSON(w, http.StatusOK, newDryRunResult(&api.ServiceSpec{}, spec, errs))
 		return
 	}
 
 	var csResp *api.CreateServiceResponse
-	if csResp, err = c.swarmkitAPI.CreateService(ct.TODO(), &api.CreateServiceRequest{Spec: spec}); err != nil {
+	if csResp, err = c.swarmkitAPI.CreateService(r.Context(), &api.CreateServiceRequest{Spec: spec}); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
 
+	auditObject(r, csResp.Service.ID)
+	recordRevision(c, r, csResp.Service, nil, "create", 0)
 	c.render.JSON(w, http.StatusOK, csResp.Service)
 }
 
-// GET /services/{serviceid:.*}?all=1
+// dryRunResult is what a create or update would apply.
+type dryRunResult struct {
+	Spec    *api.ServiceSpec `json:"spec"`
+	Changes []specChange     `json:"changes"`
+	Diff    []string         `json:"diff"` // changes formatted for humans
+	Errors  []string         `json:"errors"`
+	Valid   bool             `json:"valid"`
+}
+
+func newDryRunResult(current, spec *api.ServiceSpec, errs []string) *dryRunResult {
+	res := &dryRunResult{
+		Spec:    spec,
+		Changes: diffSpecs(current, spec),
+		Diff:    []string{},
+		Errors:  errs,
+		Valid:   len(errs) == 0,
+	}
+	for _, change := range res.Changes {
+		res.Diff = append(res.Diff, change.String())
+	}
+	return res
+}
+
+// GET /services/{serviceid:[^/]+}?all=1
 //    all:0 only display running
 //		  1 display all
 //	  default 0
+// status is computed from every task as in GET /services, request is the
+// body of a create or update producing the service spec.
 func inspectService(c *context, w http.ResponseWriter, r *http.Request) {
 	var (
 		err       error
@@ -105,14 +180,15 @@ func inspectService(c *context, w http.ResponseWriter, r *http.Request) {
 		lsTask    *api.ListTasksResponse
 		serviceid = mux.Vars(r)["serviceid"]
 		tasks     = make([]*api.Task, 0)
+		all       = queryBool(r, "all")
 	)
 
-	if service, err = swarmkit.GetService(ct.TODO(), c.swarmkitAPI, serviceid); err != nil {
+	if service, err = swarmkit.GetService(r.Context(), c.swarmkitAPI, serviceid); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
 
-	if lsTask, err = c.swarmkitAPI.ListTasks(ct.TODO(), &api.ListTasksRequest{
+	if lsTask, err = c.swarmkitAPI.ListTasks(r.Context(), &api.ListTasksRequest{
 		Filters: &api.ListTasksRequest_Filters{
 			ServiceIDs: []string{service.ID},
 		},
@@ -122,57 +198,101 @@ func inspectService(c *context, w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, t := range lsTask.Tasks {
-		if t.Status.State == api.TaskStateRunning {
+		if all || t.Status.State == api.TaskStateRunning {
 			tasks = append(tasks, t)
 		}
 	}
 
+	var nodes []*api.Node
+	if service.Spec.GetGlobal() != nil {
+		lsNode, err := c.swarmkitAPI.ListNodes(r.Context(), &api.ListNodesRequest{})
+		if err != nil {
+			errResponse(w, r, err, c)
+			return
+		}
+		nodes = lsNode.Nodes
+	}
+
 	c.render.JSON(w, http.StatusOK, map[string]interface{}{
 		"service": service,
 		"tasks":   tasks,
+		"status":  computeServiceStatus(service, lsTask.Tasks, nodes),
+		"request": exportSpec(&service.Spec),
 	})
 }
 
-// POST /services/{serviceid:.*}/update
+// POST /services/{serviceid:.*}/update?dry_run=1&wait=1&stream=1&timeout=&task_timeout=&max_failure_ratio=&rollback=1
+//    dry_run: return the resolved spec, its diff against the current spec and
+//             the validation errors without updating the service
+//    wait: respond once every task runs the new spec, or the rollout failed
+//    stream: with wait, stream the progress as json lines (or Server-Sent
+//            Events when text/event-stream is accepted)
+//    timeout: deadline of the rollout, by default task_timeout (1m) per batch
+//             of update-parallelism tasks plus the update-delay between them
+//    max_failure_ratio: share of the desired tasks allowed to fail (0 = none)
+//    rollback: re-apply the previous spec when the rollout fails (default 1)
 func updateService(c *context, w http.ResponseWriter, r *http.Request) {
 	var (
 		err       error
 		service   *api.Service
 		cspec     = &createSpec{}
 		serviceid = mux.Vars(r)["serviceid"]
+		dryRun    = queryBool(r, "dry_run")
+		rollout   *rolloutOptions
 	)
 
 	if len(strings.TrimSpace(serviceid)) <= 1 {
-		err = errors.New("service ID missing")
+		err = grpc.Errorf(codes.InvalidArgument, "service ID missing")
 		errResponse(w, r, err, c)
 		return
 	}
 
+	if queryBool(r, "wait") {
+		if rollout, err = parseRolloutOptions(r); err != nil {
+			errResponse(w, r, err, c)
+			return
+		}
+	}
+
 	if err = DecoderRequest(r, cspec); err != nil {
-		err = fmt.Errorf("Parse params for create service error:%v", err)
+		err = grpc.Errorf(codes.InvalidArgument, "Parse params for create service error:%v", err)
 		errResponse(w, r, err, c)
 		return
 	}
 
-	if service, err = swarmkit.GetService(ct.TODO(), c.swarmkitAPI, serviceid); err != nil {
+	if service, err = swarmkit.GetService(r.Context(), c.swarmkitAPI, serviceid); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
 
+	auditObject(r, service.ID)
 	spec := service.Spec.Copy()
-	if err = merge(cspec, spec, c.swarmkitAPI); err != nil {
+	if err = merge(r.Context(), cspec, spec, c.swarmkitAPI); err == nil {
+		err = checkResources(r.Context(), c.swarmkitAPI, spec, &service.Spec, service.ID)
+	}
+	if err != nil {
+		if dryRun {
+			c.render.JSON(w, http.StatusOK, newDryRunResult(&service.Spec, spec, []string{grpc.ErrorDesc(err)}))
+			return
+		}
 		errResponse(w, r, err, c)
 		return
 	}
+	if dryRun {
+		c.render.JSON(w, http.StatusOK, newDryRunResult(&service.Spec, spec, []string{}))
+		return
+	}
 
 	if reflect.DeepEqual(spec, &service.Spec) {
-		err = errors.New("no changes detected")
+		err = grpc.Errorf(codes.InvalidArgument, "no changes detected")
 		errResponse(w, r, err, c)
 		return
 	}
 
+	auditSpecs(r, &service.Spec, spec)
+
 	var usResp *api.UpdateServiceResponse
-	if usResp, err = c.swarmkitAPI.UpdateService(ct.TODO(), &api.UpdateServiceRequest{
+	if usResp, err = c.swarmkitAPI.UpdateService(r.Context(), &api.UpdateServiceRequest{
 		ServiceID:      service.ID,
 		ServiceVersion: &service.Meta.Version,
 		Spec:           spec,
@@ -180,7 +300,12 @@ func updateService(c *context, w http.ResponseWriter, r *http.Request) {
 		errResponse(w, r, err, c)
 		return
 	}
+	recordRevision(c, r, usResp.Service, service, "update", 0)
 
+	if rollout != nil {
+		waitRollout(c, w, r, service, usResp.Service, rollout)
+		return
+	}
 	c.render.JSON(w, http.StatusOK, map[string]interface{}{"id": usResp.Service.ID})
 }
 
@@ -192,15 +317,17 @@ func removeService(c *context, w http.ResponseWriter, r *http.Request) {
 		serviceid = mux.Vars(r)["name"]
 	)
 
-	if service, err = swarmkit.GetService(ct.TODO(), c.swarmkitAPI, serviceid); err != nil {
+	if service, err = swarmkit.GetService(r.Context(), c.swarmkitAPI, serviceid); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
 
-	if _, err = c.swarmkitAPI.RemoveService(ct.TODO(), &api.RemoveServiceRequest{ServiceID: service.ID}); err != nil {
+	auditObject(r, service.ID)
+	if _, err = c.swarmkitAPI.RemoveService(r.Context(), &api.RemoveServiceRequest{ServiceID: service.ID}); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
+	forgetRevisions(c, service.ID)
 
 	c.render.JSON(w, http.StatusOK, map[string]interface{}{"name": serviceid})
 }
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/gorilla/mux"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// maxComposeSize is the maximum size of a compose file.
+const maxComposeSize = 1 << 20
+
+var stackName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// stackObjects returns the services and networks labeled with stack, every
+// stack when stack is empty.
+func stackObjects(ctx ct.Context, c api.ControlClient, stack string) ([]*api.Service, []*api.Network, error) {
+	services, err := c.ListServices(ctx, &api.ListServicesRequest{})
+	if err != nil {
+		return nil, nil, err
+	}
+	networks, err := c.ListNetworks(ctx, &api.ListNetworksRequest{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		ss []*api.Service
+		ns []*api.Network
+	)
+	for _, s := range services.Services {
+		if name, ok := s.Spec.Annotations.Labels[stackLabel]; ok && (len(stack) == 0 || name == stack) {
+			ss = append(ss, s)
+		}
+	}
+	for _, n := range networks.Networks {
+		if name, ok := n.Spec.Annotations.Labels[stackLabel]; ok && (len(stack) == 0 || name == stack) {
+			ns = append(ns, n)
+		}
+	}
+	return ss, ns, nil
+}
+
+// deployStack creates the missing networks, creates or updates the services
+// of f and, when none failed, removes the services and networks of the stack
+// that are no longer in f.
+func deployStack(c *context, r *http.Request, stack string, f *composeFile) ([]*objectResult, error) {
+	// translate every service first, an invalid file changes nothing
+	names := []string{}
+	for name := range f.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cspecs := []*createSpec{}
+	for _, name := range names {
+		cspec, err := f.createSpec(stack, name)
+		if err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "%v", err)
+		}
+		cspecs = append(cspecs, cspec)
+	}
+
+	ctx := r.Context()
+	services, networks, err := stackObjects(ctx, c.swarmkitAPI, stack)
+	if err != nil {
+		return nil, err
+	}
+	results := []*objectResult{}
+
+	existingNetworks := make(map[string]*api.Network)
+	for _, n := range networks {
+		existingNetworks[n.Spec.Annotations.Name] = n
+	}
+	networkSpecs := f.networkSpecs(stack)
+	networkNames := make([]string, 0, len(networkSpecs))
+	for name := range networkSpecs {
+		networkNames = append(networkNames, name)
+	}
+	sort.Strings(networkNames)
+	for _, name := range networkNames {
+		spec := networkSpecs[name]
+		res := &objectResult{Kind: "network", Name: name, Action: "unchanged"}
+		if n, ok := existingNetworks[name]; ok {
+			res.ID = n.ID
+		} else {
+			resp, err := c.swarmkitAPI.CreateNetwork(ctx, &api.CreateNetworkRequest{Spec: spec})
+			if err != nil {
+				// the services cannot be attached without their networks
+				return nil, err
+			}
+			res.ID, res.Action = resp.Network.ID, "create"
+		}
+		results = append(results, res)
+	}
+
+	existingServices := make(map[string]*api.Service)
+	for _, s := range services {
+		existingServices[s.Spec.Annotations.Name] = s
+	}
+	deployed := make(map[string]bool)
+	for _, cspec := range cspecs {
+		deployed[cspec.Name] = true
+		current := existingServices[cspec.Name]
+		spec, res := planService(ctx, c.swarmkitAPI, cspec, current)
+		if len(res.Error) == 0 {
+			applyService(c, r, spec, current, res)
+		}
+		results = append(results, res)
+	}
+
+	// a failed service may still need the objects that are no longer in the
+	// file, they are removed by the next deploy
+	for _, res := range results {
+		if len(res.Error) > 0 {
+			return results, nil
+		}
+	}
+	for _, s := range services {
+		if !deployed[s.Spec.Annotations.Name] {
+			results = append(results, removeServiceObject(ctx, c, s))
+		}
+	}
+	for _, n := range networks {
+		if _, ok := networkSpecs[n.Spec.Annotations.Name]; !ok {
+			results = append(results, removeNetworkObject(ctx, c.swarmkitAPI, n))
+		}
+	}
+
+	return results, nil
+}
+
+// POST /stacks/{name:.*}
+// The body is a compose file, version 2 or 3. Services and networks are
+// named <stack>_<name> and labeled com.docker.stack.namespace=<stack>; the
+// services of the stack missing from the file are removed.
+func deployStackHandler(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err     error
+		b       []byte
+		f       *composeFile
+		results []*objectResult
+		name    = mux.Vars(r)["name"]
+	)
+
+	auditObject(r, name)
+	if !stackName.MatchString(name) {
+		errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid stack name %q", name), c)
+		return
+	}
+	if b, err = ioutil.ReadAll(io.LimitReader(r.Body, maxComposeSize)); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	if f, err = parseCompose(b); err != nil {
+		errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "%v", err), c)
+		return
+	}
+
+	if results, err = deployStack(c, r, name, f); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	c.render.JSON(w, http.StatusOK, results)
+}
+
+// GET /stacks
+func listStacks(c *context, w http.ResponseWriter, r *http.Request) {
+	services, networks, err := stackObjects(r.Context(), c.swarmkitAPI, "")
+	if err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+
+	type stackSummary struct {
+		Name     string `json:"name"`
+		Services int    `json:"services"`
+		Networks int    `json:"networks"`
+	}
+	stacks := make(map[string]*stackSummary)
+	summary := func(name string) *stackSummary {
+		if stacks[name] == nil {
+			stacks[name] = &stackSummary{Name: name}
+		}
+		return stacks[name]
+	}
+	for _, s := range services {
+		summary(s.Spec.Annotations.Labels[stackLabel]).Services++
+	}
+	for _, n := range networks {
+		summary(n.Spec.Annotations.Labels[stackLabel]).Networks++
+	}
+
+	names := []string{}
+	for name := range stacks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := []*stackSummary{}
+	for _, name := range names {
+		list = append(list, stacks[name])
+	}
+	c.render.JSON(w, http.StatusOK, list)
+}
+
+// GET /stacks/{name:.*}
+func inspectStack(c *context, w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	services, networks, err := stackObjects(r.Context(), c.swarmkitAPI, name)
+	if err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	if len(services) == 0 && len(networks) == 0 {
+		errResponse(w, r, notFound("stack", name), c)
+		return
+	}
+
+	c.render.JSON(w, http.StatusOK, map[string]interface{}{
+		"name":     name,
+		"services": services,
+		"networks": networks,
+	})
+}
+
+// DELETE /stacks/{name:.*}
+// Removes the services of the stack, then its networks.
+func removeStack(c *context, w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	auditObject(r, name)
+	services, networks, err := stackObjects(r.Context(), c.swarmkitAPI, name)
+	if err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	if len(services) == 0 && len(networks) == 0 {
+		errResponse(w, r, notFound("stack", name), c)
+		return
+	}
+
+	results := []*objectResult{}
+	for _, s := range services {
+		results = append(results, removeServiceObject(r.Context(), c, s))
+	}
+	for _, n := range networks {
+		results = append(results, removeNetworkObject(r.Context(), c.swarmkitAPI, n))
+	}
+	c.render.JSON(w, http.StatusOK, results)
+}
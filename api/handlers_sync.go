@@ -0,0 +1,19 @@
+package api
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// GET /sync/status
+// The last run of the sync controller: the files read, whether the cluster
+// matches them, the objects that drifted and the actions of the last apply.
+func syncStatusHandler(c *context, w http.ResponseWriter, r *http.Request) {
+	if c.sync == nil {
+		errResponse(w, r, grpc.Errorf(codes.Unimplemented, "sync is not enabled, set a sync directory"), c)
+		return
+	}
+	c.render.JSON(w, http.StatusOK, c.sync.Status())
+}
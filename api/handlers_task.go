@@ -6,7 +6,6 @@ import (
 
 	"github.com/docker/swarmkit/api"
 	"github.com/gorilla/mux"
-	ct "golang.org/x/net/context"
 )
 
 // GET /tasks?all=1&quiet=1
@@ -26,7 +25,7 @@ func listTasks(c *context, w http.ResponseWriter, r *http.Request) {
 		all = true
 	}
 
-	if listTaskResp, err = c.swarmkitAPI.ListTasks(ct.TODO(), &api.ListTasksRequest{}); err != nil {
+	if listTaskResp, err = c.swarmkitAPI.ListTasks(r.Context(), &api.ListTasksRequest{}); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
@@ -47,7 +46,7 @@ func inspectTasks(c *context, w http.ResponseWriter, r *http.Request) {
 		getTaskResp *api.GetTaskResponse
 	)
 
-	if getTaskResp, err = c.swarmkitAPI.GetTask(ct.TODO(), &api.GetTaskRequest{TaskID: taskid}); err != nil {
+	if getTaskResp, err = c.swarmkitAPI.GetTask(r.Context(), &api.GetTaskRequest{TaskID: taskid}); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
@@ -62,7 +61,8 @@ func removeTasks(c *context, w http.ResponseWriter, r *http.Request) {
 		err    error
 	)
 
-	if _, err = c.swarmkitAPI.RemoveTask(ct.TODO(), &api.RemoveTaskRequest{TaskID: taskid}); err != nil {
+	auditObject(r, taskid)
+	if _, err = c.swarmkitAPI.RemoveTask(r.Context(), &api.RemoveTaskRequest{TaskID: taskid}); err != nil {
 		errResponse(w, r, err, c)
 		return
 	}
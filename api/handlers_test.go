@@ -0,0 +1,62 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/shenshouer/swarmkit-client/swarmkit"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"deadline", ct.DeadlineExceeded, http.StatusGatewayTimeout, "DeadlineExceeded"},
+		{"canceled", ct.Canceled, http.StatusRequestTimeout, "Canceled"},
+		{"not found", notFound("service", "redis"), http.StatusNotFound, "NotFound"},
+		{"ambiguous", &swarmkit.AmbiguousError{Kind: "service", Input: "re", Matches: 2}, http.StatusBadRequest, "Ambiguous"},
+		{"manager not found", grpc.Errorf(codes.NotFound, "service redis not found"), http.StatusNotFound, "NotFound"},
+		{"invalid argument", grpc.Errorf(codes.InvalidArgument, "name is required"), http.StatusBadRequest, "InvalidArgument"},
+		{"unavailable", grpc.Errorf(codes.Unavailable, "no leader"), http.StatusServiceUnavailable, "Unavailable"},
+		// the error of the manager store on a stale version
+		{"version conflict", grpc.Errorf(codes.Unknown, "update out of sequence"), http.StatusConflict, "Aborted"},
+		{"unknown", grpc.Errorf(codes.Unknown, "boom"), http.StatusInternalServerError, "Unknown"},
+		{"no code", errors.New("boom"), http.StatusInternalServerError, "Unknown"},
+	}
+	for _, test := range tests {
+		status, code := errorStatus(test.err)
+		if status != test.status || code != test.code {
+			t.Errorf("%s: errorStatus = %d %s, want %d %s", test.name, status, code, test.status, test.code)
+		}
+	}
+}
+
+func TestInvalidArgument(t *testing.T) {
+	tests := []struct {
+		err  error
+		code codes.Code
+	}{
+		{errors.New("invalid label"), codes.InvalidArgument},
+		{grpc.Errorf(codes.FailedPrecondition, "conflict"), codes.FailedPrecondition},
+		{grpc.Errorf(codes.Unavailable, "no leader"), codes.Unavailable},
+	}
+	for _, test := range tests {
+		if code := grpc.Code(invalidArgument(test.err)); code != test.code {
+			t.Errorf("invalidArgument(%v) code = %s, want %s", test.err, code, test.code)
+		}
+	}
+	if err := invalidArgument(nil); err != nil {
+		t.Errorf("invalidArgument(nil) = %v, want nil", err)
+	}
+	nf := notFound("network", "frontend")
+	if err := invalidArgument(nf); err != nf {
+		t.Errorf("invalidArgument(%v) = %v, want it unchanged", nf, err)
+	}
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// withoutSecret returns a copy of h safe to return to the callers.
+func withoutSecret(h *webhook) *webhook {
+	c := *h
+	c.Secret = ""
+	return &c
+}
+
+func webhooksEnabled(c *context, w http.ResponseWriter, r *http.Request) bool {
+	if c.webhooks == nil {
+		errResponse(w, r, grpc.Errorf(codes.Unimplemented, "webhooks are not enabled, set a data directory"), c)
+		return false
+	}
+	return true
+}
+
+// GET /webhooks
+func listWebhooks(c *context, w http.ResponseWriter, r *http.Request) {
+	if !webhooksEnabled(c, w, r) {
+		return
+	}
+	hooks := []*webhook{}
+	for _, h := range c.webhooks.list() {
+		hooks = append(hooks, withoutSecret(h))
+	}
+	c.render.JSON(w, http.StatusOK, hooks)
+}
+
+// GET /webhooks/{webhookid:.*}
+// Returns the webhook with its recent deliveries and its dead letters.
+func inspectWebhook(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err       error
+		worker    *webhookWorker
+		dead      []*delivery
+		webhookid = mux.Vars(r)["webhookid"]
+	)
+	if !webhooksEnabled(c, w, r) {
+		return
+	}
+
+	if worker, err = c.webhooks.get(webhookid); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	if dead, err = c.webhooks.deadLetters(webhookid); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+
+	worker.Lock()
+	hook := withoutSecret(worker.hook)
+	worker.Unlock()
+	c.render.JSON(w, http.StatusOK, map[string]interface{}{
+		"webhook":      hook,
+		"deliveries":   worker.deliveries(),
+		"dead_letters": dead,
+	})
+}
+
+// POST /webhooks/create
+// {
+//    url: "https://example.com/hook",            // receiver of the events
+//    secret: "",                                 // key of the X-Swarmkit-Signature HMAC (sha256)
+//    filters: {"type":["task"], "name":["redis"], "state":["FAILED"]}, // same filters as GET /events
+//    max_retries: 5,                             // attempts after the first failure before dead lettering
+// }
+func createWebhook(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err  error
+		hook = &webhook{MaxRetries: 5}
+	)
+	if !webhooksEnabled(c, w, r) {
+		return
+	}
+
+	if err = DecoderRequest(r, hook); err != nil {
+		err = grpc.Errorf(codes.InvalidArgument, "Parse params for create webhook error:%v", err)
+		errResponse(w, r, err, c)
+		return
+	}
+
+	if err = c.webhooks.create(hook); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+
+	auditObject(r, hook.ID)
+	c.render.JSON(w, http.StatusOK, withoutSecret(hook))
+}
+
+// POST /webhooks/{webhookid:.*}/update
+// Same body as create, replaces the webhook.
+func updateWebhook(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err       error
+		hook      = &webhook{MaxRetries: 5}
+		webhookid = mux.Vars(r)["webhookid"]
+	)
+	if !webhooksEnabled(c, w, r) {
+		return
+	}
+
+	if err = DecoderRequest(r, hook); err != nil {
+		err = grpc.Errorf(codes.InvalidArgument, "Parse params for update webhook error:%v", err)
+		errResponse(w, r, err, c)
+		return
+	}
+
+	auditObject(r, webhookid)
+	if err = c.webhooks.update(webhookid, hook); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+
+	c.render.JSON(w, http.StatusOK, withoutSecret(hook))
+}
+
+// DELETE /webhooks/{webhookid:.*}
+func removeWebhook(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err       error
+		webhookid = mux.Vars(r)["webhookid"]
+	)
+	if !webhooksEnabled(c, w, r) {
+		return
+	}
+
+	auditObject(r, webhookid)
+	if err = c.webhooks.remove(webhookid); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	c.render.JSON(w, http.StatusOK, webhookid)
+}
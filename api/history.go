@@ -0,0 +1,272 @@
+package api
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+var historyBucket = []byte("events")
+
+const (
+	// pruneEvery is the number of inserts between two retention checks.
+	pruneEvery = 100
+	// historyQueueSize is the number of events waiting to be stored.
+	historyQueueSize = 1024
+	// historyDefaultLimit and historyMaxLimit bound the events returned by
+	// a query.
+	historyDefaultLimit = 1000
+	historyMaxLimit     = 10000
+)
+
+// historyStore persists the events in a bolt database of the data directory.
+// Keys are the event time followed by the event ID, both big endian, so a
+// time window is a range of keys. The events are stored by a writer
+// goroutine, the ones queued together in one transaction.
+type historyStore struct {
+	db        *bolt.DB
+	maxEvents int           // 0 = unlimited
+	maxAge    time.Duration // 0 = unlimited
+	queue     chan *Event
+
+	// owned by the writer
+	count   int // events in the bucket
+	inserts int
+}
+
+func newHistoryStore(dataDir string, maxEvents int, maxAge time.Duration) (*historyStore, error) {
+	db, err := bolt.Open(filepath.Join(dataDir, "history.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	hs := &historyStore{db: db, maxEvents: maxEvents, maxAge: maxAge, queue: make(chan *Event, historyQueueSize)}
+	if err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(historyBucket)
+		if err != nil {
+			return err
+		}
+		// counted once, then kept up to date by the writer
+		hs.count = b.Stats().KeyN
+		return nil
+	}); err != nil {
+		db.Close()
+		return nil, err
+	}
+	go hs.run()
+	return hs, nil
+}
+
+func historyKey(t time.Time, id uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(k[8:], id)
+	return k
+}
+
+// lastID returns the ID of the most recent stored event, so event IDs keep
+// increasing across restarts.
+func (hs *historyStore) lastID() (id uint64) {
+	hs.db.View(func(tx *bolt.Tx) error {
+		k, _ := tx.Bucket(historyBucket).Cursor().Last()
+		if len(k) == 16 {
+			id = binary.BigEndian.Uint64(k[8:])
+		}
+		return nil
+	})
+	return
+}
+
+// Handle queues the event for the writer. It blocks only when the writer is
+// historyQueueSize events behind.
+func (hs *historyStore) Handle(e *Event) {
+	hs.queue <- e
+}
+
+// run stores the queued events, the ones waiting together in one batch.
+func (hs *historyStore) run() {
+	for e := range hs.queue {
+		events := []*Event{e}
+	drain:
+		for len(events) < historyQueueSize {
+			select {
+			case e := <-hs.queue:
+				events = append(events, e)
+			default:
+				break drain
+			}
+		}
+		hs.store(events)
+	}
+}
+
+// store writes events in one batch, then prunes every pruneEvery inserts.
+func (hs *historyStore) store(events []*Event) {
+	keys, values := [][]byte{}, [][]byte{}
+	for _, e := range events {
+		b, err := json.Marshal(e)
+		if err != nil {
+			log.WithField("event", e.ID).Errorf("Store event error: %v", err)
+			continue
+		}
+		keys, values = append(keys, historyKey(e.Time, e.ID)), append(values, b)
+	}
+	err := hs.db.Batch(func(tx *bolt.Tx) error {
+		b := tx.Bucket(historyBucket)
+		for i := range keys {
+			if err := b.Put(keys[i], values[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Errorf("Store %d events error: %v", len(keys), err)
+		return
+	}
+
+	// the keys are unique, every put adds an event
+	hs.count += len(keys)
+	previous := hs.inserts
+	if hs.inserts += len(keys); hs.inserts/pruneEvery != previous/pruneEvery {
+		if err = hs.prune(); err != nil {
+			log.Errorf("Prune event history error: %v", err)
+		}
+	}
+}
+
+// prune removes the events older than maxAge and the oldest ones over
+// maxEvents.
+func (hs *historyStore) prune() error {
+	deleted := 0
+	err := hs.db.Update(func(tx *bolt.Tx) error {
+		deleted = 0
+		extra := 0
+		if hs.maxEvents > 0 {
+			extra = hs.count - hs.maxEvents
+		}
+		var oldest []byte
+		if hs.maxAge > 0 {
+			oldest = historyKey(time.Now().Add(-hs.maxAge), 0)
+		}
+
+		c := tx.Bucket(historyBucket).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.First() {
+			if extra <= 0 && (oldest == nil || string(k) >= string(oldest)) {
+				break
+			}
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			extra--
+			deleted++
+		}
+		return nil
+	})
+	if err == nil {
+		hs.count -= deleted
+	}
+	return err
+}
+
+// query returns the most recent events between since and until matching
+// filter, at most limit of them, oldest first. The events are read backwards
+// from until, so only the returned events are loaded. The limit is
+// historyDefaultLimit when not positive, historyMaxLimit at most.
+func (hs *historyStore) query(filter *eventFilter, since, until time.Time, limit int) ([]*Event, error) {
+	if limit <= 0 {
+		limit = historyDefaultLimit
+	}
+	if limit > historyMaxLimit {
+		limit = historyMaxLimit
+	}
+
+	events := []*Event{}
+	err := hs.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(historyBucket).Cursor()
+		var start []byte
+		if !since.IsZero() {
+			start = historyKey(since, 0)
+		}
+
+		// the last key not after until
+		k, v := c.Last()
+		if !until.IsZero() {
+			end := historyKey(until, ^uint64(0))
+			if k, v = c.Seek(end); k == nil {
+				k, v = c.Last()
+			} else if string(k) > string(end) {
+				k, v = c.Prev()
+			}
+		}
+		for ; k != nil && (start == nil || string(k) >= string(start)) && len(events) < limit; k, v = c.Prev() {
+			e := &Event{}
+			if err := json.Unmarshal(v, e); err != nil {
+				return err
+			}
+			if filter.match(e) {
+				events = append(events, e)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events, nil
+}
+
+// GET /history?since=&until=&type=&id=&name=&label=&action=&state=&limit=
+//    since, until: time window (RFC 3339 or unix timestamp)
+//    limit:        return only the most recent events (default 1000, at most 10000)
+// The other filters are the ones of GET /events.
+func listHistory(c *context, w http.ResponseWriter, r *http.Request) {
+	var (
+		err          error
+		since, until time.Time
+		limit        int
+		events       []*Event
+		q            = r.URL.Query()
+	)
+
+	if c.history == nil {
+		errResponse(w, r, grpc.Errorf(codes.Unimplemented, "event history is not enabled"), c)
+		return
+	}
+
+	if s := q.Get("since"); len(s) > 0 {
+		if since, err = parseTime(s); err != nil {
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid since: %v", err), c)
+			return
+		}
+	}
+	if s := q.Get("until"); len(s) > 0 {
+		if until, err = parseTime(s); err != nil {
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid until: %v", err), c)
+			return
+		}
+	}
+	if s := q.Get("limit"); len(s) > 0 {
+		if limit, err = strconv.Atoi(s); err != nil {
+			errResponse(w, r, grpc.Errorf(codes.InvalidArgument, "invalid limit: %v", err), c)
+			return
+		}
+	}
+
+	if events, err = c.history.query(newEventFilter(q), since, until, limit); err != nil {
+		errResponse(w, r, err, c)
+		return
+	}
+	c.render.JSON(w, http.StatusOK, events)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+)
+
+func testHistoryStore(t *testing.T, maxEvents int) (*historyStore, func()) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs, err := newHistoryStore(dir, maxEvents, 0)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return hs, func() {
+		hs.db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// historyTestEvents returns n events a second apart, of services a and b in
+// turn, with IDs from 1.
+func historyTestEvents(start time.Time, n int) []*Event {
+	events := []*Event{}
+	for i := 0; i < n; i++ {
+		name := "a"
+		if i%2 == 1 {
+			name = "b"
+		}
+		events = append(events, &Event{
+			ID:     uint64(i + 1),
+			Time:   start.Add(time.Duration(i) * time.Second),
+			Type:   "service",
+			Action: "update",
+			Name:   name,
+		})
+	}
+	return events
+}
+
+func TestHistoryQuery(t *testing.T) {
+	hs, cleanup := testHistoryStore(t, 0)
+	defer cleanup()
+	start := time.Unix(1469000000, 0)
+	hs.store(historyTestEvents(start, 10))
+
+	at := func(i int) time.Time { return start.Add(time.Duration(i-1) * time.Second) }
+	tests := []struct {
+		name         string
+		q            url.Values
+		since, until time.Time
+		limit        int
+		ids          []uint64
+	}{
+		{"all", url.Values{}, time.Time{}, time.Time{}, 0, []uint64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}},
+		{"limit keeps the most recent", url.Values{}, time.Time{}, time.Time{}, 3, []uint64{8, 9, 10}},
+		{"window", url.Values{}, at(3), at(6), 0, []uint64{3, 4, 5, 6}},
+		{"window and limit", url.Values{}, at(3), at(6), 2, []uint64{5, 6}},
+		{"until between events", url.Values{}, time.Time{}, at(4).Add(500 * time.Millisecond), 2, []uint64{3, 4}},
+		{"until after the last event", url.Values{}, time.Time{}, at(20), 1, []uint64{10}},
+		{"until before the first event", url.Values{}, time.Time{}, at(0), 0, []uint64{}},
+		{"filter and limit", url.Values{"name": {"a"}}, time.Time{}, time.Time{}, 2, []uint64{7, 9}},
+	}
+	for _, test := range tests {
+		events, err := hs.query(newEventFilter(test.q), test.since, test.until, test.limit)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		ids := []uint64{}
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+		if len(ids) != len(test.ids) {
+			t.Errorf("%s: events %v, want %v", test.name, ids, test.ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != test.ids[i] {
+				t.Errorf("%s: events %v, want %v", test.name, ids, test.ids)
+				break
+			}
+		}
+	}
+}
+
+func TestHistoryQueryDefaultLimit(t *testing.T) {
+	hs, cleanup := testHistoryStore(t, 0)
+	defer cleanup()
+	hs.store(historyTestEvents(time.Unix(1469000000, 0), historyDefaultLimit+10))
+
+	events, err := hs.query(newEventFilter(url.Values{}), time.Time{}, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != historyDefaultLimit || events[0].ID != 11 {
+		t.Errorf("query = %d events from %d, want %d from 11", len(events), events[0].ID, historyDefaultLimit)
+	}
+}
+
+func TestHistoryPrune(t *testing.T) {
+	hs, cleanup := testHistoryStore(t, 5)
+	defer cleanup()
+	// pruneEvery inserts trigger a retention check
+	hs.store(historyTestEvents(time.Unix(1469000000, 0), pruneEvery))
+
+	if hs.count != 5 {
+		t.Errorf("count = %d, want 5", hs.count)
+	}
+	events, err := hs.query(newEventFilter(url.Values{}), time.Time{}, time.Time{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 5 || events[0].ID != pruneEvery-4 {
+		t.Errorf("query = %d events, want the 5 most recent", len(events))
+	}
+	if id := hs.lastID(); id != pruneEvery {
+		t.Errorf("lastID = %d, want %d", id, pruneEvery)
+	}
+}
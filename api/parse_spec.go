@@ -5,14 +5,20 @@ import (
 	"strings"
 
 	"github.com/docker/swarmkit/api"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
-func merge(cspec *createSpec, spec *api.ServiceSpec, c api.ControlClient) (err error) {
+func merge(ctx ct.Context, cspec *createSpec, spec *api.ServiceSpec, c api.ControlClient) (err error) {
+	// the errors of the parse functions are validation errors of cspec
+	defer func() { err = invalidArgument(err) }()
+
 	if len(strings.TrimSpace(cspec.Name)) > 0 {
 		spec.Annotations.Name = cspec.Name
 	}
-	if len(cspec.Labels) > 0 {
-		spec.Annotations.Labels = cspec.Labels
+	if err = parseLabels(cspec, spec); err != nil {
+		return
 	}
 	if err = parseMode(cspec, spec); err != nil {
 		return
@@ -20,10 +26,13 @@ func merge(cspec *createSpec, spec *api.ServiceSpec, c api.ControlClient) (err e
 	if err = parseContainer(cspec, spec); err != nil {
 		return
 	}
+	if err = parseResource(cspec, spec); err != nil {
+		return
+	}
 	if err = parsePorts(cspec, spec); err != nil {
 		return
 	}
-	if err := parseNetworks(cspec, spec, c); err != nil {
+	if err := parseNetworks(ctx, cspec, spec, c); err != nil {
 		return err
 	}
 	if err = parseRestart(cspec, spec); err != nil {
@@ -35,15 +44,74 @@ func merge(cspec *createSpec, spec *api.ServiceSpec, c api.ControlClient) (err e
 	if err := parsePlacement(cspec, spec); err != nil {
 		return err
 	}
-	if err := parseBind(cspec, spec); err != nil {
+	if err := parseMounts(cspec, spec); err != nil {
 		return err
 	}
 
-	if err := parseVolume(cspec, spec); err != nil {
+	return
+}
+
+// conflictError reports operations of one request contradicting each other.
+func conflictError(format string, args ...interface{}) error {
+	return grpc.Errorf(codes.FailedPrecondition, format, args...)
+}
+
+// checkAddRm fails when the field replaced is given with its add or rm
+// field, or when a key is both added and removed.
+func checkAddRm(replaced, addField, rmField string, replacedSet bool, add, rm []string) error {
+	if replacedSet && (len(add) > 0 || len(rm) > 0) {
+		return conflictError("%s cannot be used with %s or %s", replaced, addField, rmField)
+	}
+	removed := make(map[string]bool)
+	for _, key := range rm {
+		removed[key] = true
+	}
+	for _, key := range add {
+		if removed[key] {
+			return conflictError("%s is both in %s and %s", key, addField, rmField)
+		}
+	}
+	return nil
+}
+
+// keyOf returns the key of a key=value pair.
+func keyOf(kv string) string {
+	return strings.SplitN(kv, "=", 2)[0]
+}
+
+// parseLabels sets, adds or removes the service labels.
+func parseLabels(cspec *createSpec, spec *api.ServiceSpec) error {
+	added := make(map[string]string)
+	keys := []string{}
+	for _, label := range cspec.LabelAdd {
+		parts := strings.SplitN(label, "=", 2)
+		if len(parts[0]) == 0 {
+			return fmt.Errorf("invalid label %q", label)
+		}
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+		added[parts[0]] = parts[1]
+		keys = append(keys, parts[0])
+	}
+	if err := checkAddRm("labels", "label-add", "label-rm", len(cspec.Labels) > 0, keys, cspec.LabelRm); err != nil {
 		return err
 	}
 
-	return
+	if len(cspec.Labels) > 0 {
+		spec.Annotations.Labels = cspec.Labels
+	}
+	for _, key := range cspec.LabelRm {
+		delete(spec.Annotations.Labels, key)
+	}
+	if len(added) > 0 && spec.Annotations.Labels == nil {
+		spec.Annotations.Labels = make(map[string]string)
+	}
+	for key, value := range added {
+		spec.Annotations.Labels[key] = value
+	}
+
+	return nil
 }
 
 func parseMode(cspec *createSpec, spec *api.ServiceSpec) error {
@@ -64,11 +132,12 @@ func parseMode(cspec *createSpec, spec *api.ServiceSpec) error {
 		}
 	}
 
-	if cspec.Replicas > 0 {
+	// replicas is a pointer so that 0 scales the service down
+	if cspec.Replicas != nil {
 		if spec.GetReplicated() == nil {
 			return fmt.Errorf("--replicas can only be specified in --mode replicated")
 		}
-		spec.GetReplicated().Replicas = cspec.Replicas
+		spec.GetReplicated().Replicas = *cspec.Replicas
 	}
 
 	return nil
@@ -1,23 +1,85 @@
 package api
 
 import (
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/docker/swarmkit/api"
+	"github.com/docker/swarmkit/protobuf/ptypes"
 )
 
 func parseContainer(cspec *createSpec, spec *api.ServiceSpec) error {
+	container := spec.Task.GetContainer()
+	if container == nil {
+		return fmt.Errorf("only container tasks are supported")
+	}
+
 	if len(strings.TrimSpace(cspec.Image)) > 0 {
-		spec.Task.GetContainer().Image = cspec.Image
+		container.Image = cspec.Image
+	}
+
+	if len(cspec.Command) > 0 {
+		container.Command = cspec.Command
 	}
 
 	if len(cspec.Args) > 0 {
-		spec.Task.GetContainer().Args = cspec.Args
+		container.Args = cspec.Args
 	}
 
 	if len(cspec.Env) > 0 {
-		spec.Task.GetContainer().Env = cspec.Env
+		container.Env = cspec.Env
+	}
+
+	if len(strings.TrimSpace(cspec.Dir)) > 0 {
+		container.Dir = cspec.Dir
+	}
+
+	if len(strings.TrimSpace(cspec.User)) > 0 {
+		container.User = cspec.User
+	}
+
+	if len(cspec.ContainerLabels) > 0 {
+		container.Labels = cspec.ContainerLabels
+	}
+
+	if len(strings.TrimSpace(cspec.StopGracePeriod)) > 0 {
+		gracePeriod, err := time.ParseDuration(cspec.StopGracePeriod)
+		if err != nil {
+			return err
+		}
+		container.StopGracePeriod = ptypes.DurationProto(gracePeriod)
+	}
+
+	return parseEnvAddRm(cspec, spec)
+}
+
+// parseEnvAddRm removes the variables of env-rm, given by name, then sets
+// the ones of env-add, replacing a variable of the same name.
+func parseEnvAddRm(cspec *createSpec, spec *api.ServiceSpec) error {
+	keys := []string{}
+	for _, env := range cspec.EnvAdd {
+		keys = append(keys, keyOf(env))
+	}
+	if err := checkAddRm("env", "env-add", "env-rm", len(cspec.Env) > 0, keys, cspec.EnvRm); err != nil {
+		return err
+	}
+	if len(keys) == 0 && len(cspec.EnvRm) == 0 {
+		return nil
+	}
+
+	removed := make(map[string]bool)
+	for _, key := range append(keys, cspec.EnvRm...) {
+		removed[key] = true
+	}
+	container := spec.Task.GetContainer()
+	env := []string{}
+	for _, e := range container.Env {
+		if !removed[keyOf(e)] {
+			env = append(env, e)
+		}
 	}
+	container.Env = append(env, cspec.EnvAdd...)
 
 	return nil
 }
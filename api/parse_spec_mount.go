@@ -0,0 +1,297 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/docker/swarmkit/api"
+)
+
+// mountSpec is a container mount, given as a structured object in "mounts" or
+// with the docker --mount syntax in "mount":
+// type=volume,source=data,target=/data,readonly,volume-driver=local,volume-opt=o=bind
+type mountSpec struct {
+	Type         string            `json:"type,omitempty"`             // bind or volume (default)
+	Source       string            `json:"source,omitempty"`           // host path of a bind, name of a volume (empty = anonymous volume)
+	Target       string            `json:"target"`                     // path in the container
+	ReadOnly     bool              `json:"readonly,omitempty"`         // mount read only
+	Propagation  string            `json:"bind-propagation,omitempty"` // bind only: rprivate, private, rshared, shared, rslave or slave
+	VolumeDriver string            `json:"volume-driver,omitempty"`    // volume only: driver creating the volume
+	VolumeOpts   map[string]string `json:"volume-opt,omitempty"`       // volume only: driver options
+	VolumeLabels map[string]string `json:"volume-label,omitempty"`     // volume only: labels of the created volume
+	VolumeNoCopy bool              `json:"volume-nocopy,omitempty"`    // volume only: do not populate the volume with the image content
+}
+
+var mountPropagations = map[string]api.Mount_BindOptions_MountPropagation{
+	"rprivate": api.MountPropagationRPrivate,
+	"private":  api.MountPropagationPrivate,
+	"rshared":  api.MountPropagationRShared,
+	"shared":   api.MountPropagationShared,
+	"rslave":   api.MountPropagationRSlave,
+	"slave":    api.MountPropagationSlave,
+}
+
+// parseMountString parses the docker --mount syntax, comma separated key=value
+// fields where readonly and volume-nocopy may be given without a value.
+func parseMountString(s string) (*mountSpec, error) {
+	fields, err := csv.NewReader(strings.NewReader(s)).Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid mount %q: %v", s, err)
+	}
+
+	ms := &mountSpec{}
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		if len(parts) == 1 {
+			switch key {
+			case "readonly", "ro":
+				ms.ReadOnly = true
+			case "volume-nocopy":
+				ms.VolumeNoCopy = true
+			default:
+				return nil, fmt.Errorf("invalid mount %q: field %q must be key=value", s, field)
+			}
+			continue
+		}
+
+		value := parts[1]
+		switch key {
+		case "type":
+			ms.Type = value
+		case "source", "src":
+			ms.Source = value
+		case "target", "dst", "destination":
+			ms.Target = value
+		case "readonly", "ro":
+			if ms.ReadOnly, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid mount %q: invalid readonly %q", s, value)
+			}
+		case "bind-propagation":
+			ms.Propagation = value
+		case "volume-driver":
+			ms.VolumeDriver = value
+		case "volume-opt", "volume-label":
+			kv := strings.SplitN(value, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid mount %q: %s %q must be key=value", s, key, value)
+			}
+			m := &ms.VolumeOpts
+			if key == "volume-label" {
+				m = &ms.VolumeLabels
+			}
+			if *m == nil {
+				*m = make(map[string]string)
+			}
+			(*m)[kv[0]] = kv[1]
+		case "volume-nocopy":
+			if ms.VolumeNoCopy, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid mount %q: invalid volume-nocopy %q", s, value)
+			}
+		default:
+			return nil, fmt.Errorf("invalid mount %q: unknown field %q", s, key)
+		}
+	}
+	return ms, nil
+}
+
+// toMount validates ms and returns the matching swarmkit mount.
+func (ms *mountSpec) toMount() (api.Mount, error) {
+	m := api.Mount{Source: ms.Source, Target: ms.Target, Writable: !ms.ReadOnly}
+
+	if len(ms.Target) == 0 {
+		return m, fmt.Errorf("mount target is required")
+	}
+	if !path.IsAbs(ms.Target) {
+		return m, fmt.Errorf("mount target %q must be an absolute path", ms.Target)
+	}
+
+	volumeOptions := len(ms.VolumeDriver) > 0 || len(ms.VolumeOpts) > 0 || len(ms.VolumeLabels) > 0 || ms.VolumeNoCopy
+	switch strings.ToLower(ms.Type) {
+	case "bind":
+		m.Type = api.MountTypeBind
+		if !path.IsAbs(ms.Source) {
+			return m, fmt.Errorf("bind mount %s: source %q must be an absolute path", ms.Target, ms.Source)
+		}
+		if volumeOptions {
+			return m, fmt.Errorf("bind mount %s: volume options cannot be used with a bind", ms.Target)
+		}
+		if len(ms.Propagation) > 0 {
+			propagation, ok := mountPropagations[strings.ToLower(ms.Propagation)]
+			if !ok {
+				return m, fmt.Errorf("bind mount %s: invalid bind-propagation %q", ms.Target, ms.Propagation)
+			}
+			m.BindOptions = &api.Mount_BindOptions{Propagation: propagation}
+		}
+	case "", "volume":
+		m.Type = api.MountTypeVolume
+		if strings.Contains(ms.Source, "/") {
+			return m, fmt.Errorf("volume mount %s: invalid volume name %q, use a bind to mount a host path", ms.Target, ms.Source)
+		}
+		if len(ms.Propagation) > 0 {
+			return m, fmt.Errorf("volume mount %s: bind-propagation can only be used with a bind", ms.Target)
+		}
+		if volumeOptions {
+			m.VolumeOptions = &api.Mount_VolumeOptions{
+				Populate: !ms.VolumeNoCopy,
+				Labels:   ms.VolumeLabels,
+			}
+			if len(ms.VolumeDriver) > 0 || len(ms.VolumeOpts) > 0 {
+				m.VolumeOptions.DriverConfig = &api.Driver{Name: ms.VolumeDriver, Options: ms.VolumeOpts}
+			}
+		}
+	default:
+		return m, fmt.Errorf("mount %s: invalid type %q, must be bind or volume", ms.Target, ms.Type)
+	}
+
+	return m, nil
+}
+
+// parseBindString parses the short bind syntax, source:target[:ro|rw].
+func parseBindString(s string) (*mountSpec, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, fmt.Errorf("bind format %q not supported, use source:target[:ro]", s)
+	}
+	ms := &mountSpec{Type: "bind", Source: parts[0], Target: parts[1]}
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			ms.ReadOnly = true
+		case "rw":
+		default:
+			return nil, fmt.Errorf("bind %q: invalid mode %q, must be ro or rw", s, parts[2])
+		}
+	}
+	return ms, nil
+}
+
+// parseVolumeString parses the short volume syntax, [name:]target[:ro|rw].
+func parseVolumeString(s string) (*mountSpec, error) {
+	parts := strings.Split(s, ":")
+	ms := &mountSpec{Type: "volume"}
+	if n := len(parts); n > 1 && (parts[n-1] == "ro" || parts[n-1] == "rw") {
+		ms.ReadOnly = parts[n-1] == "ro"
+		parts = parts[:n-1]
+	}
+	switch len(parts) {
+	case 1:
+		ms.Target = parts[0]
+	case 2:
+		ms.Source, ms.Target = parts[0], parts[1]
+	default:
+		return nil, fmt.Errorf("volume format %q not supported, use [name:]target[:ro]", s)
+	}
+	return ms, nil
+}
+
+// parseMounts applies the mounts of cspec. The mounts given with mounts,
+// mount, bind and volume replace the current ones, as env, labels and ports
+// do. Otherwise the mounts listed in mount-rm are removed by target, and
+// every mount of mount-add replaces the current mount with the same target
+// or is added. A target cannot be both added and removed.
+func parseMounts(cspec *createSpec, spec *api.ServiceSpec) error {
+	specs := []*mountSpec{}
+	for i := range cspec.Mounts {
+		specs = append(specs, &cspec.Mounts[i])
+	}
+	for _, s := range cspec.Mount {
+		ms, err := parseMountString(s)
+		if err != nil {
+			return err
+		}
+		specs = append(specs, ms)
+	}
+	for _, s := range cspec.Bind {
+		ms, err := parseBindString(s)
+		if err != nil {
+			return err
+		}
+		specs = append(specs, ms)
+	}
+	for _, s := range cspec.Volume {
+		ms, err := parseVolumeString(s)
+		if err != nil {
+			return err
+		}
+		specs = append(specs, ms)
+	}
+	addSpecs := []*mountSpec{}
+	for _, s := range cspec.MountAdd {
+		ms, err := parseMountString(s)
+		if err != nil {
+			return err
+		}
+		addSpecs = append(addSpecs, ms)
+	}
+	if len(specs) == 0 && len(addSpecs) == 0 && len(cspec.MountRm) == 0 {
+		return nil
+	}
+
+	container := spec.Task.GetContainer()
+	if container == nil {
+		return fmt.Errorf("mounts can only be set on container tasks")
+	}
+
+	mounts, err := newMounts(specs)
+	if err != nil {
+		return err
+	}
+	added, err := newMounts(addSpecs)
+	if err != nil {
+		return err
+	}
+	targets, removedTargets := []string{}, []string{}
+	for _, m := range added {
+		targets = append(targets, path.Clean(m.Target))
+	}
+	for _, target := range cspec.MountRm {
+		removedTargets = append(removedTargets, path.Clean(target))
+	}
+	if err := checkAddRm("mounts", "mount-add", "mount-rm", len(specs) > 0, targets, removedTargets); err != nil {
+		return err
+	}
+
+	// the current mounts are kept unless mounts are given
+	if len(specs) > 0 {
+		container.Mounts = mounts
+	}
+
+	if len(added) > 0 || len(removedTargets) > 0 {
+		drop := make(map[string]bool)
+		for _, target := range append(targets, removedTargets...) {
+			drop[target] = true
+		}
+		mounts = []api.Mount{}
+		for _, m := range container.Mounts {
+			if !drop[path.Clean(m.Target)] {
+				mounts = append(mounts, m)
+			}
+		}
+		container.Mounts = append(mounts, added...)
+	}
+
+	return nil
+}
+
+// newMounts returns the mounts of specs. A target can only be mounted once.
+func newMounts(specs []*mountSpec) ([]api.Mount, error) {
+	mounts := []api.Mount{}
+	seen := make(map[string]bool)
+	for _, ms := range specs {
+		m, err := ms.toMount()
+		if err != nil {
+			return nil, err
+		}
+		target := path.Clean(m.Target)
+		if seen[target] {
+			return nil, fmt.Errorf("duplicate mount target %s", m.Target)
+		}
+		seen[target] = true
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
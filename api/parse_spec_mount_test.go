@@ -0,0 +1,71 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/swarmkit/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func mountTestSpec() *api.ServiceSpec {
+	return &api.ServiceSpec{
+		Task: api.TaskSpec{
+			Runtime: &api.TaskSpec_Container{
+				Container: &api.ContainerSpec{
+					Image: "postgres",
+					Mounts: []api.Mount{
+						{Type: api.MountTypeBind, Source: "/srv/conf", Target: "/etc/postgresql", Writable: true},
+						{Type: api.MountTypeVolume, Source: "pgdata", Target: "/var/lib/postgresql/data", Writable: true},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestParseMounts(t *testing.T) {
+	spec := mountTestSpec()
+	conf, data := spec.Task.GetContainer().Mounts[0], spec.Task.GetContainer().Mounts[1]
+	logs := api.Mount{Type: api.MountTypeBind, Source: "/srv/logs", Target: "/var/log", Writable: true}
+	newConf := api.Mount{Type: api.MountTypeBind, Source: "/srv/conf2", Target: "/etc/postgresql/"}
+
+	tests := []struct {
+		name   string
+		cspec  *createSpec
+		mounts []api.Mount
+	}{
+		{"unchanged", &createSpec{}, []api.Mount{conf, data}},
+		{"mount", &createSpec{Mount: []string{"type=bind,source=/srv/logs,target=/var/log"}}, []api.Mount{logs}},
+		{"mounts", &createSpec{Mounts: []mountSpec{{Type: "bind", Source: "/srv/logs", Target: "/var/log"}}}, []api.Mount{logs}},
+		{"bind", &createSpec{Bind: []string{"/srv/logs:/var/log"}}, []api.Mount{logs}},
+		{"mount-add", &createSpec{MountAdd: []string{"type=bind,source=/srv/logs,target=/var/log"}}, []api.Mount{conf, data, logs}},
+		{"mount-add same target", &createSpec{MountAdd: []string{"type=bind,source=/srv/conf2,target=/etc/postgresql/,readonly"}}, []api.Mount{data, newConf}},
+		{"mount-rm", &createSpec{MountRm: []string{"/etc/postgresql/"}}, []api.Mount{data}},
+	}
+	for _, test := range tests {
+		spec := mountTestSpec()
+		if err := parseMounts(test.cspec, spec); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if mounts := spec.Task.GetContainer().Mounts; !reflect.DeepEqual(mounts, test.mounts) {
+			t.Errorf("%s: mounts = %v, want %v", test.name, mounts, test.mounts)
+		}
+	}
+}
+
+func TestParseMountsConflict(t *testing.T) {
+	tests := []*createSpec{
+		{Mount: []string{"type=bind,source=/srv/logs,target=/var/log"}, MountRm: []string{"/etc/postgresql"}},
+		{Volume: []string{"pgdata:/var/lib/postgresql/data"}, MountAdd: []string{"type=bind,source=/srv/logs,target=/var/log"}},
+		{MountAdd: []string{"type=bind,source=/srv/logs,target=/var/log"}, MountRm: []string{"/var/log"}},
+	}
+	for _, cspec := range tests {
+		err := parseMounts(cspec, mountTestSpec())
+		if code := grpc.Code(err); code != codes.FailedPrecondition {
+			t.Errorf("parseMounts(%+v) = %v, want a FailedPrecondition error", cspec, err)
+		}
+	}
+}
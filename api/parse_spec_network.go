@@ -1,25 +1,125 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
 	"strings"
 
 	"github.com/docker/swarmkit/api"
-	"github.com/docker/swarmkit/cmd/swarmctl/network"
+	"github.com/shenshouer/swarmkit-client/swarmkit"
 	ct "golang.org/x/net/context"
 )
 
-func parseNetworks(cspec *createSpec, spec *api.ServiceSpec, c api.ControlClient) error {
+// networkSpec is a network attachment, given as an object or as the name or
+// ID of the network alone.
+type networkSpec struct {
+	Target  string   `json:"target"`            // network name or ID
+	Aliases []string `json:"aliases,omitempty"` // names of the service on this network
+}
+
+// UnmarshalJSON accepts a network name or ID as well as an object.
+func (ns *networkSpec) UnmarshalJSON(b []byte) error {
+	var target string
+	if err := json.Unmarshal(b, &target); err == nil {
+		ns.Target = target
+		return nil
+	}
+	type plain networkSpec
+	return json.Unmarshal(b, (*plain)(ns))
+}
+
+// parseNetworks applies the network attachments of cspec. network and
+// networks replace every attachment, network-rm and network-add change the
+// current ones. A network cannot be both added and removed.
+func parseNetworks(ctx ct.Context, cspec *createSpec, spec *api.ServiceSpec, c api.ControlClient) error {
+	specs := cspec.Networks
 	if len(strings.TrimSpace(cspec.Network)) > 0 {
-		n, err := network.GetNetwork(ct.TODO(), c, cspec.Network)
+		specs = append([]networkSpec{{Target: cspec.Network}}, specs...)
+	}
+
+	removed := []string{}
+	for _, input := range cspec.NetworkRm {
+		n, err := swarmkit.GetNetwork(ctx, c, input)
+		if err != nil {
+			return err
+		}
+		removed = append(removed, n.ID)
+	}
+	added, err := resolveNetworks(ctx, c, cspec.NetworkAdd)
+	if err != nil {
+		return err
+	}
+	addedIDs := []string{}
+	for _, a := range added {
+		addedIDs = append(addedIDs, a.Target)
+	}
+	if err := checkAddRm("networks", "network-add", "network-rm", len(specs) > 0, addedIDs, removed); err != nil {
+		return err
+	}
+
+	if len(specs) > 0 {
+		attachments, err := resolveNetworks(ctx, c, specs)
 		if err != nil {
 			return err
 		}
+		spec.Networks = attachments
+	}
+
+	if len(removed) > 0 {
+		drop := make(map[string]bool)
+		for i, id := range removed {
+			if !attached(spec.Networks, id) {
+				return conflictError("network %s is not attached to the service", cspec.NetworkRm[i])
+			}
+			drop[id] = true
+		}
+		attachments := []*api.ServiceSpec_NetworkAttachmentConfig{}
+		for _, a := range spec.Networks {
+			if !drop[a.Target] {
+				attachments = append(attachments, a)
+			}
+		}
+		spec.Networks = attachments
+	}
 
-		spec.Networks = []*api.ServiceSpec_NetworkAttachmentConfig{
-			{
-				Target: n.ID,
-			},
+	for i, a := range added {
+		if attached(spec.Networks, a.Target) {
+			return conflictError("network %s is already attached to the service", cspec.NetworkAdd[i].Target)
 		}
 	}
+	spec.Networks = append(spec.Networks, added...)
+
 	return nil
 }
+
+// resolveNetworks returns the attachments of specs, which must not appear
+// twice.
+func resolveNetworks(ctx ct.Context, c api.ControlClient, specs []networkSpec) ([]*api.ServiceSpec_NetworkAttachmentConfig, error) {
+	attachments := []*api.ServiceSpec_NetworkAttachmentConfig{}
+	for _, ns := range specs {
+		if len(strings.TrimSpace(ns.Target)) == 0 {
+			return nil, fmt.Errorf("network target is required")
+		}
+		n, err := swarmkit.GetNetwork(ctx, c, ns.Target)
+		if err != nil {
+			return nil, err
+		}
+		if attached(attachments, n.ID) {
+			return nil, fmt.Errorf("duplicate network %s", ns.Target)
+		}
+		attachments = append(attachments, &api.ServiceSpec_NetworkAttachmentConfig{
+			Target:  n.ID,
+			Aliases: ns.Aliases,
+		})
+	}
+	return attachments, nil
+}
+
+func attached(attachments []*api.ServiceSpec_NetworkAttachmentConfig, id string) bool {
+	for _, a := range attachments {
+		if a.Target == id {
+			return true
+		}
+	}
+	return false
+}
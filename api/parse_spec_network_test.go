@@ -0,0 +1,107 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/swarmkit/api"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// networkTestClient is a control client knowing the networks of the tests.
+type networkTestClient struct {
+	api.ControlClient
+	networks []*api.Network
+}
+
+func (c *networkTestClient) GetNetwork(ctx ct.Context, r *api.GetNetworkRequest, opts ...grpc.CallOption) (*api.GetNetworkResponse, error) {
+	for _, n := range c.networks {
+		if n.ID == r.NetworkID {
+			return &api.GetNetworkResponse{Network: n}, nil
+		}
+	}
+	return nil, grpc.Errorf(codes.NotFound, "network %s not found", r.NetworkID)
+}
+
+func (c *networkTestClient) ListNetworks(ctx ct.Context, r *api.ListNetworksRequest, opts ...grpc.CallOption) (*api.ListNetworksResponse, error) {
+	networks := []*api.Network{}
+	for _, n := range c.networks {
+		for _, name := range r.Filters.Names {
+			if n.Spec.Annotations.Name == name {
+				networks = append(networks, n)
+			}
+		}
+	}
+	return &api.ListNetworksResponse{Networks: networks}, nil
+}
+
+func newNetworkTestClient(names ...string) *networkTestClient {
+	c := &networkTestClient{}
+	for _, name := range names {
+		c.networks = append(c.networks, &api.Network{ID: name + "-id", Spec: api.NetworkSpec{Annotations: api.Annotations{Name: name}}})
+	}
+	return c
+}
+
+func networkTestSpec() *api.ServiceSpec {
+	return &api.ServiceSpec{
+		Networks: []*api.ServiceSpec_NetworkAttachmentConfig{
+			{Target: "frontend-id"},
+			{Target: "backend-id", Aliases: []string{"api"}},
+		},
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	frontend := &api.ServiceSpec_NetworkAttachmentConfig{Target: "frontend-id"}
+	backend := &api.ServiceSpec_NetworkAttachmentConfig{Target: "backend-id", Aliases: []string{"api"}}
+	monitoring := &api.ServiceSpec_NetworkAttachmentConfig{Target: "monitoring-id"}
+
+	tests := []struct {
+		name     string
+		cspec    *createSpec
+		networks []*api.ServiceSpec_NetworkAttachmentConfig
+	}{
+		{"unchanged", &createSpec{}, []*api.ServiceSpec_NetworkAttachmentConfig{frontend, backend}},
+		{"network", &createSpec{Network: "monitoring"}, []*api.ServiceSpec_NetworkAttachmentConfig{monitoring}},
+		{"networks", &createSpec{Networks: []networkSpec{{Target: "monitoring"}}}, []*api.ServiceSpec_NetworkAttachmentConfig{monitoring}},
+		{"network-add", &createSpec{NetworkAdd: []networkSpec{{Target: "monitoring"}}}, []*api.ServiceSpec_NetworkAttachmentConfig{frontend, backend, monitoring}},
+		{"network-rm", &createSpec{NetworkRm: []string{"frontend"}}, []*api.ServiceSpec_NetworkAttachmentConfig{backend}},
+		{
+			"network-add and network-rm",
+			&createSpec{NetworkAdd: []networkSpec{{Target: "monitoring"}}, NetworkRm: []string{"backend-id"}},
+			[]*api.ServiceSpec_NetworkAttachmentConfig{frontend, monitoring},
+		},
+	}
+	c := newNetworkTestClient("frontend", "backend", "monitoring")
+	for _, test := range tests {
+		spec := networkTestSpec()
+		if err := parseNetworks(ct.Background(), test.cspec, spec, c); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(spec.Networks, test.networks) {
+			t.Errorf("%s: networks = %v, want %v", test.name, spec.Networks, test.networks)
+		}
+	}
+}
+
+func TestParseNetworksConflict(t *testing.T) {
+	tests := []*createSpec{
+		// by name and by ID
+		{NetworkAdd: []networkSpec{{Target: "monitoring"}}, NetworkRm: []string{"monitoring-id"}},
+		{Networks: []networkSpec{{Target: "monitoring"}}, NetworkRm: []string{"frontend"}},
+		{Network: "monitoring", NetworkAdd: []networkSpec{{Target: "backend"}}},
+		{NetworkAdd: []networkSpec{{Target: "frontend"}}},
+		{NetworkRm: []string{"monitoring"}},
+	}
+	c := newNetworkTestClient("frontend", "backend", "monitoring")
+	for _, cspec := range tests {
+		err := parseNetworks(ct.Background(), cspec, networkTestSpec(), c)
+		if code := grpc.Code(err); code != codes.FailedPrecondition {
+			t.Errorf("parseNetworks(%+v) = %v, want a FailedPrecondition error", cspec, err)
+		}
+	}
+}
@@ -1,8 +1,16 @@
 package api
 
-import "github.com/docker/swarmkit/api"
+import (
+	"strings"
+
+	"github.com/docker/swarmkit/api"
+)
 
 func parsePlacement(cspec *createSpec, spec *api.ServiceSpec) error {
+	if err := checkAddRm("constraint", "constraint-add", "constraint-rm", len(cspec.Constraint) > 0, cspec.ConstraintAdd, cspec.ConstraintRm); err != nil {
+		return err
+	}
+
 	if len(cspec.Constraint) > 0 {
 		if spec.Task.Placement == nil {
 			spec.Task.Placement = &api.Placement{}
@@ -10,5 +18,23 @@ func parsePlacement(cspec *createSpec, spec *api.ServiceSpec) error {
 		spec.Task.Placement.Constraints = cspec.Constraint
 	}
 
+	if len(cspec.ConstraintAdd) > 0 || len(cspec.ConstraintRm) > 0 {
+		if spec.Task.Placement == nil {
+			spec.Task.Placement = &api.Placement{}
+		}
+		// constraints are compared as written, without their spaces
+		removed := make(map[string]bool)
+		for _, c := range append(cspec.ConstraintRm, cspec.ConstraintAdd...) {
+			removed[strings.Replace(c, " ", "", -1)] = true
+		}
+		constraints := []string{}
+		for _, c := range spec.Task.Placement.Constraints {
+			if !removed[strings.Replace(c, " ", "", -1)] {
+				constraints = append(constraints, c)
+			}
+		}
+		spec.Task.Placement.Constraints = append(constraints, cspec.ConstraintAdd...)
+	}
+
 	return nil
 }
@@ -11,26 +11,101 @@ import (
 func parsePorts(cspec *createSpec, spec *api.ServiceSpec) error {
 	ports := []*api.PortConfig{}
 	for _, portConfig := range cspec.Ports {
-		name, protocol, port, swarmPort, err := parsePortConfig(portConfig)
+		port, err := newPortConfig(portConfig)
 		if err != nil {
 			return err
 		}
+		ports = append(ports, port)
+	}
+
+	added := []*api.PortConfig{}
+	keys := []string{}
+	for _, portConfig := range cspec.PublishAdd {
+		port, err := newPortConfig(portConfig)
+		if err != nil {
+			return err
+		}
+		added = append(added, port)
+		keys = append(keys, portKey(port.Protocol, port.TargetPort))
+	}
+	removed := []string{}
+	for _, portSpec := range cspec.PublishRm {
+		protocol, port, err := parsePortSpec(portSpec)
+		if err != nil {
+			return fmt.Errorf("failed to parse port %q: %v", portSpec, err)
+		}
+		removed = append(removed, portKey(protocol, port))
+	}
+	if err := checkAddRm("ports", "publish-add", "publish-rm", len(ports) > 0, keys, removed); err != nil {
+		return err
+	}
 
-		ports = append(ports, &api.PortConfig{
-			Name:          name,
-			Protocol:      protocol,
-			TargetPort:    port,
-			PublishedPort: swarmPort,
-		})
+	// the current ports are kept unless ports are given
+	if len(ports) > 0 {
+		if spec.Endpoint == nil {
+			spec.Endpoint = &api.EndpointSpec{}
+		}
+		spec.Endpoint.Ports = ports
+	}
+
+	if len(added) > 0 || len(removed) > 0 {
+		if spec.Endpoint == nil {
+			spec.Endpoint = &api.EndpointSpec{}
+		}
+		drop := make(map[string]bool)
+		for _, key := range append(keys, removed...) {
+			drop[key] = true
+		}
+		ports = []*api.PortConfig{}
+		for _, port := range spec.Endpoint.Ports {
+			if !drop[portKey(port.Protocol, port.TargetPort)] {
+				ports = append(ports, port)
+			}
+		}
+		spec.Endpoint.Ports = append(ports, added...)
 	}
 
-	spec.Endpoint = &api.EndpointSpec{
-		Ports: ports,
+	return parseEndpointMode(cspec, spec)
+}
+
+func parseEndpointMode(cspec *createSpec, spec *api.ServiceSpec) error {
+	if len(strings.TrimSpace(cspec.EndpointMode)) > 0 {
+		if spec.Endpoint == nil {
+			spec.Endpoint = &api.EndpointSpec{}
+		}
+
+		switch cspec.EndpointMode {
+		case "vip":
+			spec.Endpoint.Mode = api.ResolutionModeVirtualIP
+		case "dnsrr":
+			spec.Endpoint.Mode = api.ResolutionModeDNSRoundRobin
+		default:
+			return fmt.Errorf("invalid endpoint mode: %s", cspec.EndpointMode)
+		}
 	}
 
 	return nil
 }
 
+// portKey identifies a published port by its target port and protocol.
+func portKey(protocol api.PortConfig_Protocol, port uint32) string {
+	return fmt.Sprintf("%d/%s", port, strings.ToLower(protocol.String()))
+}
+
+func newPortConfig(portConfig string) (*api.PortConfig, error) {
+	name, protocol, port, swarmPort, err := parsePortConfig(portConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.PortConfig{
+		Name:          name,
+		Protocol:      protocol,
+		TargetPort:    port,
+		PublishedPort: swarmPort,
+	}, nil
+}
+
 func parsePortConfig(portConfig string) (string, api.PortConfig_Protocol, uint32, uint32, error) {
 	protocol := api.ProtocolTCP
 	parts := strings.Split(portConfig, ":")
@@ -3,19 +3,87 @@ package api
 import (
 	"crypto/tls"
 	"net/http"
+	"os"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/swarmkit/api"
 	"github.com/gorilla/mux"
 	"github.com/unrolled/render"
+	ct "golang.org/x/net/context"
 )
 
+// PrimaryOptions holds the optional settings of the primary router.
+type PrimaryOptions struct {
+	// RequestTimeout bounds the calls to the manager made by a request
+	// (0 = no timeout).
+	RequestTimeout time.Duration
+	// RouteTimeouts overrides RequestTimeout for some routes. Keys are
+	// "METHOD route" with route as registered, e.g. "POST /services/create".
+	RouteTimeouts map[string]time.Duration
+	// Authenticators identify the callers, tried in order. Without
+	// authenticators every caller is an anonymous admin.
+	Authenticators []Authenticator
+	// Audit records every POST and DELETE request, nil disables auditing.
+	Audit *AuditLog
+	// EventsInterval is the period of the cluster listings compared to
+	// produce events (0 = events disabled).
+	EventsInterval time.Duration
+	// EventsHistory is the number of recent events kept for replay.
+	EventsHistory int
+	// DataDir is where the state owned by this client (webhooks, event
+	// history, service revisions, ...) is persisted. Features needing it are disabled when empty.
+	DataDir string
+	// HistoryMaxEvents and HistoryMaxAge are the retention limits of the
+	// event history kept in DataDir (0 = unlimited).
+	HistoryMaxEvents int
+	HistoryMaxAge    time.Duration
+	// DeploymentsMaxKept and DeploymentsMaxAge are the retention limits of
+	// the finished deployments (0 = unlimited).
+	DeploymentsMaxKept int
+	DeploymentsMaxAge  time.Duration
+	// EventsQueueSize is the number of events queued for a listener before
+	// it is considered too slow and dropped.
+	EventsQueueSize int
+	// Sync runs the sync controller on a directory of specs, nil disables it.
+	Sync *SyncOptions
+	// WebsocketOrigins are the origins, e.g. "https://dashboard.example.com",
+	// allowed to open GET /events/ws besides the host of the api itself.
+	WebsocketOrigins []string
+}
+
+// streamingRoutes have no request timeout unless one is set in RouteTimeouts.
+var streamingRoutes = map[string]bool{
+	http.MethodGet + " /events":    true,
+	http.MethodGet + " /events/ws": true,
+}
+
+// timeout returns the request timeout of a route.
+func (o *PrimaryOptions) timeout(method, route string) time.Duration {
+	if d, ok := o.RouteTimeouts[method+" "+route]; ok {
+		return d
+	}
+	if streamingRoutes[method+" "+route] {
+		return 0
+	}
+	return o.RequestTimeout
+}
+
 // Primary router context, used by handlers.
 type context struct {
 	swarmkitAPI   api.ControlClient
 	eventsHandler *eventsHandler
 	tlsConfig     *tls.Config
 	render        *render.Render
+	options       *PrimaryOptions
+	audit         *AuditLog
+	watcher       *watcher
+	enableCors    bool
+	webhooks      *webhookManager
+	history       *historyStore
+	revisions     *revisionStore
+	sync          *syncController
+	deployments   *deploymentManager
 	// apiVersion    string
 	// statusHandler StatusHandler
 }
@@ -24,50 +92,182 @@ type handler func(c *context, w http.ResponseWriter, r *http.Request)
 
 var routes = map[string]map[string]handler{
 	http.MethodGet: {
-		"/nodes":                   listNodes,
-		"/nodes/{nodeid:.*}":       inspectNode,
-		"/services":                listService,
-		"/services/{serviceid:.*}": inspectService,
-		"/tasks":                   listTasks,
-		"/tasks/{taskid:.*}":       inspectTasks,
-		"/networks":                listNetworks,
-		"/networks/{networkid:.*}": inspectNetworks,
-		"/clusters":                listClusters,
-		"/clusters/{clusterid:.*}": inspectClusters,
+		"/nodes":                             listNodes,
+		"/nodes/{nodeid:.*}":                 inspectNode,
+		"/services":                          listService,
+		"/services/{serviceid:[^/]+}":        inspectService,
+		"/services/{serviceid:.*}/revisions": listRevisions,
+		"/services/{serviceid:.*}/revisions/{revision:[0-9]+}/diff": diffRevision,
+		"/tasks":                            listTasks,
+		"/tasks/{taskid:.*}":                inspectTasks,
+		"/networks":                         listNetworks,
+		"/networks/{networkid:.*}":          inspectNetworks,
+		"/clusters":                         listClusters,
+		"/clusters/{clusterid:.*}":          inspectClusters,
+		"/audit":                            listAudit,
+		"/events":                           getEvents,
+		"/events/ws":                        getEventsWebsocket,
+		"/webhooks":                         listWebhooks,
+		"/webhooks/{webhookid:.*}":          inspectWebhook,
+		"/history":                          listHistory,
+		"/stacks":                           listStacks,
+		"/stacks/{name:.*}":                 inspectStack,
+		"/sync/status":                      syncStatusHandler,
+		"/deployments":                      listDeployments,
+		"/deployments/{deploymentid:[^/]+}": inspectDeployment,
 	},
 	http.MethodPost: {
-		"/nodes/accept":                   acceptNode,
-		"/nodes/{nodeid:.*}/activate":     activateNode,
-		"/services/create":                createService,
-		"/services/{serviceid:.*}/update": updateService,
-		"/networks/creat":                 createNetworks,
-		"/clusters/{clusterid:.*}/update": updateClusters,
+		"/nodes/accept":                             acceptNode,
+		"/nodes/{nodeid:.*}/activate":               activateNode,
+		"/apply":                                    applyState,
+		"/services/create":                          createService,
+		"/services/{serviceid:.*}/update":           updateService,
+		"/services/{serviceid:.*}/scale":            scaleService,
+		"/services/scale":                           scaleServices,
+		"/services/{serviceid:.*}/rollback":         rollbackService,
+		"/networks/creat":                           createNetworks,
+		"/clusters/{clusterid:.*}/update":           updateClusters,
+		"/webhooks/create":                          createWebhook,
+		"/webhooks/{webhookid:.*}/update":           updateWebhook,
+		"/stacks/{name:.*}":                         deployStackHandler,
+		"/deployments/create":                       createDeployment,
+		"/deployments/{deploymentid:[^/]+}/promote": promoteDeployment,
+		"/deployments/{deploymentid:[^/]+}/abort":   abortDeployment,
 	},
 	http.MethodDelete: {
 		"/nodes/{nodeid:.*}":       removeNode,
 		"/services/{name:.*}":      removeService,
 		"/tasts/{taskid:.*}":       removeTasks,
 		"/networks/{networkid:.*}": removeNetworks,
+		"/webhooks/{webhookid:.*}": removeWebhook,
+		"/stacks/{name:.*}":        removeStack,
+	},
+}
+
+// routeRoles is the minimal role needed to call each route. Routes missing
+// from the table are reserved to admins.
+var routeRoles = map[string]map[string]Role{
+	http.MethodGet: {
+		"/nodes":                             RoleViewer,
+		"/nodes/{nodeid:.*}":                 RoleViewer,
+		"/services":                          RoleViewer,
+		"/services/{serviceid:[^/]+}":        RoleViewer,
+		"/services/{serviceid:.*}/revisions": RoleViewer,
+		"/services/{serviceid:.*}/revisions/{revision:[0-9]+}/diff": RoleViewer,
+		"/tasks":                            RoleViewer,
+		"/tasks/{taskid:.*}":                RoleViewer,
+		"/networks":                         RoleViewer,
+		"/networks/{networkid:.*}":          RoleViewer,
+		"/clusters":                         RoleViewer,
+		"/clusters/{clusterid:.*}":          RoleViewer,
+		"/events":                           RoleViewer,
+		"/events/ws":                        RoleViewer,
+		"/webhooks":                         RoleViewer,
+		"/webhooks/{webhookid:.*}":          RoleViewer,
+		"/history":                          RoleViewer,
+		"/stacks":                           RoleViewer,
+		"/stacks/{name:.*}":                 RoleViewer,
+		"/sync/status":                      RoleViewer,
+		"/deployments":                      RoleViewer,
+		"/deployments/{deploymentid:[^/]+}": RoleViewer,
+	},
+	http.MethodPost: {
+		"/apply":                                    RoleDeployer,
+		"/services/create":                          RoleDeployer,
+		"/services/{serviceid:.*}/update":           RoleDeployer,
+		"/services/{serviceid:.*}/scale":            RoleDeployer,
+		"/services/scale":                           RoleDeployer,
+		"/services/{serviceid:.*}/rollback":         RoleDeployer,
+		"/webhooks/create":                          RoleDeployer,
+		"/webhooks/{webhookid:.*}/update":           RoleDeployer,
+		"/stacks/{name:.*}":                         RoleDeployer,
+		"/deployments/create":                       RoleDeployer,
+		"/deployments/{deploymentid:[^/]+}/promote": RoleDeployer,
+		"/deployments/{deploymentid:[^/]+}/abort":   RoleDeployer,
+	},
+	http.MethodDelete: {
+		"/services/{name:.*}":      RoleDeployer,
+		"/tasts/{taskid:.*}":       RoleDeployer,
+		"/webhooks/{webhookid:.*}": RoleDeployer,
+		"/stacks/{name:.*}":        RoleDeployer,
 	},
 }
 
 func writeCorsHeaders(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Access-Control-Allow-Origin", "*")
-	w.Header().Add("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept")
+	w.Header().Add("Access-Control-Allow-Headers", "Origin, X-Requested-With, Content-Type, Accept, Authorization")
 	w.Header().Add("Access-Control-Allow-Methods", "GET, POST, DELETE, PUT, OPTIONS")
 }
 
 // NewPrimary creates a new API router.
-func NewPrimary(swarmkitAPI api.ControlClient, tlsConfig *tls.Config, enableCors bool) *mux.Router {
+func NewPrimary(swarmkitAPI api.ControlClient, tlsConfig *tls.Config, enableCors bool, opts *PrimaryOptions) (*mux.Router, error) {
+	if opts == nil {
+		opts = &PrimaryOptions{}
+	}
 	r := mux.NewRouter()
 	context := &context{
 		swarmkitAPI: swarmkitAPI,
 		tlsConfig:   tlsConfig,
 		render:      render.New(),
+		options:     opts,
+		audit:       opts.Audit,
+		enableCors:  enableCors,
+	}
+
+	if opts.EventsInterval > 0 {
+		context.eventsHandler = newEventsHandler(opts.EventsHistory, opts.EventsQueueSize)
+		context.watcher = newWatcher(swarmkitAPI, opts.EventsInterval)
+		context.watcher.Subscribe(context.eventsHandler.Handle)
+	}
+
+	if len(opts.DataDir) > 0 {
+		if err := os.MkdirAll(opts.DataDir, 0700); err != nil {
+			return nil, err
+		}
+		webhooks, err := newWebhookManager(opts.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		context.webhooks = webhooks
+
+		revisions, err := newRevisionStore(opts.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		context.revisions = revisions
+
+		if context.watcher != nil {
+			history, err := newHistoryStore(opts.DataDir, opts.HistoryMaxEvents, opts.HistoryMaxAge)
+			if err != nil {
+				return nil, err
+			}
+			context.history = history
+			context.watcher.seq = history.lastID()
+			context.watcher.Subscribe(history.Handle)
+			context.watcher.Subscribe(webhooks.Handle)
+			context.watcher.Subscribe(revisions.Handle)
+		} else {
+			log.Warn("Events are disabled, webhooks will not be called and no history is kept")
+		}
+	}
+
+	if context.watcher != nil {
+		go context.watcher.Run(ct.Background())
+	}
+
+	deployments, err := newDeploymentManager(context, opts.DataDir, opts.DeploymentsMaxKept, opts.DeploymentsMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	context.deployments = deployments
+
+	if opts.Sync != nil {
+		context.sync = newSyncController(context, *opts.Sync, opts.RequestTimeout)
+		go context.sync.Run(ct.Background())
 	}
 
 	setupPrimaryRouter(r, context, enableCors)
-	return r
+	return r, nil
 }
 
 func setupPrimaryRouter(r *mux.Router, context *context, enableCors bool) {
@@ -77,16 +277,58 @@ func setupPrimaryRouter(r *mux.Router, context *context, enableCors bool) {
 
 			localRoute := route
 			localFct := fct
+			localMethod := method
+			timeout := context.options.timeout(method, route)
 
 			wrap := func(w http.ResponseWriter, r *http.Request) {
-				log.WithFields(log.Fields{"method": r.Method, "uri": r.RequestURI}).Debug("HTTP request received")
+				log.WithFields(log.Fields{"method": r.Method, "uri": requestURI(r)}).Debug("HTTP request received")
 				if enableCors {
 					writeCorsHeaders(w, r)
 				}
+
+				if localMethod == http.MethodGet && queryTokenRoutes[localRoute] {
+					r = allowQueryToken(r)
+				}
+				// rejected changes are audited as well
+				reject := func(r *http.Request, err error) {
+					if context.audit != nil && localMethod != http.MethodGet {
+						audited(context, localRoute, func(c *context, w http.ResponseWriter, r *http.Request) {
+							errResponse(w, r, err, c)
+						}, w, r)
+						return
+					}
+					errResponse(w, r, err, context)
+				}
+
+				id, err := authenticate(context.options.Authenticators, r)
+				if err != nil {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+					reject(withIdentity(r, unauthenticated), err)
+					return
+				}
+				r = withIdentity(r, id)
+				if err = authorize(id, localMethod, localRoute); err != nil {
+					reject(r, err)
+					return
+				}
+
+				// the request context is canceled when the client goes away,
+				// which cancels the calls to the manager made with it
+				ctx := r.Context()
+				if timeout > 0 {
+					var cancel ct.CancelFunc
+					ctx, cancel = ct.WithTimeout(ctx, timeout)
+					defer cancel()
+				}
+				r = r.WithContext(ctx)
+
+				if context.audit != nil && localMethod != http.MethodGet {
+					audited(context, localRoute, localFct, w, r)
+					return
+				}
 				localFct(context, w, r)
 			}
 
-			localMethod := method
 			r.Path(localRoute).Methods(localMethod).HandlerFunc(wrap)
 
 			if enableCors {
@@ -94,7 +336,7 @@ func setupPrimaryRouter(r *mux.Router, context *context, enableCors bool) {
 				localFct = optionsHandler
 
 				wrap := func(w http.ResponseWriter, r *http.Request) {
-					log.WithFields(log.Fields{"method": optionsMethod, "uri": r.RequestURI}).
+					log.WithFields(log.Fields{"method": optionsMethod, "uri": requestURI(r)}).
 						Debug("HTTP request received")
 					if enableCors {
 						writeCorsHeaders(w, r)
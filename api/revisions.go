@@ -0,0 +1,206 @@
+package api
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	"github.com/docker/swarmkit/api"
+)
+
+// serviceRevision is a service spec applied through this api.
+type serviceRevision struct {
+	Revision   uint64           `json:"revision"`
+	ServiceID  string           `json:"service_id"`
+	Time       time.Time        `json:"time"`
+	User       string           `json:"user,omitempty"`
+	Source     string           `json:"source"`                // create, update, scale, rollback, rollout-rollback, deployment or observed
+	RollbackOf uint64           `json:"rollback_of,omitempty"` // revision restored by a rollback
+	Version    uint64           `json:"version"`               // Meta.Version.Index of the service after the change
+	Spec       *api.ServiceSpec `json:"spec,omitempty"`
+	SpecProto  []byte           `json:"spec_proto,omitempty"` // stored spec, protobuf encoded
+}
+
+// revisionStore keeps the numbered spec revisions of every service in a bolt
+// database of the data directory, one bucket per service ID. Specs are
+// stored protobuf encoded since their oneof fields cannot be decoded from
+// json.
+type revisionStore struct {
+	db *bolt.DB
+}
+
+func newRevisionStore(dataDir string) (*revisionStore, error) {
+	db, err := bolt.Open(filepath.Join(dataDir, "revisions.db"), 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	return &revisionStore{db: db}, nil
+}
+
+func revisionKey(n uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, n)
+	return k
+}
+
+func decodeRevision(b []byte) (*serviceRevision, error) {
+	rev := &serviceRevision{}
+	if err := json.Unmarshal(b, rev); err != nil {
+		return nil, err
+	}
+	rev.Spec = &api.ServiceSpec{}
+	if err := rev.Spec.Unmarshal(rev.SpecProto); err != nil {
+		return nil, err
+	}
+	rev.SpecProto = nil
+	return rev, nil
+}
+
+// record stores the spec of service as its next revision. When the service
+// has no revision yet and previous is given, previous is stored first as an
+// observed revision, so the spec the service had before its first change
+// through this api can be restored.
+func (rs *revisionStore) record(service *api.Service, previous *api.Service, rev *serviceRevision) error {
+	return rs.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(service.ID))
+		if err != nil {
+			return err
+		}
+
+		if k, _ := b.Cursor().Last(); k == nil && previous != nil {
+			if err = putRevision(b, previous, &serviceRevision{Source: "observed", Time: time.Now().UTC()}); err != nil {
+				return err
+			}
+		}
+		return putRevision(b, service, rev)
+	})
+}
+
+func putRevision(b *bolt.Bucket, service *api.Service, rev *serviceRevision) error {
+	n, err := b.NextSequence()
+	if err != nil {
+		return err
+	}
+	specProto, err := service.Spec.Marshal()
+	if err != nil {
+		return err
+	}
+
+	stored := *rev
+	stored.Revision = n
+	stored.ServiceID = service.ID
+	stored.Version = service.Meta.Version.Index
+	stored.Spec = nil
+	stored.SpecProto = specProto
+	v, err := json.Marshal(&stored)
+	if err != nil {
+		return err
+	}
+	return b.Put(revisionKey(n), v)
+}
+
+// list returns the revisions of a service, oldest first.
+func (rs *revisionStore) list(serviceID string) ([]*serviceRevision, error) {
+	revs := []*serviceRevision{}
+	err := rs.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(serviceID))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			rev, err := decodeRevision(v)
+			if err != nil {
+				return err
+			}
+			revs = append(revs, rev)
+			return nil
+		})
+	})
+	return revs, err
+}
+
+// get returns the revision n of a service.
+func (rs *revisionStore) get(serviceID string, n uint64) (rev *serviceRevision, err error) {
+	err = rs.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(serviceID))
+		if b == nil {
+			return notFound("revision", fmt.Sprintf("%s@%d", serviceID, n))
+		}
+		v := b.Get(revisionKey(n))
+		if v == nil {
+			return notFound("revision", fmt.Sprintf("%s@%d", serviceID, n))
+		}
+		rev, err = decodeRevision(v)
+		return err
+	})
+	return
+}
+
+// remove deletes the revisions of a removed service.
+func (rs *revisionStore) remove(serviceID string) error {
+	return rs.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte(serviceID)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+// Handle deletes the revisions of the services removed outside of this api.
+func (rs *revisionStore) Handle(e *Event) {
+	if e.Type != "service" || e.Action != "delete" {
+		return
+	}
+	if err := rs.remove(e.ObjectID); err != nil {
+		log.WithField("service", e.ObjectID).Errorf("Remove service revisions error: %v", err)
+	}
+}
+
+// latestOther returns the most recent revision of a service whose spec is
+// not spec, nil when there is none.
+func (rs *revisionStore) latestOther(serviceID string, spec *api.ServiceSpec) (*serviceRevision, error) {
+	revs, err := rs.list(serviceID)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(revs) - 1; i >= 0; i-- {
+		if !reflect.DeepEqual(revs[i].Spec, spec) {
+			return revs[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// forgetRevisions deletes the revisions of a service removed by r. The
+// service is already removed, so a failure is only logged.
+func forgetRevisions(c *context, serviceID string) {
+	if c.revisions == nil {
+		return
+	}
+	if err := c.revisions.remove(serviceID); err != nil {
+		log.WithField("service", serviceID).Errorf("Remove service revisions error: %v", err)
+	}
+}
+
+// recordRevision stores the spec of service after a change made by r. The
+// change is already applied, so a failure is only logged.
+func recordRevision(c *context, r *http.Request, service, previous *api.Service, source string, rollbackOf uint64) {
+	if c.revisions == nil || service == nil {
+		return
+	}
+	rev := &serviceRevision{
+		Time:       time.Now().UTC(),
+		User:       requestIdentity(r).Name,
+		Source:     source,
+		RollbackOf: rollbackOf,
+	}
+	if err := c.revisions.record(service, previous, rev); err != nil {
+		log.WithField("service", service.ID).Errorf("Record service revision error: %v", err)
+	}
+}
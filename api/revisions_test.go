@@ -0,0 +1,92 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/docker/swarmkit/api"
+)
+
+func testRevisionStore(t *testing.T) (*revisionStore, func()) {
+	dir, err := ioutil.TempDir("", "revisions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := newRevisionStore(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return rs, func() {
+		rs.db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func revisionTestService(image string) *api.Service {
+	return &api.Service{
+		ID: "svc",
+		Spec: api.ServiceSpec{
+			Annotations: api.Annotations{Name: "web"},
+			Task: api.TaskSpec{
+				Runtime: &api.TaskSpec_Container{Container: &api.ContainerSpec{Image: image}},
+			},
+		},
+	}
+}
+
+func TestRevisionLatestOther(t *testing.T) {
+	rs, cleanup := testRevisionStore(t)
+	defer cleanup()
+
+	// revisions 1: v1, 2: v2, 3: v1, 4: v3
+	for _, image := range []string{"v1", "v2", "v1", "v3"} {
+		if err := rs.record(revisionTestService(image), nil, &serviceRevision{Source: "update"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		running string
+		want    uint64
+	}{
+		{"v3", 3},
+		// rolled back by swarm or changed outside of this api
+		{"v1", 4},
+		{"v4", 4},
+	} {
+		rev, err := rs.latestOther("svc", &revisionTestService(tc.running).Spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rev == nil || rev.Revision != tc.want {
+			t.Errorf("running %s: got %v, want revision %d", tc.running, rev, tc.want)
+		}
+	}
+
+	if rev, err := rs.latestOther("other", &revisionTestService("v1").Spec); err != nil || rev != nil {
+		t.Errorf("unknown service: got %v, %v, want nil", rev, err)
+	}
+}
+
+func TestRevisionRemove(t *testing.T) {
+	rs, cleanup := testRevisionStore(t)
+	defer cleanup()
+
+	if err := rs.record(revisionTestService("v1"), nil, &serviceRevision{Source: "create"}); err != nil {
+		t.Fatal(err)
+	}
+	rs.Handle(&Event{Type: "service", Action: "update", ObjectID: "svc"})
+	if revs, _ := rs.list("svc"); len(revs) != 1 {
+		t.Fatalf("update event: got %d revisions, want 1", len(revs))
+	}
+	rs.Handle(&Event{Type: "service", Action: "delete", ObjectID: "svc"})
+	if revs, _ := rs.list("svc"); len(revs) != 0 {
+		t.Fatalf("delete event: got %d revisions, want 0", len(revs))
+	}
+	// already removed through the api
+	if err := rs.remove("svc"); err != nil {
+		t.Errorf("remove twice: %v", err)
+	}
+}
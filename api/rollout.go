@@ -0,0 +1,345 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/swarmkit/api"
+	"github.com/docker/swarmkit/protobuf/ptypes"
+	"github.com/shenshouer/swarmkit-client/swarmkit"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	// defaultTaskTimeout is the time given to a batch of tasks to reach
+	// running when the rollout timeout is not set.
+	defaultTaskTimeout = time.Minute
+	// rolloutPollInterval is the period of the task listings of a rollout.
+	rolloutPollInterval = time.Second
+	// rolloutMargin is the time given past the deadline of a rollout to
+	// roll it back.
+	rolloutMargin = time.Minute
+)
+
+// State of a rollout.
+const (
+	rolloutUpdating    = "updating"
+	rolloutCompleted   = "completed"
+	rolloutFailed      = "failed"
+	rolloutRollingBack = "rolling_back"
+	rolloutRolledBack  = "rolled_back"
+)
+
+// rolloutOptions are the query parameters of an update waiting for its tasks.
+type rolloutOptions struct {
+	TaskTimeout     time.Duration // time for a batch of tasks to reach running
+	Timeout         time.Duration // deadline of the whole rollout, from the update config when 0
+	MaxFailureRatio float64       // share of the desired tasks allowed to fail
+	Rollback        bool          // re-apply the previous spec when the rollout fails
+}
+
+func parseRolloutOptions(r *http.Request) (*rolloutOptions, error) {
+	var (
+		err  error
+		q    = r.URL.Query()
+		opts = &rolloutOptions{TaskTimeout: defaultTaskTimeout, Rollback: true}
+	)
+	if s := q.Get("task_timeout"); len(s) > 0 {
+		if opts.TaskTimeout, err = time.ParseDuration(s); err != nil || opts.TaskTimeout <= 0 {
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid task_timeout %q", s)
+		}
+	}
+	if s := q.Get("timeout"); len(s) > 0 {
+		if opts.Timeout, err = time.ParseDuration(s); err != nil || opts.Timeout <= 0 {
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid timeout %q", s)
+		}
+	}
+	if s := q.Get("max_failure_ratio"); len(s) > 0 {
+		if opts.MaxFailureRatio, err = strconv.ParseFloat(s, 64); err != nil || opts.MaxFailureRatio < 0 || opts.MaxFailureRatio > 1 {
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid max_failure_ratio %q, must be between 0 and 1", s)
+		}
+	}
+	if s := q.Get("rollback"); len(s) > 0 {
+		if opts.Rollback, err = strconv.ParseBool(s); err != nil {
+			return nil, grpc.Errorf(codes.InvalidArgument, "invalid rollback %q", s)
+		}
+	}
+	return opts, nil
+}
+
+// deadline returns how long the rollout of spec to desired tasks may take:
+// a task timeout per batch of update-parallelism tasks plus the update-delay
+// between batches.
+func (opts *rolloutOptions) deadline(spec *api.ServiceSpec, desired uint64) time.Duration {
+	if opts.Timeout > 0 {
+		return opts.Timeout
+	}
+	var (
+		batches uint64 = 1
+		delay   time.Duration
+	)
+	if update := spec.Update; update != nil {
+		if update.Parallelism > 0 && desired > update.Parallelism {
+			batches = (desired + update.Parallelism - 1) / update.Parallelism
+		}
+		delay, _ = ptypes.Duration(&update.Delay)
+	}
+	return time.Duration(batches)*opts.TaskTimeout + time.Duration(batches-1)*delay
+}
+
+// rolloutContext returns the context of the rollout of spec, detached from
+// ctx which it outlives, and bounded by the rollout deadline plus
+// rolloutMargin. The tasks of a global service are bounded by the nodes.
+func rolloutContext(ctx ct.Context, c api.ControlClient, spec *api.ServiceSpec, opts *rolloutOptions) (ct.Context, ct.CancelFunc) {
+	var desired uint64
+	if replicated := spec.GetReplicated(); replicated != nil {
+		desired = replicated.Replicas
+	} else if resp, err := c.ListNodes(ctx, &api.ListNodesRequest{}); err == nil {
+		desired = uint64(len(resp.Nodes))
+	}
+	return ct.WithTimeout(ct.Background(), opts.deadline(spec, desired)+rolloutMargin)
+}
+
+// rolloutProgress is reported while the tasks of an update are replaced.
+type rolloutProgress struct {
+	Time     time.Time `json:"time"`
+	State    string    `json:"state"` // updating, completed, failed, rolling_back or rolled_back
+	Desired  uint64    `json:"desired"`
+	Updated  uint64    `json:"updated"`  // tasks of the new spec running
+	Outdated uint64    `json:"outdated"` // tasks of the previous spec still running
+	Failed   int       `json:"failed"`   // tasks of the new spec failed or rejected
+	Message  string    `json:"message,omitempty"`
+}
+
+func (p *rolloutProgress) same(o *rolloutProgress) bool {
+	return o != nil && p.State == o.State && p.Desired == o.Desired && p.Updated == o.Updated &&
+		p.Outdated == o.Outdated && p.Failed == o.Failed
+}
+
+// monitorRollout follows the tasks of service, just updated, until they all
+// run its spec, or until too many of them failed or the deadline passed.
+// report is called when the progress changes, and at least every
+// pingInterval. The returned progress is completed or failed, it fails when
+// ctx is done.
+func monitorRollout(ctx ct.Context, c api.ControlClient, service *api.Service, opts *rolloutOptions, report func(*rolloutProgress)) *rolloutProgress {
+	var (
+		start    = time.Now()
+		deadline = start.Add(opts.deadline(&service.Spec, 0))
+		last     *rolloutProgress
+		reported time.Time
+	)
+	ticker := time.NewTicker(rolloutPollInterval)
+	defer ticker.Stop()
+
+	for {
+		p, err := rolloutStatus(ctx, c, service, start)
+		switch {
+		case err == nil:
+			deadline = start.Add(opts.deadline(&service.Spec, p.Desired))
+		case time.Now().After(deadline):
+			p = &rolloutProgress{State: rolloutUpdating}
+			if last != nil {
+				*p = *last
+			}
+			p.Time = time.Now().UTC()
+		default:
+			// the manager may be failing over, retried until the deadline
+			log.WithField("service", service.ID).Warnf("Rollout status error: %v", err)
+		}
+
+		if p != nil {
+			switch {
+			case p.Updated >= p.Desired && p.Outdated == 0 && err == nil:
+				p.State = rolloutCompleted
+			case p.Desired > 0 && float64(p.Failed)/float64(p.Desired) > opts.MaxFailureRatio:
+				p.State = rolloutFailed
+				p.Message = fmt.Sprintf("%d new tasks failed, over %g of the %d desired: %s", p.Failed, opts.MaxFailureRatio, p.Desired, p.Message)
+			case time.Now().After(deadline):
+				p.State = rolloutFailed
+				p.Message = fmt.Sprintf("%d of %d tasks run the new spec after %s", p.Updated, p.Desired, deadline.Sub(start))
+				if err != nil {
+					p.Message += fmt.Sprintf(", last error: %v", err)
+				}
+			}
+			if p.State != rolloutUpdating {
+				report(p)
+				return p
+			}
+			if !p.same(last) || time.Since(reported) >= pingInterval {
+				report(p)
+				reported = time.Now()
+			}
+			last = p
+		}
+
+		select {
+		case <-ctx.Done():
+			p = &rolloutProgress{}
+			if last != nil {
+				*p = *last
+			}
+			p.Time, p.State, p.Message = time.Now().UTC(), rolloutFailed, ctx.Err().Error()
+			report(p)
+			return p
+		case <-ticker.C:
+		}
+	}
+}
+
+// rolloutStatus counts the tasks of service, the failed ones only when they
+// run its current spec and failed after start. Message is the error of the
+// last failure.
+func rolloutStatus(ctx ct.Context, c api.ControlClient, service *api.Service, start time.Time) (*rolloutProgress, error) {
+	tasks, err := c.ListTasks(ctx, &api.ListTasksRequest{
+		Filters: &api.ListTasksRequest_Filters{ServiceIDs: []string{service.ID}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*api.Node
+	if service.Spec.GetGlobal() != nil {
+		resp, err := c.ListNodes(ctx, &api.ListNodesRequest{})
+		if err != nil {
+			return nil, err
+		}
+		nodes = resp.Nodes
+	}
+
+	status := computeServiceStatus(service, tasks.Tasks, nodes)
+	p := &rolloutProgress{
+		Time:     time.Now().UTC(),
+		State:    rolloutUpdating,
+		Desired:  status.Desired,
+		Updated:  status.Running - status.Outdated,
+		Outdated: status.Outdated,
+	}
+	var lastFailure time.Time
+	for _, t := range tasks.Tasks {
+		if t.Status.State != api.TaskStateFailed && t.Status.State != api.TaskStateRejected {
+			continue
+		}
+		if t.Status.Timestamp == nil || !reflect.DeepEqual(t.Spec, service.Spec.Task) {
+			continue
+		}
+		at, err := ptypes.Timestamp(t.Status.Timestamp)
+		if err != nil || at.Before(start) {
+			continue
+		}
+		p.Failed++
+		if at.After(lastFailure) {
+			lastFailure, p.Message = at, t.Status.Err
+			if len(p.Message) == 0 {
+				p.Message = t.Status.Message
+			}
+		}
+	}
+	return p, nil
+}
+
+// rollbackRollout re-applies previous, the spec before a failed update.
+func rollbackRollout(ctx ct.Context, c *context, r *http.Request, previous *api.Service) error {
+	current, err := swarmkit.GetService(ctx, c.swarmkitAPI, previous.ID)
+	if err != nil {
+		return err
+	}
+	resp, err := c.swarmkitAPI.UpdateService(ctx, &api.UpdateServiceRequest{
+		ServiceID:      current.ID,
+		ServiceVersion: &current.Meta.Version,
+		Spec:           &previous.Spec,
+	})
+	if err != nil {
+		return err
+	}
+	recordRevision(c, r, resp.Service, current, "rollout-rollback", 0)
+	return nil
+}
+
+// rolloutWriter streams the progress of a rollout as json lines, or as
+// Server-Sent Events.
+type rolloutWriter struct {
+	w   io.Writer
+	sse bool
+}
+
+func (rw *rolloutWriter) write(p *rolloutProgress) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return
+	}
+	if rw.sse {
+		_, err = fmt.Fprintf(rw.w, "event: progress\ndata: %s\n\n", b)
+	} else {
+		_, err = rw.w.Write(append(b, '\n'))
+	}
+	if err != nil {
+		// the client went away, the rollout is still monitored
+		return
+	}
+	if f, ok := rw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// waitRollout monitors the rollout of updated, rolls it back to previous when
+// it fails and writes the outcome. The rollout is followed past the request
+// timeout, and rolled back even when the client goes away.
+func waitRollout(c *context, w http.ResponseWriter, r *http.Request, previous, updated *api.Service, opts *rolloutOptions) {
+	var (
+		progress = []*rolloutProgress{}
+		report   func(*rolloutProgress)
+		stream   = queryBool(r, "stream")
+		sse      = strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	)
+	if stream || sse {
+		if sse {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(http.StatusOK)
+		rw := &rolloutWriter{w: w, sse: sse}
+		report = rw.write
+	} else {
+		report = func(p *rolloutProgress) { progress = append(progress, p) }
+	}
+
+	// not the request context: the rollout outlives the request timeout and
+	// the client, monitorRollout stops at the rollout deadline
+	ctx, cancel := rolloutContext(r.Context(), c.swarmkitAPI, &updated.Spec, opts)
+	defer cancel()
+	logger := log.WithFields(log.Fields{"service": updated.ID})
+
+	result := monitorRollout(ctx, c.swarmkitAPI, updated, opts, report)
+	if result.State == rolloutFailed && opts.Rollback {
+		logger.Warnf("Rollout failed, rolling back: %s", result.Message)
+		report(&rolloutProgress{Time: time.Now().UTC(), State: rolloutRollingBack, Desired: result.Desired,
+			Updated: result.Updated, Outdated: result.Outdated, Failed: result.Failed, Message: result.Message})
+		rollback := &rolloutProgress{Time: time.Now().UTC(), State: rolloutRolledBack, Message: result.Message}
+		if err := rollbackRollout(ctx, c, r, previous); err != nil {
+			logger.Errorf("Rollout rollback error: %v", err)
+			rollback.State, rollback.Message = rolloutFailed, fmt.Sprintf("%s, rollback error: %v", result.Message, err)
+		}
+		report(rollback)
+		result = rollback
+	}
+
+	if stream || sse {
+		return
+	}
+	c.render.JSON(w, http.StatusOK, map[string]interface{}{
+		"id":       updated.ID,
+		"state":    result.State,
+		"message":  result.Message,
+		"progress": progress,
+	})
+}
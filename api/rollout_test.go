@@ -0,0 +1,172 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/docker/swarmkit/protobuf/ptypes"
+	ct "golang.org/x/net/context"
+)
+
+// updateTestService creates web with replicas tasks of nginx:1 in state, then
+// updates it to nginx:2 and returns the service before and after the update.
+func updateTestService(t *testing.T, client *clusterTestClient, replicas uint64, state api.TaskState) (previous, updated *api.Service) {
+	spec := testServiceSpec("web", "nginx:1", nil)
+	spec.GetReplicated().Replicas = replicas
+	previous = client.addService(spec)
+	client.setTasks(previous.ID, int(replicas), state)
+
+	spec = spec.Copy()
+	spec.Task.GetContainer().Image = "nginx:2"
+	resp, err := client.UpdateService(ct.Background(), &api.UpdateServiceRequest{ServiceID: previous.ID, ServiceVersion: &previous.Meta.Version, Spec: spec})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return previous, resp.Service
+}
+
+// setTaskStates sets the state of the tasks of service, with the spec of
+// service, failing at at.
+func setTaskStates(client *clusterTestClient, service *api.Service, at time.Time, states ...api.TaskState) {
+	client.setTasks(service.ID, len(states), api.TaskStateRunning)
+	i := 0
+	for _, t := range client.tasks {
+		if t.ServiceID != service.ID {
+			continue
+		}
+		t.Status = api.TaskStatus{State: states[i], Timestamp: ptypes.MustTimestampProto(at)}
+		i++
+	}
+}
+
+func TestRolloutDeadline(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     rolloutOptions
+		update   *api.UpdateConfig
+		desired  uint64
+		deadline time.Duration
+	}{
+		{"one batch", rolloutOptions{TaskTimeout: time.Minute}, nil, 5, time.Minute},
+		{"timeout", rolloutOptions{TaskTimeout: time.Minute, Timeout: time.Hour}, &api.UpdateConfig{Parallelism: 1}, 5, time.Hour},
+		{"all in parallel", rolloutOptions{TaskTimeout: time.Minute}, &api.UpdateConfig{Parallelism: 0}, 5, time.Minute},
+		{
+			"batches with a delay",
+			rolloutOptions{TaskTimeout: time.Minute},
+			&api.UpdateConfig{Parallelism: 2, Delay: *ptypes.DurationProto(10 * time.Second)},
+			5, 3*time.Minute + 20*time.Second,
+		},
+	}
+	for _, test := range tests {
+		spec := testServiceSpec("web", "nginx", nil)
+		spec.Update = test.update
+		if deadline := test.opts.deadline(spec, test.desired); deadline != test.deadline {
+			t.Errorf("%s: got %s, want %s", test.name, deadline, test.deadline)
+		}
+	}
+}
+
+func TestMonitorRollout(t *testing.T) {
+	var (
+		running = api.TaskStateRunning
+		failed  = api.TaskStateFailed
+		// failures after the start of the rollout, or before it
+		after  = time.Now().Add(time.Hour)
+		before = time.Now().Add(-time.Hour)
+	)
+
+	tests := []struct {
+		name    string
+		states  []api.TaskState // of the tasks of the new spec, outdated tasks when nil
+		at      time.Time
+		ratio   float64
+		state   string
+		updated uint64
+		failed  int
+		message string // prefix of the message of the result
+	}{
+		{"completed", []api.TaskState{running, running, running}, after, 0, rolloutCompleted, 3, 0, ""},
+		{"failed task", []api.TaskState{running, running, failed}, after, 0, rolloutFailed, 2, 1, "1 new tasks failed, over 0 of the 3 desired"},
+		{"rejected task", []api.TaskState{running, running, api.TaskStateRejected}, after, 0, rolloutFailed, 2, 1, "1 new tasks failed"},
+		// the context is done once the first status is reported
+		{"failures within the ratio", []api.TaskState{running, running, failed}, after, 0.5, rolloutFailed, 2, 1, ct.Canceled.Error()},
+		{"failure before the rollout", []api.TaskState{running, running, failed}, before, 0, rolloutFailed, 2, 0, ct.Canceled.Error()},
+		{"outdated tasks", nil, after, 0, rolloutFailed, 0, 0, ct.Canceled.Error()},
+	}
+	for _, test := range tests {
+		client := newClusterTestClient()
+		_, updated := updateTestService(t, client, 3, running)
+		if test.states != nil {
+			setTaskStates(client, updated, test.at, test.states...)
+		}
+
+		ctx, cancel := ct.WithCancel(ct.Background())
+		reports := []*rolloutProgress{}
+		result := monitorRollout(ctx, client, updated, &rolloutOptions{TaskTimeout: time.Minute, MaxFailureRatio: test.ratio}, func(p *rolloutProgress) {
+			reports = append(reports, p)
+			cancel()
+		})
+		cancel()
+
+		if result.State != test.state || result.Updated != test.updated || result.Failed != test.failed || !strings.HasPrefix(result.Message, test.message) {
+			t.Errorf("%s: got %s, %d updated, %d failed, %q, want %s, %d updated, %d failed, %q", test.name,
+				result.State, result.Updated, result.Failed, result.Message,
+				test.state, test.updated, test.failed, test.message)
+		}
+		if len(reports) == 0 || reports[len(reports)-1] != result {
+			t.Errorf("%s: the result was not reported", test.name)
+		}
+	}
+}
+
+func TestWaitRolloutRollback(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		state    string
+		image    string // of the service once the rollout ended
+		progress []string
+	}{
+		{"rolled back", "", rolloutRolledBack, "nginx:1", []string{rolloutFailed, rolloutRollingBack, rolloutRolledBack}},
+		{"without rollback", "?rollback=false", rolloutFailed, "nginx:2", []string{rolloutFailed}},
+	}
+	for _, test := range tests {
+		client := newClusterTestClient()
+		previous, updated := updateTestService(t, client, 2, api.TaskStateRunning)
+		setTaskStates(client, updated, time.Now().Add(time.Hour), api.TaskStateFailed, api.TaskStateFailed)
+
+		r := httptest.NewRequest(http.MethodPost, "/services/web"+test.query, nil)
+		opts, err := parseRolloutOptions(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		waitRollout(newTestContext(client), w, r, previous, updated, opts)
+
+		var resp struct {
+			State    string             `json:"state"`
+			Progress []*rolloutProgress `json:"progress"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("%s: %v: %s", test.name, err, w.Body.String())
+		}
+		if resp.State != test.state {
+			t.Errorf("%s: got state %s, want %s", test.name, resp.State, test.state)
+		}
+		states := []string{}
+		for _, p := range resp.Progress {
+			states = append(states, p.State)
+		}
+		if strings.Join(states, " ") != strings.Join(test.progress, " ") {
+			t.Errorf("%s: got progress %v, want %v", test.name, states, test.progress)
+		}
+		if image := client.service("web").Spec.Task.GetContainer().Image; image != test.image {
+			t.Errorf("%s: got image %s, want %s", test.name, image, test.image)
+		}
+	}
+}
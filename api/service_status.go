@@ -0,0 +1,183 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/docker/swarmkit/protobuf/ptypes"
+	ct "golang.org/x/net/context"
+)
+
+// failureWindow is how long a failed task counts against the health of its
+// service.
+const failureWindow = 5 * time.Minute
+
+// Health of a service.
+const (
+	healthConverged = "converged" // every desired task runs the current spec
+	healthUpdating  = "updating"  // tasks are being replaced or started
+	healthDegraded  = "degraded"  // some desired tasks are missing
+	healthFailing   = "failing"   // no task runs and tasks keep failing
+)
+
+// serviceStatus is the state of the tasks of a service, computed from the
+// task list.
+type serviceStatus struct {
+	Desired     uint64         `json:"desired"`  // replicas, or eligible nodes in global mode
+	Running     uint64         `json:"running"`  // tasks running the current spec or not yet replaced
+	Replicas    string         `json:"replicas"` // running/desired
+	Outdated    uint64         `json:"outdated"` // current tasks still running a previous spec
+	States      map[string]int `json:"states"`   // current tasks (desired state running) by state
+	Failures    int            `json:"failures"` // tasks failed or rejected in the last five minutes
+	Nodes       *nodeCoverage  `json:"nodes,omitempty"`
+	LastFailure *taskFailure   `json:"last_failure,omitempty"`
+	Health      string         `json:"health"`
+}
+
+// nodeCoverage is the nodes a global service runs on.
+type nodeCoverage struct {
+	Eligible int      `json:"eligible"`
+	Running  int      `json:"running"`
+	Missing  []string `json:"missing"` // eligible nodes without a running task
+}
+
+// taskFailure describes the last task that failed.
+type taskFailure struct {
+	TaskID  string    `json:"task_id"`
+	NodeID  string    `json:"node_id,omitempty"`
+	State   string    `json:"state"`
+	Message string    `json:"message,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+// serviceWithStatus is a service as listed by GET /services.
+type serviceWithStatus struct {
+	*api.Service
+	Status *serviceStatus `json:"status"`
+}
+
+// computeServiceStatus summarizes tasks, the tasks of service. nodes are the
+// nodes of the cluster, only used in global mode.
+func computeServiceStatus(service *api.Service, tasks []*api.Task, nodes []*api.Node) *serviceStatus {
+	status := &serviceStatus{States: make(map[string]int)}
+	now := time.Now()
+	var (
+		starting    uint64
+		lastFailure time.Time
+		runningOn   = make(map[string]bool)
+	)
+
+	for _, t := range tasks {
+		state := t.Status.State
+		if state == api.TaskStateFailed || state == api.TaskStateRejected {
+			var at time.Time
+			if t.Status.Timestamp != nil {
+				at, _ = ptypes.Timestamp(t.Status.Timestamp)
+			}
+			if now.Sub(at) < failureWindow {
+				status.Failures++
+			}
+			if status.LastFailure == nil || at.After(lastFailure) {
+				lastFailure = at
+				status.LastFailure = &taskFailure{
+					TaskID:  t.ID,
+					NodeID:  t.NodeID,
+					State:   strings.ToLower(state.String()),
+					Message: t.Status.Message,
+					Error:   t.Status.Err,
+					Time:    at.UTC(),
+				}
+			}
+		}
+
+		if t.DesiredState > api.TaskStateRunning {
+			continue
+		}
+		status.States[strings.ToLower(state.String())]++
+		switch {
+		case state == api.TaskStateRunning:
+			status.Running++
+			runningOn[t.NodeID] = true
+			if !reflect.DeepEqual(t.Spec, service.Spec.Task) {
+				status.Outdated++
+			}
+		case state < api.TaskStateRunning:
+			starting++
+		}
+	}
+
+	switch mode := service.Spec.Mode.(type) {
+	case *api.ServiceSpec_Replicated:
+		status.Desired = mode.Replicated.Replicas
+	case *api.ServiceSpec_Global:
+		var constraints []string
+		if service.Spec.Task.Placement != nil {
+			constraints = service.Spec.Task.Placement.Constraints
+		}
+		// invalid constraints match no node, as for the scheduler
+		eligible, _ := matchNodes(nodes, constraints)
+		coverage := &nodeCoverage{Eligible: len(eligible), Missing: []string{}}
+		for _, n := range eligible {
+			if runningOn[n.ID] {
+				coverage.Running++
+				continue
+			}
+			name := n.ID
+			if n.Description != nil && len(n.Description.Hostname) > 0 {
+				name = n.Description.Hostname
+			}
+			coverage.Missing = append(coverage.Missing, name)
+		}
+		status.Desired, status.Nodes = uint64(len(eligible)), coverage
+	}
+	status.Replicas = fmt.Sprintf("%d/%d", status.Running, status.Desired)
+
+	switch {
+	case status.Running >= status.Desired && status.Outdated == 0:
+		status.Health = healthConverged
+	case status.Running == 0 && status.Failures > 0:
+		status.Health = healthFailing
+	case status.Outdated > 0 || status.Running+starting >= status.Desired:
+		status.Health = healthUpdating
+	case status.Running == 0:
+		status.Health = healthFailing
+	default:
+		status.Health = healthDegraded
+	}
+	return status
+}
+
+// serviceStatuses computes the status of services with one listing of the
+// tasks and nodes.
+func serviceStatuses(ctx ct.Context, c api.ControlClient, services []*api.Service) (map[string]*serviceStatus, error) {
+	tasks, err := c.ListTasks(ctx, &api.ListTasksRequest{})
+	if err != nil {
+		return nil, err
+	}
+	byService := make(map[string][]*api.Task)
+	for _, t := range tasks.Tasks {
+		byService[t.ServiceID] = append(byService[t.ServiceID], t)
+	}
+
+	var nodes []*api.Node
+	for _, s := range services {
+		if s.Spec.GetGlobal() != nil {
+			resp, err := c.ListNodes(ctx, &api.ListNodesRequest{})
+			if err != nil {
+				return nil, err
+			}
+			nodes = resp.Nodes
+			break
+		}
+	}
+
+	statuses := make(map[string]*serviceStatus)
+	for _, s := range services {
+		statuses[s.ID] = computeServiceStatus(s, byService[s.ID], nodes)
+	}
+	return statuses, nil
+}
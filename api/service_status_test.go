@@ -0,0 +1,137 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/docker/swarmkit/protobuf/ptypes"
+	ct "golang.org/x/net/context"
+)
+
+func TestServiceStatus(t *testing.T) {
+	replicated := &api.Service{ID: "web-id", Spec: *testServiceSpec("web", "nginx:2", nil)}
+	replicated.Spec.GetReplicated().Replicas = 3
+	global := &api.Service{ID: "agent-id", Spec: *testServiceSpec("agent", "agent:2", nil)}
+	global.Spec.Mode = &api.ServiceSpec_Global{Global: &api.GlobalService{}}
+	previous := testServiceSpec("web", "nginx:1", nil).Task
+
+	nodes := []*api.Node{}
+	for i, availability := range []api.NodeSpec_Availability{api.NodeAvailabilityActive, api.NodeAvailabilityActive, api.NodeAvailabilityDrain} {
+		nodes = append(nodes, &api.Node{
+			ID:          []string{"n1", "n2", "n3"}[i],
+			Spec:        api.NodeSpec{Availability: availability},
+			Description: &api.NodeDescription{Hostname: []string{"node1", "node2", "node3"}[i]},
+			Status:      api.NodeStatus{State: api.NodeStatus_READY},
+		})
+	}
+
+	now := time.Now()
+	// task returns a task of service, of its current spec unless outdated,
+	// in state for age.
+	task := func(service *api.Service, node string, state api.TaskState, outdated bool, age time.Duration) *api.Task {
+		tk := &api.Task{
+			ID:           service.ID + "." + node + "." + state.String(),
+			ServiceID:    service.ID,
+			NodeID:       node,
+			Spec:         service.Spec.Task,
+			DesiredState: api.TaskStateRunning,
+			Status:       api.TaskStatus{State: state, Timestamp: ptypes.MustTimestampProto(now.Add(-age))},
+		}
+		if outdated {
+			tk.Spec = previous
+		}
+		if state > api.TaskStateRunning {
+			tk.DesiredState = api.TaskStateShutdown
+		}
+		return tk
+	}
+	running := api.TaskStateRunning
+
+	tests := []struct {
+		name     string
+		service  *api.Service
+		tasks    []*api.Task
+		replicas string
+		outdated uint64
+		failures int
+		health   string
+	}{
+		{
+			"converged",
+			replicated,
+			[]*api.Task{task(replicated, "n1", running, false, 0), task(replicated, "n2", running, false, 0), task(replicated, "n3", running, false, 0)},
+			"3/3", 0, 0, healthConverged,
+		},
+		{
+			"rolling update",
+			replicated,
+			[]*api.Task{task(replicated, "n1", running, false, 0), task(replicated, "n2", running, false, 0), task(replicated, "n3", running, true, 0)},
+			"3/3", 1, 0, healthUpdating,
+		},
+		{
+			"starting",
+			replicated,
+			[]*api.Task{task(replicated, "n1", running, false, 0), task(replicated, "n2", running, false, 0), task(replicated, "n3", api.TaskStatePreparing, false, 0)},
+			"2/3", 0, 0, healthUpdating,
+		},
+		{
+			"degraded",
+			replicated,
+			[]*api.Task{task(replicated, "n1", running, false, 0), task(replicated, "n2", running, false, 0), task(replicated, "n3", api.TaskStateFailed, false, time.Minute)},
+			"2/3", 0, 1, healthDegraded,
+		},
+		{
+			"failing",
+			replicated,
+			[]*api.Task{task(replicated, "n1", api.TaskStateFailed, false, time.Minute), task(replicated, "n2", api.TaskStateRejected, false, 0)},
+			"0/3", 0, 2, healthFailing,
+		},
+		{
+			"old failure",
+			replicated,
+			[]*api.Task{task(replicated, "n1", running, false, 0), task(replicated, "n2", running, false, 0), task(replicated, "n3", running, false, 0), task(replicated, "n4", api.TaskStateFailed, false, time.Hour)},
+			"3/3", 0, 0, healthConverged,
+		},
+		{
+			"global on every eligible node",
+			global,
+			[]*api.Task{task(global, "n1", running, false, 0), task(global, "n2", running, false, 0)},
+			"2/2", 0, 0, healthConverged,
+		},
+		{
+			"global missing a node",
+			global,
+			[]*api.Task{task(global, "n1", running, false, 0)},
+			"1/2", 0, 0, healthDegraded,
+		},
+	}
+	for _, test := range tests {
+		client := newClusterTestClient()
+		client.tasks, client.nodes = test.tasks, nodes
+
+		statuses, err := serviceStatuses(ct.Background(), client, []*api.Service{test.service})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		status := statuses[test.service.ID]
+		if status.Replicas != test.replicas || status.Outdated != test.outdated || status.Failures != test.failures || status.Health != test.health {
+			t.Errorf("%s: got %s, %d outdated, %d failures, %s, want %s, %d outdated, %d failures, %s", test.name,
+				status.Replicas, status.Outdated, status.Failures, status.Health,
+				test.replicas, test.outdated, test.failures, test.health)
+		}
+		if test.failures > 0 && (status.LastFailure == nil || status.LastFailure.TaskID == "") {
+			t.Errorf("%s: got no last failure", test.name)
+		}
+	}
+
+	client := newClusterTestClient()
+	client.tasks, client.nodes = []*api.Task{task(global, "n1", running, false, 0)}, nodes
+	statuses, err := serviceStatuses(ct.Background(), client, []*api.Service{global})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodes := statuses[global.ID].Nodes; nodes == nil || nodes.Eligible != 2 || len(nodes.Missing) != 1 || nodes.Missing[0] != "node2" {
+		t.Errorf("got node coverage %+v, want node2 missing out of 2 eligible nodes", nodes)
+	}
+}
@@ -5,12 +5,27 @@ type (
 		Name               string            `json:"name"`                           // service name
 		Image              string            `json:"image"`                          // container image
 		Labels             map[string]string `json:"labels,omitempty"`               // service label (key=value)
+		LabelAdd           []string          `json:"label-add,omitempty"`            // service labels to set on update (key=value)
+		LabelRm            []string          `json:"label-rm,omitempty"`             // keys of the service labels to remove on update
 		Mode               string            `json:"mode,omitempty"`                 // one of replicated, global
-		Replicas           uint64            `json:"replicas,omitempty"`             // number of replicas for the service (only works in replicated service mode)
+		Replicas           *uint64           `json:"replicas,omitempty"`             // number of replicas for the service (only works in replicated service mode)
+		Command            []string          `json:"command,omitempty"`              // override entrypoint
 		Args               []string          `json:"args,omitempty"`                 // container args
 		Env                []string          `json:"env,omitempty"`                  // container env
+		EnvAdd             []string          `json:"env-add,omitempty"`              // env variables to set on update (KEY=VALUE)
+		EnvRm              []string          `json:"env-rm,omitempty"`               // names of the env variables to remove on update
+		Dir                string            `json:"dir,omitempty"`                  // working directory of the container
+		User               string            `json:"user,omitempty"`                 // user running the container (name, uid or uid:gid)
+		ContainerLabels    map[string]string `json:"container-labels,omitempty"`     // container labels (key=value)
+		StopGracePeriod    string            `json:"stop-grace-period,omitempty"`    // time to wait before force killing a container (e.g. 10s)
 		Ports              []string          `json:"ports,omitempty"`                // ports
+		PublishAdd         []string          `json:"publish-add,omitempty"`          // ports to publish on update, as ports
+		PublishRm          []string          `json:"publish-rm,omitempty"`           // target ports to unpublish on update (80 or 53/udp)
+		EndpointMode       string            `json:"endpoint-mode,omitempty"`        // service discovery mode (vip or dnsrr)
 		Network            string            `json:"network,omitempty"`              // network name
+		Networks           []networkSpec     `json:"networks,omitempty"`             // network attachments, replacing the current ones
+		NetworkAdd         []networkSpec     `json:"network-add,omitempty"`          // network attachments to add on update
+		NetworkRm          []string          `json:"network-rm,omitempty"`           // networks to detach on update
 		MemoryReservation  string            `json:"memory-reservation,omitempty"`   // amount of reserved memory (e.g. 512m)
 		MemoryLimit        string            `json:"memory-limit,omitempty"`         // memory limit (e.g. 512m)
 		CPUReservation     string            `json:"cpu-reservation,omitempty"`      // number of CPU cores reserved (e.g. 0.5)
@@ -22,7 +37,13 @@ type (
 		RestartMaxAttempts uint64            `json:"restart-max-attempts,omitempty"` // maximum number of restart attempts (0 = unlimited)
 		RestartWindow      string            `json:"restart-window,omitempty"`       // time window to evaluate restart attempts (0 = unbound)
 		Constraint         []string          `json:"constraint,omitempty"`           // Placement constraint (node.labels.key==value)
-		Bind               []string          `json:"bind,omitempty"`                 // define a bind mount
-		Volume             []string          `json:"volume,omitempty"`               // define a volume mount
+		ConstraintAdd      []string          `json:"constraint-add,omitempty"`       // placement constraints to add on update
+		ConstraintRm       []string          `json:"constraint-rm,omitempty"`        // placement constraints to remove on update
+		Bind               []string          `json:"bind,omitempty"`                 // define a bind mount (source:target[:ro])
+		Volume             []string          `json:"volume,omitempty"`               // define a volume mount ([name:]target[:ro])
+		Mounts             []mountSpec       `json:"mounts,omitempty"`               // mounts as objects
+		Mount              []string          `json:"mount,omitempty"`                // mounts with the docker --mount syntax
+		MountAdd           []string          `json:"mount-add,omitempty"`            // mounts to add on update, as mount
+		MountRm            []string          `json:"mount-rm,omitempty"`             // targets of the mounts to remove
 	}
 )
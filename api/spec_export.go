@@ -0,0 +1,153 @@
+package api
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/docker/swarmkit/protobuf/ptypes"
+)
+
+// exportSpec returns the create request producing spec, the inverse of
+// merge: sent to /services/{id}/update it leaves the service unchanged.
+func exportSpec(spec *api.ServiceSpec) *createSpec {
+	cspec := &createSpec{
+		Name:   spec.Annotations.Name,
+		Labels: spec.Annotations.Labels,
+	}
+
+	switch mode := spec.Mode.(type) {
+	case *api.ServiceSpec_Global:
+		cspec.Mode = "global"
+	case *api.ServiceSpec_Replicated:
+		replicas := mode.Replicated.Replicas
+		cspec.Mode, cspec.Replicas = "replicated", &replicas
+	}
+
+	if container := spec.Task.GetContainer(); container != nil {
+		cspec.Image = container.Image
+		cspec.Command = container.Command
+		cspec.Args = container.Args
+		cspec.Env = container.Env
+		cspec.Dir = container.Dir
+		cspec.User = container.User
+		cspec.ContainerLabels = container.Labels
+		if container.StopGracePeriod != nil {
+			if d, err := ptypes.Duration(container.StopGracePeriod); err == nil {
+				cspec.StopGracePeriod = d.String()
+			}
+		}
+		for _, m := range container.Mounts {
+			cspec.Mounts = append(cspec.Mounts, exportMount(m))
+		}
+	}
+
+	if endpoint := spec.Endpoint; endpoint != nil {
+		for _, port := range endpoint.Ports {
+			cspec.Ports = append(cspec.Ports, exportPort(port))
+		}
+		switch endpoint.Mode {
+		case api.ResolutionModeVirtualIP:
+			cspec.EndpointMode = "vip"
+		case api.ResolutionModeDNSRoundRobin:
+			cspec.EndpointMode = "dnsrr"
+		}
+	}
+
+	for _, a := range spec.Networks {
+		cspec.Networks = append(cspec.Networks, networkSpec{Target: a.Target, Aliases: a.Aliases})
+	}
+
+	if resources := spec.Task.Resources; resources != nil {
+		if r := resources.Reservations; r != nil {
+			cspec.MemoryReservation, cspec.CPUReservation = exportMemory(r.MemoryBytes), exportCPU(r.NanoCPUs)
+		}
+		if r := resources.Limits; r != nil {
+			cspec.MemoryLimit, cspec.CPULimit = exportMemory(r.MemoryBytes), exportCPU(r.NanoCPUs)
+		}
+	}
+
+	if update := spec.Update; update != nil {
+		cspec.UpdateParallelism = update.Parallelism
+		if d, err := ptypes.Duration(&update.Delay); err == nil {
+			cspec.UpdateDelay = d.String()
+		}
+	}
+
+	if restart := spec.Task.Restart; restart != nil {
+		switch restart.Condition {
+		case api.RestartOnNone:
+			cspec.RestartCondition = "none"
+		case api.RestartOnFailure:
+			cspec.RestartCondition = "failure"
+		case api.RestartOnAny:
+			cspec.RestartCondition = "any"
+		}
+		if restart.Delay != nil {
+			if d, err := ptypes.Duration(restart.Delay); err == nil {
+				cspec.RestartDelay = d.String()
+			}
+		}
+		cspec.RestartMaxAttempts = restart.MaxAttempts
+		if restart.Window != nil {
+			if d, err := ptypes.Duration(restart.Window); err == nil {
+				cspec.RestartWindow = d.String()
+			}
+		}
+	}
+
+	if placement := spec.Task.Placement; placement != nil {
+		cspec.Constraint = placement.Constraints
+	}
+
+	return cspec
+}
+
+// exportPort formats port with the ports syntax,
+// name:port/protocol[:published/protocol].
+func exportPort(port *api.PortConfig) string {
+	protocol := strings.ToLower(port.Protocol.String())
+	s := fmt.Sprintf("%s:%d/%s", port.Name, port.TargetPort, protocol)
+	if port.PublishedPort > 0 {
+		s += fmt.Sprintf(":%d/%s", port.PublishedPort, protocol)
+	}
+	return s
+}
+
+func exportMount(m api.Mount) mountSpec {
+	ms := mountSpec{Type: "volume", Source: m.Source, Target: m.Target, ReadOnly: !m.Writable}
+	if m.Type == api.MountTypeBind {
+		ms.Type = "bind"
+	}
+	if m.BindOptions != nil {
+		for name, propagation := range mountPropagations {
+			if propagation == m.BindOptions.Propagation {
+				ms.Propagation = name
+			}
+		}
+	}
+	if v := m.VolumeOptions; v != nil {
+		ms.VolumeNoCopy = !v.Populate
+		ms.VolumeLabels = v.Labels
+		if v.DriverConfig != nil {
+			ms.VolumeDriver, ms.VolumeOpts = v.DriverConfig.Name, v.DriverConfig.Options
+		}
+	}
+	return ms
+}
+
+func exportMemory(bytes int64) string {
+	if bytes == 0 {
+		return ""
+	}
+	return strconv.FormatInt(bytes, 10) + "b"
+}
+
+func exportCPU(nanoCPUs int64) string {
+	if nanoCPUs == 0 {
+		return ""
+	}
+	return strings.TrimRight(strings.TrimRight(big.NewRat(nanoCPUs, 1e9).FloatString(9), "0"), ".")
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/swarmkit/api"
+	"github.com/docker/swarmkit/protobuf/ptypes"
+	ct "golang.org/x/net/context"
+)
+
+// exportTestSpec sets every field exportSpec covers but the networks, which
+// merge resolves against the manager.
+func exportTestSpec() *api.ServiceSpec {
+	return &api.ServiceSpec{
+		Annotations: api.Annotations{
+			Name:   "web",
+			Labels: map[string]string{"tier": "front"},
+		},
+		Mode: &api.ServiceSpec_Replicated{
+			Replicated: &api.ReplicatedService{Replicas: 3},
+		},
+		Task: api.TaskSpec{
+			Runtime: &api.TaskSpec_Container{
+				Container: &api.ContainerSpec{
+					Image:           "nginx:1.11",
+					Command:         []string{"nginx"},
+					Args:            []string{"-g", "daemon off;"},
+					Env:             []string{"FOO=bar"},
+					Dir:             "/srv",
+					User:            "www-data",
+					Labels:          map[string]string{"log": "json"},
+					StopGracePeriod: ptypes.DurationProto(10 * time.Second),
+					Mounts: []api.Mount{
+						{
+							Type:        api.MountTypeBind,
+							Source:      "/etc/nginx",
+							Target:      "/etc/nginx",
+							BindOptions: &api.Mount_BindOptions{Propagation: api.MountPropagationRSlave},
+						},
+						{
+							Type:     api.MountTypeVolume,
+							Source:   "data",
+							Target:   "/data",
+							Writable: true,
+							VolumeOptions: &api.Mount_VolumeOptions{
+								Populate:     true,
+								DriverConfig: &api.Driver{Name: "local", Options: map[string]string{"type": "tmpfs"}},
+							},
+						},
+					},
+				},
+			},
+			Resources: &api.ResourceRequirements{
+				Reservations: &api.Resources{NanoCPUs: 500000000, MemoryBytes: 128 * 1024 * 1024},
+				Limits:       &api.Resources{NanoCPUs: 1500000000, MemoryBytes: 512 * 1024 * 1024},
+			},
+			Restart: &api.RestartPolicy{
+				Condition:   api.RestartOnFailure,
+				Delay:       ptypes.DurationProto(5 * time.Second),
+				MaxAttempts: 3,
+				Window:      ptypes.DurationProto(time.Minute),
+			},
+			Placement: &api.Placement{Constraints: []string{"node.role==worker"}},
+		},
+		Update: &api.UpdateConfig{
+			Parallelism: 2,
+			Delay:       *ptypes.DurationProto(10 * time.Second),
+		},
+		Endpoint: &api.EndpointSpec{
+			Mode: api.ResolutionModeDNSRoundRobin,
+			Ports: []*api.PortConfig{
+				{Name: "http", Protocol: api.ProtocolTCP, TargetPort: 80, PublishedPort: 8080},
+				{Name: "dns", Protocol: api.ProtocolUDP, TargetPort: 53, PublishedPort: 53},
+				{Protocol: api.ProtocolTCP, TargetPort: 9090},
+			},
+		},
+	}
+}
+
+func TestExportSpecCreate(t *testing.T) {
+	spec := exportTestSpec()
+	created, err := newServiceSpec(ct.Background(), nil, exportSpec(spec))
+	if err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+	if !reflect.DeepEqual(created, spec) {
+		t.Errorf("merge(exportSpec(spec)) = %+v, want %+v", created, spec)
+	}
+}
+
+func TestExportSpecUpdate(t *testing.T) {
+	spec := exportTestSpec()
+	updated := spec.Copy()
+	if err := merge(ct.Background(), exportSpec(spec), updated, nil); err != nil {
+		t.Fatalf("merge error: %v", err)
+	}
+	if !reflect.DeepEqual(updated, spec) {
+		t.Errorf("merge(exportSpec(spec), spec) = %+v, want %+v", updated, spec)
+	}
+}
+
+func TestExportPort(t *testing.T) {
+	tests := []struct {
+		port *api.PortConfig
+		s    string
+	}{
+		{&api.PortConfig{Protocol: api.ProtocolTCP, TargetPort: 80}, ":80/tcp"},
+		{&api.PortConfig{Name: "http", Protocol: api.ProtocolTCP, TargetPort: 80, PublishedPort: 8080}, "http:80/tcp:8080/tcp"},
+		{&api.PortConfig{Name: "dns", Protocol: api.ProtocolUDP, TargetPort: 53, PublishedPort: 53}, "dns:53/udp:53/udp"},
+	}
+	for _, test := range tests {
+		s := exportPort(test.port)
+		if s != test.s {
+			t.Errorf("exportPort(%v) = %q, want %q", test.port, s, test.s)
+			continue
+		}
+		port, err := newPortConfig(s)
+		if err != nil {
+			t.Errorf("newPortConfig(%q) error: %v", s, err)
+			continue
+		}
+		if !reflect.DeepEqual(port, test.port) {
+			t.Errorf("newPortConfig(%q) = %v, want %v", s, port, test.port)
+		}
+	}
+}
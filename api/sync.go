@@ -0,0 +1,297 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	ct "golang.org/x/net/context"
+	"gopkg.in/yaml.v2"
+)
+
+// syncIdentity is the user recorded in the revisions of the services changed
+// by the sync controller.
+var syncIdentity = &Identity{Name: "sync", Role: RoleAdmin, Method: "sync"}
+
+// SyncOptions are the settings of the sync controller, which converges the
+// cluster to the services and networks described by the files of a directory.
+type SyncOptions struct {
+	// Dir holds the .json, .yml and .yaml files, each one a document of
+	// POST /apply without prune. Subdirectories are read, hidden ones skipped.
+	Dir string
+	// PollInterval is the period of the checks for changed files.
+	PollInterval time.Duration
+	// Interval is the period of the comparisons of the cluster with the
+	// files, which find the changes made out-of-band (0 = only on change).
+	Interval time.Duration
+	// Prune deletes the objects created by the controller that are no longer
+	// in the files. A directory without any file is not applied.
+	Prune bool
+	// CorrectDrift applies the files again when the cluster drifted from
+	// them, otherwise the drift is only reported.
+	CorrectDrift bool
+}
+
+// syncStatus is the outcome of the last run of the sync controller.
+type syncStatus struct {
+	Dir             string          `json:"dir"`
+	Revision        string          `json:"revision,omitempty"`         // hash of the files
+	AppliedRevision string          `json:"applied_revision,omitempty"` // hash of the files last applied
+	Files           []string        `json:"files"`
+	Trigger         string          `json:"trigger,omitempty"` // startup, change or interval
+	LastSync        time.Time       `json:"last_sync,omitempty"`
+	LastApply       time.Time       `json:"last_apply,omitempty"`
+	InSync          bool            `json:"in_sync"`
+	Drift           []*objectResult `json:"drift,omitempty"`   // objects differing from the files
+	Results         []*objectResult `json:"results,omitempty"` // actions of the last apply
+	Error           string          `json:"error,omitempty"`
+}
+
+// syncController applies the files of a directory to the cluster when they
+// change and checks periodically that the cluster still matches them. Like
+// the watcher it polls, the files and the manager have no watch API in use.
+type syncController struct {
+	c       *context
+	opts    SyncOptions
+	timeout time.Duration // bound of one run, 0 = none
+
+	sync.RWMutex
+	status syncStatus
+}
+
+func newSyncController(c *context, opts SyncOptions, timeout time.Duration) *syncController {
+	return &syncController{
+		c:       c,
+		opts:    opts,
+		timeout: timeout,
+		status:  syncStatus{Dir: opts.Dir, Files: []string{}},
+	}
+}
+
+// Run syncs at startup, then when the files change and on every interval,
+// until ctx is done.
+func (sc *syncController) Run(ctx ct.Context) {
+	poll := time.NewTicker(sc.opts.PollInterval)
+	defer poll.Stop()
+	var interval <-chan time.Time
+	if sc.opts.Interval > 0 {
+		ticker := time.NewTicker(sc.opts.Interval)
+		defer ticker.Stop()
+		interval = ticker.C
+	}
+
+	sc.run(ctx, "startup")
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			if sc.changed() {
+				sc.run(ctx, "change")
+			}
+		case <-interval:
+			sc.run(ctx, "interval")
+		}
+	}
+}
+
+// Status returns a copy of the last status.
+func (sc *syncController) Status() syncStatus {
+	sc.RLock()
+	defer sc.RUnlock()
+	return sc.status
+}
+
+// changed reports whether the files differ from the ones last read.
+func (sc *syncController) changed() bool {
+	status := sc.Status()
+	_, revision, err := readSyncDir(sc.opts.Dir)
+	if err != nil {
+		// reported by a run, once
+		return err.Error() != status.Error
+	}
+	return revision != status.Revision
+}
+
+// run reads the files, compares them with the cluster and applies them when
+// they changed since the last apply, or when drift is corrected.
+func (sc *syncController) run(ctx ct.Context, trigger string) {
+	if sc.timeout > 0 {
+		var cancel ct.CancelFunc
+		ctx, cancel = ct.WithTimeout(ctx, sc.timeout)
+		defer cancel()
+	}
+
+	status := sc.Status()
+	status.Trigger, status.LastSync, status.Error = trigger, time.Now().UTC(), ""
+	defer func() {
+		sc.Lock()
+		sc.status = status
+		sc.Unlock()
+	}()
+	logger := log.WithFields(log.Fields{"dir": sc.opts.Dir, "trigger": trigger})
+
+	docs, revision, err := readSyncDir(sc.opts.Dir)
+	if err != nil {
+		status.Error = err.Error()
+		logger.Errorf("Sync read error: %v", err)
+		return
+	}
+	status.Revision = revision
+	status.Files = []string{}
+	doc := &applyDocument{Prune: sc.opts.Prune, manager: "sync"}
+	for _, f := range docs {
+		status.Files = append(status.Files, f.name)
+		doc.Networks = append(doc.Networks, f.doc.Networks...)
+		doc.Services = append(doc.Services, f.doc.Services...)
+		doc.Adopt = doc.Adopt || f.doc.Adopt
+	}
+	// an empty directory, e.g. a checkout in progress or a missing mount,
+	// would prune every object of the controller
+	if doc.Prune && len(docs) == 0 {
+		status.InSync = false
+		status.Error = "the sync directory has no file, nothing was applied or pruned"
+		logger.Error("Sync directory has no file, refusing to prune")
+		return
+	}
+
+	plan, err := planApply(ctx, sc.c.swarmkitAPI, doc)
+	if err != nil {
+		status.Error = err.Error()
+		logger.Errorf("Sync plan error: %v", err)
+		return
+	}
+	status.Drift = []*objectResult{}
+	for _, res := range plan.results() {
+		if res.Action != "unchanged" || len(res.Error) > 0 {
+			status.Drift = append(status.Drift, res)
+		}
+	}
+	status.InSync = len(status.Drift) == 0
+	if status.InSync {
+		status.AppliedRevision = revision
+		return
+	}
+	if plan.failed() {
+		status.Error = "the files are invalid, nothing was applied"
+		logger.Error("Sync plan failed, nothing was applied")
+		return
+	}
+
+	// the cluster drifted out-of-band when the files were already applied
+	if revision == status.AppliedRevision && !sc.opts.CorrectDrift {
+		logger.Warnf("Cluster drifted from the sync files on %d objects", len(status.Drift))
+		return
+	}
+
+	status.Results = plan.execute(sc.c, backgroundRequest(ctx, syncIdentity))
+	status.LastApply = time.Now().UTC()
+	status.InSync = true
+	for _, res := range status.Results {
+		if len(res.Error) > 0 {
+			status.InSync = false
+			status.Error = "some objects could not be applied"
+		}
+	}
+	// a partial apply is retried on the next run, not reported as drift
+	if status.InSync {
+		status.AppliedRevision = revision
+		status.Drift = []*objectResult{}
+	}
+	logger.WithField("revision", revision).Infof("Sync applied %d objects", len(status.Results))
+}
+
+// syncFile is a parsed file of the sync directory.
+type syncFile struct {
+	name string // relative to the directory
+	doc  *applyDocument
+}
+
+// readSyncDir parses the files of dir in lexical order and returns them with
+// a hash of their names and contents.
+func readSyncDir(dir string) ([]*syncFile, string, error) {
+	names := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".json", ".yml", ".yaml":
+			names = append(names, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	files := []*syncFile{}
+	for _, path := range names {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, "", err
+		}
+		name, _ := filepath.Rel(dir, path)
+		fmt.Fprintf(hash, "%s\x00%d\x00", name, len(b))
+		hash.Write(b)
+
+		doc := &applyDocument{}
+		if err := unmarshalSyncFile(path, b, doc); err != nil {
+			return nil, "", fmt.Errorf("%s: %v", name, err)
+		}
+		files = append(files, &syncFile{name: name, doc: doc})
+	}
+	return files, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// unmarshalSyncFile decodes a json or yaml file with the json field names of
+// the api: yaml is converted to json first.
+func unmarshalSyncFile(path string, b []byte, v interface{}) error {
+	if strings.ToLower(filepath.Ext(path)) != ".json" {
+		var doc interface{}
+		if err := yaml.Unmarshal(b, &doc); err != nil {
+			return err
+		}
+		var err error
+		if b, err = json.Marshal(jsonValue(doc)); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(b, v)
+}
+
+// jsonValue converts the maps decoded by yaml, keyed by interface{}, to maps
+// json can encode.
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonValue(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonValue(e)
+		}
+		return v
+	}
+	return v
+}
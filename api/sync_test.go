@@ -0,0 +1,101 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/docker/swarmkit/api"
+	ct "golang.org/x/net/context"
+)
+
+func TestSyncDrift(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := newClusterTestClient()
+	sc := newSyncController(newTestContext(client), SyncOptions{Dir: dir, Prune: true}, 0)
+	// an out-of-band change of web
+	changeWeb := func() {
+		web := client.service("web")
+		spec := web.Spec.Copy()
+		spec.Task.GetContainer().Image = "nginx:latest"
+		if _, err := client.UpdateService(ct.Background(), &api.UpdateServiceRequest{ServiceID: web.ID, ServiceVersion: &web.Meta.Version, Spec: spec}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		change  func()
+		correct bool
+		inSync  bool
+		drift   []string // objects differing from the files
+		changes []string // calls received by the manager
+		err     string
+	}{
+		{
+			"no file",
+			func() {},
+			false, false, nil, nil,
+			"the sync directory has no file, nothing was applied or pruned",
+		},
+		{
+			"first files",
+			func() {
+				writeFile("web.yml", "services:\n  - name: web\n    image: nginx\n  - name: db\n    image: redis\n")
+			},
+			false, true, []string{}, []string{"CreateService db", "CreateService web"}, "",
+		},
+		{"nothing changed", func() {}, false, true, []string{}, nil, ""},
+		{"drift reported", changeWeb, false, false, []string{"service web update"}, nil, ""},
+		{"drift corrected", func() {}, true, true, []string{}, []string{"UpdateService web"}, ""},
+		{
+			"service removed from the files",
+			func() { writeFile("web.yml", "services:\n  - name: web\n    image: nginx\n") },
+			false, true, []string{}, []string{"RemoveService db"}, "",
+		},
+		{
+			"every file removed",
+			func() { os.Remove(filepath.Join(dir, "web.yml")) },
+			false, false, []string{}, nil,
+			"the sync directory has no file, nothing was applied or pruned",
+		},
+	}
+	for _, test := range tests {
+		test.change()
+		sc.opts.CorrectDrift = test.correct
+		before := len(client.changes())
+		sc.run(ct.Background(), "interval")
+		status := sc.Status()
+
+		if status.Error != test.err {
+			t.Errorf("%s: got error %q, want %q", test.name, status.Error, test.err)
+		}
+		if status.InSync != test.inSync {
+			t.Errorf("%s: got in sync %v, want %v", test.name, status.InSync, test.inSync)
+		}
+		if drift := planActions(status.Drift); len(test.err) == 0 && !reflect.DeepEqual(drift, test.drift) {
+			t.Errorf("%s: got drift %v, want %v", test.name, drift, test.drift)
+		}
+		if changes := client.changes()[before:]; len(changes) > 0 || len(test.changes) > 0 {
+			if !reflect.DeepEqual(changes, test.changes) {
+				t.Errorf("%s: got changes %v, want %v", test.name, changes, test.changes)
+			}
+		}
+	}
+
+	if client.service("web") == nil {
+		t.Error("web was pruned with the empty directory")
+	}
+}
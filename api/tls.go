@@ -0,0 +1,161 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// TLSOptions describes the certificates used by the http server.
+type TLSOptions struct {
+	CertFile          string        // server certificate (PEM)
+	KeyFile           string        // server private key (PEM)
+	CAFile            string        // CA bundle used to verify client certificates (PEM)
+	RequireClientCert bool          // reject clients that do not present a certificate signed by CAFile
+	ReloadInterval    time.Duration // how often the files are checked for changes (0 = never)
+}
+
+// certReloader keeps the server certificate and client CA pool in memory
+// and reloads them whenever the files on disk change, so rotated certificates
+// are picked up without restarting the server.
+type certReloader struct {
+	sync.RWMutex
+	opts    TLSOptions
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime map[string]time.Time
+}
+
+// NewTLSConfig creates a tls.Config for the http server from opts. The
+// certificate and CA files are watched and reloaded when they change.
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	if len(opts.CertFile) == 0 || len(opts.KeyFile) == 0 {
+		return nil, errors.New("tls certificate and key are mandatory")
+	}
+	if opts.RequireClientCert && len(opts.CAFile) == 0 {
+		return nil, errors.New("a CA certificate is required to verify client certificates")
+	}
+
+	cr := &certReloader{
+		opts:    opts,
+		modTime: make(map[string]time.Time),
+	}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	if opts.ReloadInterval > 0 {
+		go cr.watch(opts.ReloadInterval)
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cr.getCertificate,
+	}
+	if len(opts.CAFile) > 0 {
+		tlsConfig.GetConfigForClient = cr.getConfigForClient
+	}
+	return tlsConfig, nil
+}
+
+func (cr *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.RLock()
+	defer cr.RUnlock()
+	return cr.cert, nil
+}
+
+// getConfigForClient returns a per-connection config so that every handshake
+// verifies clients against the most recently loaded CA pool.
+func (cr *certReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	cr.RLock()
+	defer cr.RUnlock()
+
+	clientAuth := tls.VerifyClientCertIfGiven
+	if cr.opts.RequireClientCert {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"http/1.1"},
+		GetCertificate: cr.getCertificate,
+		ClientCAs:      cr.pool,
+		ClientAuth:     clientAuth,
+	}, nil
+}
+
+// reload reads the certificate, key and CA files from disk.
+func (cr *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.opts.CertFile, cr.opts.KeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load server certificate: %v", err)
+	}
+
+	var pool *x509.CertPool
+	if len(cr.opts.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(cr.opts.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA certificate: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no valid certificate found in %s", cr.opts.CAFile)
+		}
+	}
+
+	modTime := make(map[string]time.Time)
+	for _, f := range []string{cr.opts.CertFile, cr.opts.KeyFile, cr.opts.CAFile} {
+		if len(f) == 0 {
+			continue
+		}
+		if fi, err := os.Stat(f); err == nil {
+			modTime[f] = fi.ModTime()
+		}
+	}
+
+	cr.Lock()
+	cr.cert = &cert
+	cr.pool = pool
+	cr.modTime = modTime
+	cr.Unlock()
+	return nil
+}
+
+// changed reports whether any of the watched files has a new modification time.
+func (cr *certReloader) changed() bool {
+	cr.RLock()
+	defer cr.RUnlock()
+	for f, t := range cr.modTime {
+		fi, err := os.Stat(f)
+		if err != nil {
+			// the file may be in the middle of being replaced, retry later
+			continue
+		}
+		if !fi.ModTime().Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch polls the certificate files and reloads them when they change. A
+// failed reload keeps the previous certificates in place.
+func (cr *certReloader) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !cr.changed() {
+			continue
+		}
+		if err := cr.reload(); err != nil {
+			log.WithField("cert", cr.opts.CertFile).Errorf("Reload TLS certificates error: %v", err)
+			continue
+		}
+		log.WithField("cert", cr.opts.CertFile).Info("TLS certificates reloaded")
+	}
+}
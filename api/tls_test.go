@@ -0,0 +1,232 @@
+package api
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA signs the certificates of the tls tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns the PEM certificate and key of cn, for a server or a client.
+func (ca *testCA) issue(t *testing.T, cn string, serial int64, usage x509.ExtKeyUsage) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeTestFile(t *testing.T, path string, data []byte) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tlsTestServer writes a server certificate signed by a new CA and returns
+// the options of a server using it.
+func tlsTestServer(t *testing.T, dir string) (*testCA, TLSOptions) {
+	ca := newTestCA(t)
+	opts := TLSOptions{
+		CertFile: filepath.Join(dir, "cert.pem"),
+		KeyFile:  filepath.Join(dir, "key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+	}
+	cert, key := ca.issue(t, "server", 2, x509.ExtKeyUsageServerAuth)
+	writeTestFile(t, opts.CertFile, cert)
+	writeTestFile(t, opts.KeyFile, key)
+	writeTestFile(t, opts.CAFile, ca.pem)
+	return ca, opts
+}
+
+// serveTLS accepts connections with config and sends the outcome of their
+// handshake on the returned channel.
+func serveTLS(t *testing.T, config *tls.Config) (net.Listener, <-chan error) {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handshakes := make(chan error, 16)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			err = conn.(*tls.Conn).Handshake()
+			select {
+			case handshakes <- err:
+			default:
+				// not checked by the test
+			}
+			conn.Close()
+		}
+	}()
+	return l, handshakes
+}
+
+func dialTLS(addr string, ca *testCA, certs []tls.Certificate) (*tls.Conn, error) {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, Certificates: certs})
+}
+
+func TestTLSRequireClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, opts := tlsTestServer(t, dir)
+	opts.RequireClientCert = true
+	config, err := NewTLSConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, handshakes := serveTLS(t, config)
+	defer l.Close()
+
+	certPEM, keyPEM := ca.issue(t, "client", 3, x509.ExtKeyUsageClientAuth)
+	clientCert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := dialTLS(l.Addr().String(), ca, []tls.Certificate{clientCert})
+	if err != nil {
+		t.Fatalf("handshake with a client certificate: %v", err)
+	}
+	conn.Close()
+	if err = <-handshakes; err != nil {
+		t.Fatalf("server handshake with a client certificate: %v", err)
+	}
+
+	// the client may see the rejection only on its first read
+	if conn, err = dialTLS(l.Addr().String(), ca, nil); err == nil {
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if err = <-handshakes; err == nil {
+		t.Fatal("server handshake without a client certificate: want an error")
+	}
+
+	other := newTestCA(t)
+	certPEM, keyPEM = other.issue(t, "client", 3, x509.ExtKeyUsageClientAuth)
+	if clientCert, err = tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatal(err)
+	}
+	if conn, err = dialTLS(l.Addr().String(), ca, []tls.Certificate{clientCert}); err == nil {
+		conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if err = <-handshakes; err == nil {
+		t.Fatal("server handshake with a certificate of another CA: want an error")
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, opts := tlsTestServer(t, dir)
+	opts.ReloadInterval = 10 * time.Millisecond
+	config, err := NewTLSConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, _ := serveTLS(t, config)
+	defer l.Close()
+
+	serial := func() int64 {
+		conn, err := dialTLS(l.Addr().String(), ca, nil)
+		if err != nil {
+			t.Fatalf("handshake: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if s := serial(); s != 2 {
+		t.Fatalf("serial = %d, want 2", s)
+	}
+
+	cert, key := ca.issue(t, "server", 4, x509.ExtKeyUsageServerAuth)
+	writeTestFile(t, opts.KeyFile, key)
+	writeTestFile(t, opts.CertFile, cert)
+	// the modification time may not change within its resolution
+	later := time.Now().Add(time.Minute)
+	for _, f := range []string{opts.CertFile, opts.KeyFile} {
+		if err = os.Chtimes(f, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for serial() != 4 {
+		if time.Now().After(deadline) {
+			t.Fatal("the rewritten certificate was not served")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
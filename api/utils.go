@@ -1,8 +1,17 @@
 package api
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	ct "golang.org/x/net/context"
 )
 
 // 解析http.request中body参数到实体
@@ -10,3 +19,50 @@ func DecoderRequest(req *http.Request, struzt interface{}) error {
 	decoder := json.NewDecoder(req.Body)
 	return decoder.Decode(struzt)
 }
+
+// queryBool reports whether the query parameter name is set to 1 or true.
+func queryBool(r *http.Request, name string) bool {
+	b, _ := strconv.ParseBool(r.URL.Query().Get(name))
+	return b
+}
+
+// backgroundRequest returns a request standing for the work done by the
+// client itself on behalf of id, e.g. to record the author of revisions.
+func backgroundRequest(ctx ct.Context, id *Identity) *http.Request {
+	r := &http.Request{Method: http.MethodPost, URL: &url.URL{Path: "/"}, Header: make(http.Header)}
+	return withIdentity(r.WithContext(ctx), id)
+}
+
+// writeFileAtomic writes data to a temporary file renamed over path, so a
+// crash never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// newID returns a random identifier for the objects owned by this client.
+func newID() string {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/swarmkit/api"
+	ct "golang.org/x/net/context"
+)
+
+// snapshot is what the watcher remembers of an object between two listings.
+type snapshot struct {
+	version uint64
+	name    string
+	labels  map[string]string
+	state   string // task or node status, empty for other kinds
+}
+
+// watcher lists the cluster objects periodically and turns the differences
+// between two listings into events. The manager has no watch API, so changes
+// are detected with Meta.Version and status changes.
+type watcher struct {
+	client   api.ControlClient
+	interval time.Duration
+	handlers []func(*Event)
+
+	seq     uint64
+	objects map[string]map[string]*snapshot // kind -> object ID -> snapshot
+	synced  bool                            // a first listing was taken
+}
+
+func newWatcher(client api.ControlClient, interval time.Duration) *watcher {
+	return &watcher{
+		client:   client,
+		interval: interval,
+		objects:  make(map[string]map[string]*snapshot),
+	}
+}
+
+// Subscribe registers fn to be called with every event, in order.
+func (wt *watcher) Subscribe(fn func(*Event)) {
+	wt.handlers = append(wt.handlers, fn)
+}
+
+// Run polls the manager until ctx is done.
+func (wt *watcher) Run(ctx ct.Context) {
+	ticker := time.NewTicker(wt.interval)
+	defer ticker.Stop()
+	for {
+		if err := wt.poll(ctx); err != nil {
+			log.Warnf("Watch cluster error: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll lists every kind of object and emits the events found.
+func (wt *watcher) poll(ctx ct.Context) error {
+	listings := map[string]map[string]*snapshot{}
+
+	services, err := wt.client.ListServices(ctx, &api.ListServicesRequest{})
+	if err != nil {
+		return err
+	}
+	listings["service"] = make(map[string]*snapshot)
+	for _, s := range services.Services {
+		listings["service"][s.ID] = &snapshot{
+			version: s.Meta.Version.Index,
+			name:    s.Spec.Annotations.Name,
+			labels:  s.Spec.Annotations.Labels,
+		}
+	}
+
+	tasks, err := wt.client.ListTasks(ctx, &api.ListTasksRequest{})
+	if err != nil {
+		return err
+	}
+	listings["task"] = make(map[string]*snapshot)
+	for _, t := range tasks.Tasks {
+		listings["task"][t.ID] = &snapshot{
+			version: t.Meta.Version.Index,
+			name:    t.ServiceAnnotations.Name,
+			labels:  t.ServiceAnnotations.Labels,
+			state:   t.Status.State.String(),
+		}
+	}
+
+	nodes, err := wt.client.ListNodes(ctx, &api.ListNodesRequest{})
+	if err != nil {
+		return err
+	}
+	listings["node"] = make(map[string]*snapshot)
+	for _, n := range nodes.Nodes {
+		name := n.Spec.Annotations.Name
+		if len(name) == 0 && n.Description != nil {
+			name = n.Description.Hostname
+		}
+		listings["node"][n.ID] = &snapshot{
+			version: n.Meta.Version.Index,
+			name:    name,
+			labels:  n.Spec.Annotations.Labels,
+			state:   n.Status.State.String(),
+		}
+	}
+
+	networks, err := wt.client.ListNetworks(ctx, &api.ListNetworksRequest{})
+	if err != nil {
+		return err
+	}
+	listings["network"] = make(map[string]*snapshot)
+	for _, n := range networks.Networks {
+		listings["network"][n.ID] = &snapshot{
+			version: n.Meta.Version.Index,
+			name:    n.Spec.Annotations.Name,
+			labels:  n.Spec.Annotations.Labels,
+		}
+	}
+
+	clusters, err := wt.client.ListClusters(ctx, &api.ListClustersRequest{})
+	if err != nil {
+		return err
+	}
+	listings["cluster"] = make(map[string]*snapshot)
+	for _, c := range clusters.Clusters {
+		listings["cluster"][c.ID] = &snapshot{
+			version: c.Meta.Version.Index,
+			name:    c.Spec.Annotations.Name,
+			labels:  c.Spec.Annotations.Labels,
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, kind := range []string{"cluster", "node", "network", "service", "task"} {
+		if wt.synced {
+			wt.diff(now, kind, wt.objects[kind], listings[kind])
+		}
+		wt.objects[kind] = listings[kind]
+	}
+	wt.synced = true
+	return nil
+}
+
+// diff emits the events turning old into cur.
+func (wt *watcher) diff(now time.Time, kind string, old, cur map[string]*snapshot) {
+	for id, s := range cur {
+		o, ok := old[id]
+		switch {
+		case !ok:
+			wt.emit(&Event{Time: now, Type: kind, Action: "create", ObjectID: id, To: s.state}, s)
+		case o.version == s.version:
+		case o.state != s.state:
+			wt.emit(&Event{Time: now, Type: kind, Action: "state", ObjectID: id, From: o.state, To: s.state}, s)
+		default:
+			wt.emit(&Event{Time: now, Type: kind, Action: "update", ObjectID: id}, s)
+		}
+	}
+	for id, o := range old {
+		if _, ok := cur[id]; !ok {
+			wt.emit(&Event{Time: now, Type: kind, Action: "delete", ObjectID: id, From: o.state}, o)
+		}
+	}
+}
+
+func (wt *watcher) emit(e *Event, s *snapshot) {
+	wt.seq++
+	e.ID = wt.seq
+	e.Name = s.name
+	e.Labels = s.labels
+	e.Version = s.version
+	for _, fn := range wt.handlers {
+		fn(e)
+	}
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/swarmkit/api"
+	ct "golang.org/x/net/context"
+)
+
+func TestWatcherDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, cur *snapshot // nil when the object does not exist
+		event    *Event    // nil when no event is expected
+	}{
+		{"created", nil, &snapshot{version: 1, name: "web", state: "NEW"}, &Event{Action: "create", Name: "web", Version: 1, To: "NEW"}},
+		{"unchanged", &snapshot{version: 1, name: "web"}, &snapshot{version: 1, name: "web"}, nil},
+		{"updated", &snapshot{version: 1, name: "web"}, &snapshot{version: 2, name: "web"}, &Event{Action: "update", Name: "web", Version: 2}},
+		{
+			"state changed",
+			&snapshot{version: 1, name: "web", state: "PENDING"},
+			&snapshot{version: 2, name: "web", state: "RUNNING"},
+			&Event{Action: "state", Name: "web", Version: 2, From: "PENDING", To: "RUNNING"},
+		},
+		{"deleted", &snapshot{version: 3, name: "web", state: "RUNNING"}, nil, &Event{Action: "delete", Name: "web", Version: 3, From: "RUNNING"}},
+	}
+
+	now := time.Now().UTC()
+	for _, test := range tests {
+		old, cur := map[string]*snapshot{}, map[string]*snapshot{}
+		if test.old != nil {
+			old["obj"] = test.old
+		}
+		if test.cur != nil {
+			cur["obj"] = test.cur
+		}
+		events := []*Event{}
+		wt := newWatcher(nil, time.Second)
+		wt.Subscribe(func(e *Event) { events = append(events, e) })
+		wt.diff(now, "task", old, cur)
+
+		want := []*Event{}
+		if test.event != nil {
+			e := *test.event
+			e.ID, e.Time, e.Type, e.ObjectID = 1, now, "task", "obj"
+			want = append(want, &e)
+		}
+		if !reflect.DeepEqual(events, want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, events, want)
+		}
+	}
+}
+
+func TestWatcherPoll(t *testing.T) {
+	client := newClusterTestClient()
+	web := client.addService(testServiceSpec("web", "nginx", nil))
+	client.setTasks(web.ID, 1, api.TaskStatePending)
+	task := web.ID + ".1.1"
+
+	wt := newWatcher(client, time.Second)
+	events := []*Event{}
+	wt.Subscribe(func(e *Event) { events = append(events, e) })
+
+	tests := []struct {
+		name    string
+		change  func()
+		actions []string // type action name of the events
+	}{
+		{"first listing", func() {}, []string{}},
+		{"nothing changed", func() {}, []string{}},
+		{"service created", func() { client.addService(testServiceSpec("db", "redis", nil)) }, []string{"service create db"}},
+		{"task running", func() { client.setTaskState(task, api.TaskStateRunning) }, []string{"task state web"}},
+		{"service updated", func() {
+			s := client.service("db")
+			client.UpdateService(ct.Background(), &api.UpdateServiceRequest{ServiceID: s.ID, ServiceVersion: &s.Meta.Version, Spec: testServiceSpec("db", "redis:3", nil)})
+		}, []string{"service update db"}},
+		{"service removed", func() { client.RemoveService(ct.Background(), &api.RemoveServiceRequest{ServiceID: "db-id"}) }, []string{"service delete db"}},
+	}
+	for _, test := range tests {
+		test.change()
+		events = events[:0]
+		if err := wt.poll(ct.Background()); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		actions := []string{}
+		for _, e := range events {
+			actions = append(actions, e.Type+" "+e.Action+" "+e.Name)
+		}
+		if !reflect.DeepEqual(actions, test.actions) {
+			t.Errorf("%s: got events %v, want %v", test.name, actions, test.actions)
+		}
+	}
+
+	// every event got the next ID
+	if last := wt.seq; last != 4 {
+		t.Errorf("got %d events, want 4", last)
+	}
+}
@@ -0,0 +1,403 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const (
+	webhookQueueSize    = 128
+	webhookHistorySize  = 100
+	webhookRetryBackoff = time.Second
+	webhookMaxBackoff   = time.Minute
+)
+
+// webhook is a subscription posting the matching events to an url.
+type webhook struct {
+	ID         string              `json:"id"`
+	URL        string              `json:"url"`
+	Secret     string              `json:"secret,omitempty"`  // key of the HMAC signature of the payloads
+	Filters    map[string][]string `json:"filters,omitempty"` // same filters as GET /events
+	MaxRetries int                 `json:"max_retries"`
+	CreatedAt  time.Time           `json:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+}
+
+// delivery is one event posted to a webhook.
+type delivery struct {
+	ID         string    `json:"id"`
+	WebhookID  string    `json:"webhook_id"`
+	Event      *Event    `json:"event"`
+	Time       time.Time `json:"time"`
+	Attempts   int       `json:"attempts"`
+	Status     string    `json:"status"` // pending, delivered or dead
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// webhookWorker posts the events of one webhook in order.
+type webhookWorker struct {
+	hook   *webhook
+	filter *eventFilter
+	queue  chan *delivery
+	done   chan struct{}
+
+	sync.Mutex
+	history []*delivery
+}
+
+// webhookManager keeps the webhooks, persisted in a json file of the data
+// directory, and delivers the events to them. Deliveries that failed every
+// attempt are appended to a dead letter file.
+type webhookManager struct {
+	sync.RWMutex
+	path     string
+	deadPath string
+	client   *http.Client
+	backoff  time.Duration // delay of the first retry, doubled on every retry
+	workers  map[string]*webhookWorker
+
+	deadMu sync.Mutex
+}
+
+func newWebhookManager(dataDir string) (*webhookManager, error) {
+	m := &webhookManager{
+		path:     filepath.Join(dataDir, "webhooks.json"),
+		deadPath: filepath.Join(dataDir, "webhooks-dead-letter.jsonl"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		backoff:  webhookRetryBackoff,
+		workers:  make(map[string]*webhookWorker),
+	}
+
+	b, err := ioutil.ReadFile(m.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(b) > 0 {
+		hooks := []*webhook{}
+		if err = json.Unmarshal(b, &hooks); err != nil {
+			return nil, fmt.Errorf("parse %s: %v", m.path, err)
+		}
+		for _, h := range hooks {
+			m.start(h)
+		}
+	}
+	return m, nil
+}
+
+// save writes every webhook to the json file. The caller holds the lock.
+func (m *webhookManager) save() error {
+	hooks := make([]*webhook, 0, len(m.workers))
+	for _, w := range m.workers {
+		hooks = append(hooks, w.hook)
+	}
+	b, err := json.MarshalIndent(hooks, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.path, b, 0600)
+}
+
+// start runs a worker for h. The caller holds the lock.
+func (m *webhookManager) start(h *webhook) {
+	w := &webhookWorker{
+		hook:   h,
+		filter: newEventFilter(h.Filters),
+		queue:  make(chan *delivery, webhookQueueSize),
+		done:   make(chan struct{}),
+	}
+	m.workers[h.ID] = w
+	go m.run(w)
+}
+
+func validateWebhook(h *webhook) error {
+	if len(h.URL) == 0 {
+		return grpc.Errorf(codes.InvalidArgument, "url is required")
+	}
+	if _, err := http.NewRequest(http.MethodPost, h.URL, nil); err != nil {
+		return grpc.Errorf(codes.InvalidArgument, "invalid url: %v", err)
+	}
+	if h.MaxRetries < 0 {
+		return grpc.Errorf(codes.InvalidArgument, "max_retries must be positive")
+	}
+	return nil
+}
+
+func (m *webhookManager) create(h *webhook) error {
+	if err := validateWebhook(h); err != nil {
+		return err
+	}
+	h.ID = newID()
+	h.CreatedAt = time.Now().UTC()
+	h.UpdatedAt = h.CreatedAt
+
+	m.Lock()
+	defer m.Unlock()
+	m.start(h)
+	if err := m.save(); err != nil {
+		close(m.workers[h.ID].done)
+		delete(m.workers, h.ID)
+		return err
+	}
+	return nil
+}
+
+// update replaces the webhook with id by h. Pending deliveries are posted
+// with the new settings.
+func (m *webhookManager) update(id string, h *webhook) error {
+	if err := validateWebhook(h); err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	w, ok := m.workers[id]
+	if !ok {
+		return notFound("webhook", id)
+	}
+	h.ID = id
+	h.CreatedAt = w.hook.CreatedAt
+	h.UpdatedAt = time.Now().UTC()
+
+	w.Lock()
+	w.hook = h
+	w.filter = newEventFilter(h.Filters)
+	w.Unlock()
+	return m.save()
+}
+
+func (m *webhookManager) remove(id string) error {
+	m.Lock()
+	defer m.Unlock()
+	w, ok := m.workers[id]
+	if !ok {
+		return notFound("webhook", id)
+	}
+	close(w.done)
+	delete(m.workers, id)
+	return m.save()
+}
+
+func (m *webhookManager) get(id string) (*webhookWorker, error) {
+	m.RLock()
+	defer m.RUnlock()
+	w, ok := m.workers[id]
+	if !ok {
+		return nil, notFound("webhook", id)
+	}
+	return w, nil
+}
+
+func (m *webhookManager) list() []*webhook {
+	m.RLock()
+	defer m.RUnlock()
+	hooks := make([]*webhook, 0, len(m.workers))
+	for _, w := range m.workers {
+		w.Lock()
+		hooks = append(hooks, w.hook)
+		w.Unlock()
+	}
+	return hooks
+}
+
+// Handle queues the event for the matching webhooks. It never blocks: when
+// the queue of a webhook is full the delivery goes to the dead letters.
+func (m *webhookManager) Handle(e *Event) {
+	m.RLock()
+	defer m.RUnlock()
+	for id, w := range m.workers {
+		w.Lock()
+		match := w.filter.match(e)
+		w.Unlock()
+		if !match {
+			continue
+		}
+
+		d := &delivery{ID: newID(), WebhookID: id, Event: e, Time: time.Now().UTC(), Status: "pending"}
+		select {
+		case w.queue <- d:
+		default:
+			d.Status, d.Error = "dead", "delivery queue is full"
+			w.record(d)
+			m.deadLetter(d)
+		}
+	}
+}
+
+// run posts the queued deliveries of w until the webhook is removed.
+func (m *webhookManager) run(w *webhookWorker) {
+	for {
+		select {
+		case <-w.done:
+			return
+		case d := <-w.queue:
+			w.record(d)
+			m.deliver(w, d)
+		}
+	}
+}
+
+// deliver posts d, retrying with an exponential backoff.
+func (m *webhookManager) deliver(w *webhookWorker, d *delivery) {
+	payload, err := json.Marshal(d.Event)
+	if err != nil {
+		log.Errorf("Encode webhook payload error: %v", err)
+		return
+	}
+
+	backoff := m.backoff
+	for {
+		w.Lock()
+		hook := w.hook
+		w.Unlock()
+
+		code, err := m.post(hook, d, payload)
+		w.Lock()
+		d.Attempts++
+		d.StatusCode = code
+		if err == nil {
+			d.Status, d.Error = "delivered", ""
+			w.Unlock()
+			return
+		}
+		d.Error = err.Error()
+		if d.Attempts > hook.MaxRetries {
+			d.Status = "dead"
+			w.Unlock()
+			log.WithFields(log.Fields{"webhook": hook.ID, "url": hook.URL}).Warnf("Webhook delivery failed: %v", err)
+			m.deadLetter(d)
+			return
+		}
+		w.Unlock()
+
+		select {
+		case <-w.done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+// signature returns the X-Swarmkit-Signature of payload sent at timestamp:
+// "sha256=" followed by the hex HMAC of the timestamp, a dot and the payload.
+// Signing the timestamp lets receivers reject replayed deliveries.
+func signature(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// post sends one attempt of d, signed with the webhook secret.
+func (m *webhookManager) post(hook *webhook, d *delivery, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "swarmkit-client")
+	req.Header.Set("X-Swarmkit-Event", d.Event.Type+"."+d.Event.Action)
+	req.Header.Set("X-Swarmkit-Delivery", d.ID)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("X-Swarmkit-Timestamp", timestamp)
+	if len(hook.Secret) > 0 {
+		req.Header.Set("X-Swarmkit-Signature", signature(hook.Secret, timestamp, payload))
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record adds d to the delivery history of w.
+func (w *webhookWorker) record(d *delivery) {
+	w.Lock()
+	defer w.Unlock()
+	w.history = append(w.history, d)
+	if len(w.history) > webhookHistorySize {
+		w.history = w.history[len(w.history)-webhookHistorySize:]
+	}
+}
+
+// deliveries returns a copy of the delivery history, oldest first.
+func (w *webhookWorker) deliveries() []delivery {
+	w.Lock()
+	defer w.Unlock()
+	ds := make([]delivery, 0, len(w.history))
+	for _, d := range w.history {
+		ds = append(ds, *d)
+	}
+	return ds
+}
+
+func (m *webhookManager) deadLetter(d *delivery) {
+	m.deadMu.Lock()
+	defer m.deadMu.Unlock()
+
+	b, err := json.Marshal(d)
+	if err == nil {
+		var f *os.File
+		if f, err = os.OpenFile(m.deadPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600); err == nil {
+			_, err = f.Write(append(b, '\n'))
+			f.Close()
+		}
+	}
+	if err != nil {
+		log.WithField("webhook", d.WebhookID).Errorf("Write dead letter error: %v", err)
+	}
+}
+
+// deadLetters returns the dead letters of the webhook id.
+func (m *webhookManager) deadLetters(id string) ([]*delivery, error) {
+	m.deadMu.Lock()
+	defer m.deadMu.Unlock()
+
+	ds := []*delivery{}
+	f, err := os.Open(m.deadPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ds, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		d := &delivery{}
+		if err := json.Unmarshal(scanner.Bytes(), d); err != nil {
+			continue
+		}
+		if d.WebhookID == id {
+			ds = append(ds, d)
+		}
+	}
+	return ds, scanner.Err()
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// webhookRequest is a request received by a test receiver.
+type webhookRequest struct {
+	time   time.Time
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver answers every request with status and sends it on the
+// returned channel.
+func webhookReceiver(t *testing.T, status int) (*httptest.Server, <-chan *webhookRequest) {
+	requests := make(chan *webhookRequest, 16)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		requests <- &webhookRequest{time: time.Now(), header: r.Header, body: body}
+		w.WriteHeader(status)
+	}))
+	return s, requests
+}
+
+func receiveWebhook(t *testing.T, requests <-chan *webhookRequest) *webhookRequest {
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook request received")
+	}
+	return nil
+}
+
+func testWebhookManager(t *testing.T) (*webhookManager, string) {
+	dir, err := ioutil.TempDir("", "webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := newWebhookManager(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return m, dir
+}
+
+var testWebhookEvent = &Event{ID: 1, Type: "service", Action: "create", ObjectID: "abc", Name: "web"}
+
+// checkSignature verifies req as a receiver would, the signature covers the
+// timestamp and the body.
+func checkSignature(t *testing.T, req *webhookRequest, secret string) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(req.header.Get("X-Swarmkit-Timestamp") + "."))
+	mac.Write(req.body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := req.header.Get("X-Swarmkit-Signature"); got != want {
+		t.Errorf("X-Swarmkit-Signature = %q, want %q", got, want)
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	s, requests := webhookReceiver(t, http.StatusOK)
+	defer s.Close()
+	m, dir := testWebhookManager(t)
+	defer os.RemoveAll(dir)
+
+	h := &webhook{URL: s.URL, Secret: "s3cret"}
+	if err := m.create(h); err != nil {
+		t.Fatal(err)
+	}
+	m.Handle(testWebhookEvent)
+
+	req := receiveWebhook(t, requests)
+	checkSignature(t, req, "s3cret")
+	if got := req.header.Get("X-Swarmkit-Event"); got != "service.create" {
+		t.Errorf("X-Swarmkit-Event = %q, want service.create", got)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	s, requests := webhookReceiver(t, http.StatusInternalServerError)
+	defer s.Close()
+	m, dir := testWebhookManager(t)
+	defer os.RemoveAll(dir)
+	m.backoff = 50 * time.Millisecond
+
+	h := &webhook{URL: s.URL, MaxRetries: 2}
+	if err := m.create(h); err != nil {
+		t.Fatal(err)
+	}
+	m.Handle(testWebhookEvent)
+
+	attempts := []time.Time{}
+	for i := 0; i < 3; i++ {
+		attempts = append(attempts, receiveWebhook(t, requests).time)
+	}
+	// the backoff doubles after every retry
+	for i, want := range []time.Duration{50 * time.Millisecond, 100 * time.Millisecond} {
+		if d := attempts[i+1].Sub(attempts[i]); d < want {
+			t.Errorf("retry %d after %s, want at least %s", i+1, d, want)
+		}
+	}
+
+	var dead []*delivery
+	deadline := time.Now().Add(5 * time.Second)
+	for len(dead) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no dead letter written")
+		}
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		if dead, err = m.deadLetters(h.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := dead[0]
+	if d.Attempts != 3 || d.Status != "dead" || d.StatusCode != http.StatusInternalServerError {
+		t.Errorf("dead letter = %d attempts, status %s, code %d, want 3 attempts, status dead, code 500", d.Attempts, d.Status, d.StatusCode)
+	}
+	if d.Event == nil || d.Event.ObjectID != testWebhookEvent.ObjectID {
+		t.Errorf("dead letter event = %+v, want %+v", d.Event, testWebhookEvent)
+	}
+
+	select {
+	case <-requests:
+		t.Error("delivery attempted after max_retries")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestWebhookReload(t *testing.T) {
+	s, requests := webhookReceiver(t, http.StatusOK)
+	defer s.Close()
+	m, dir := testWebhookManager(t)
+	defer os.RemoveAll(dir)
+
+	h := &webhook{URL: s.URL, Secret: "s3cret", MaxRetries: 3, Filters: map[string][]string{"type": {"service"}}}
+	if err := m.create(h); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := newWebhookManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, err := reloaded.get(h.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := w.hook; got.URL != h.URL || got.Secret != h.Secret || got.MaxRetries != h.MaxRetries || len(got.Filters["type"]) != 1 {
+		t.Errorf("reloaded webhook = %+v, want %+v", got, h)
+	}
+
+	// only the reloaded manager delivers
+	m.remove(h.ID)
+	reloaded.Handle(&Event{ID: 2, Type: "node", Action: "update"})
+	reloaded.Handle(testWebhookEvent)
+	req := receiveWebhook(t, requests)
+	checkSignature(t, req, "s3cret")
+	if got := req.header.Get("X-Swarmkit-Event"); got != "service.create" {
+		t.Errorf("X-Swarmkit-Event = %q, want service.create, the filters were not reloaded", got)
+	}
+}
+
+func TestWebhookSignatureTimestamp(t *testing.T) {
+	payload := []byte(`{"id":1}`)
+	if signature("s3cret", "1469003462", payload) == signature("s3cret", "1469003463", payload) {
+		t.Error("the signature does not depend on the timestamp")
+	}
+}
+
+func TestWebhookCreateSaveError(t *testing.T) {
+	m, dir := testWebhookManager(t)
+	os.RemoveAll(dir)
+
+	if err := m.create(&webhook{URL: "http://127.0.0.1/hook"}); err == nil {
+		t.Fatal("create without data directory: want an error")
+	}
+	if hooks := m.list(); len(hooks) != 0 {
+		t.Errorf("webhooks = %v, want none after a failed create", hooks)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/shenshouer/swarmkit-client/api"
 	"github.com/shenshouer/swarmkit-client/swarmkit"
@@ -20,8 +22,10 @@ var RootCmd = &cobra.Command{
 	SilenceUsage:  true,
 	SilenceErrors: true,
 	Run: func(cmd *cobra.Command, args []string) {
-		// TODO add tls support
-		var tlsConfig *tls.Config = nil
+		tlsConfig, err := loadTLSConfig(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		enableCors, err := cmd.Flags().GetBool("api-enable-cors")
 		if err != nil {
@@ -31,16 +35,31 @@ var RootCmd = &cobra.Command{
 		if err != nil {
 			log.Fatal(err)
 		}
-		socket, err := cmd.Flags().GetString("socket")
+		managers, err := cmd.Flags().GetStringSlice("socket")
 		if err != nil {
 			log.Fatal(err)
 		}
 		server := api.NewServer(host, tlsConfig)
-		swarmkitAPI, err := swarmkit.Dial(socket)
+		dialOpts, err := loadDialOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		failoverOpts, err := loadFailoverOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		swarmkitAPI, err := swarmkit.DialManagers(managers, dialOpts, failoverOpts)
+		if err != nil {
+			log.Fatal(err)
+		}
+		primaryOpts, err := loadPrimaryOptions(cmd)
+		if err != nil {
+			log.Fatal(err)
+		}
+		primary, err := api.NewPrimary(swarmkitAPI, tlsConfig, enableCors, primaryOpts)
 		if err != nil {
 			log.Fatal(err)
 		}
-		primary := api.NewPrimary(swarmkitAPI, tlsConfig, enableCors)
 		server.SetHandler(primary)
 		log.Fatal(server.ListenAndServe())
 	},
@@ -63,8 +82,233 @@ func defaultSocket() string {
 	return "/var/run/docker/cluster/docker-swarmd.sock"
 }
 
+// loadTLSConfig builds the tls.Config for the http server from the tls flags.
+// It returns nil when no tls flag is set, and fails when tls flags are set
+// without a server certificate rather than serving plain http.
+func loadTLSConfig(cmd *cobra.Command) (*tls.Config, error) {
+	cert, err := cmd.Flags().GetString("tlscert")
+	if err != nil {
+		return nil, err
+	}
+	key, err := cmd.Flags().GetString("tlskey")
+	if err != nil {
+		return nil, err
+	}
+	ca, err := cmd.Flags().GetString("tlscacert")
+	if err != nil {
+		return nil, err
+	}
+	verify, err := cmd.Flags().GetBool("tlsverify")
+	if err != nil {
+		return nil, err
+	}
+	if len(cert) == 0 {
+		if verify || len(ca) > 0 || len(key) > 0 {
+			return nil, fmt.Errorf("--tlsverify, --tlscacert and --tlskey require --tlscert")
+		}
+		return nil, nil
+	}
+	reload, err := cmd.Flags().GetDuration("tls-reload-interval")
+	if err != nil {
+		return nil, err
+	}
+
+	return api.NewTLSConfig(api.TLSOptions{
+		CertFile:          cert,
+		KeyFile:           key,
+		CAFile:            ca,
+		RequireClientCert: verify,
+		ReloadInterval:    reload,
+	})
+}
+
+// loadDialOptions builds the connection parameters for the swarmkit manager
+// from the manager flags.
+func loadDialOptions(cmd *cobra.Command) (opts swarmkit.DialOptions, err error) {
+	if opts.CertFile, err = cmd.Flags().GetString("manager-tlscert"); err != nil {
+		return
+	}
+	if opts.KeyFile, err = cmd.Flags().GetString("manager-tlskey"); err != nil {
+		return
+	}
+	if opts.CAFile, err = cmd.Flags().GetString("manager-tlscacert"); err != nil {
+		return
+	}
+	if opts.ServerName, err = cmd.Flags().GetString("manager-server-name"); err != nil {
+		return
+	}
+	if opts.InsecureSkipVerify, err = cmd.Flags().GetBool("manager-tls-skip-verify"); err != nil {
+		return
+	}
+	if opts.Timeout, err = cmd.Flags().GetDuration("dial-timeout"); err != nil {
+		return
+	}
+	opts.KeepAlive, err = cmd.Flags().GetDuration("keepalive")
+	return
+}
+
+// loadFailoverOptions reads how the managers are probed and failed over.
+func loadFailoverOptions(cmd *cobra.Command) (opts swarmkit.FailoverOptions, err error) {
+	if opts.HealthInterval, err = cmd.Flags().GetDuration("health-interval"); err != nil {
+		return
+	}
+	if opts.ProbeTimeout, err = cmd.Flags().GetDuration("health-timeout"); err != nil {
+		return
+	}
+	opts.ReadRetries, err = cmd.Flags().GetInt("read-retries")
+	return
+}
+
+// loadPrimaryOptions reads the settings of the api router.
+func loadPrimaryOptions(cmd *cobra.Command) (*api.PrimaryOptions, error) {
+	opts := &api.PrimaryOptions{
+		RouteTimeouts: make(map[string]time.Duration),
+	}
+
+	var err error
+	if opts.RequestTimeout, err = cmd.Flags().GetDuration("request-timeout"); err != nil {
+		return nil, err
+	}
+	authFile, err := cmd.Flags().GetString("auth-file")
+	if err != nil {
+		return nil, err
+	}
+	if len(authFile) > 0 {
+		if opts.Authenticators, err = api.LoadAuthenticators(authFile); err != nil {
+			return nil, err
+		}
+	}
+
+	auditFile, err := cmd.Flags().GetString("audit-file")
+	if err != nil {
+		return nil, err
+	}
+	if len(auditFile) > 0 {
+		maxSize, err := cmd.Flags().GetInt64("audit-max-size")
+		if err != nil {
+			return nil, err
+		}
+		maxBackups, err := cmd.Flags().GetInt("audit-max-backups")
+		if err != nil {
+			return nil, err
+		}
+		if opts.Audit, err = api.NewAuditLog(auditFile, maxSize<<20, maxBackups); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.DataDir, err = cmd.Flags().GetString("data-dir"); err != nil {
+		return nil, err
+	}
+	if opts.HistoryMaxEvents, err = cmd.Flags().GetInt("history-max-events"); err != nil {
+		return nil, err
+	}
+	if opts.HistoryMaxAge, err = cmd.Flags().GetDuration("history-max-age"); err != nil {
+		return nil, err
+	}
+	if opts.DeploymentsMaxKept, err = cmd.Flags().GetInt("deployments-max-kept"); err != nil {
+		return nil, err
+	}
+	if opts.DeploymentsMaxAge, err = cmd.Flags().GetDuration("deployments-max-age"); err != nil {
+		return nil, err
+	}
+	if opts.EventsInterval, err = cmd.Flags().GetDuration("events-interval"); err != nil {
+		return nil, err
+	}
+	if opts.EventsHistory, err = cmd.Flags().GetInt("events-history"); err != nil {
+		return nil, err
+	}
+	if opts.EventsQueueSize, err = cmd.Flags().GetInt("events-queue-size"); err != nil {
+		return nil, err
+	}
+	if opts.Sync, err = loadSyncOptions(cmd); err != nil {
+		return nil, err
+	}
+	if opts.WebsocketOrigins, err = cmd.Flags().GetStringSlice("ws-allowed-origin"); err != nil {
+		return nil, err
+	}
+
+	routeTimeouts, err := cmd.Flags().GetStringSlice("route-timeout")
+	if err != nil {
+		return nil, err
+	}
+	for _, rt := range routeTimeouts {
+		i := strings.LastIndex(rt, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid route timeout %q, expected \"METHOD route=duration\"", rt)
+		}
+		d, err := time.ParseDuration(rt[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid route timeout %q: %v", rt, err)
+		}
+		opts.RouteTimeouts[strings.TrimSpace(rt[:i])] = d
+	}
+	return opts, nil
+}
+
+// loadSyncOptions reads the settings of the sync controller, nil when no
+// sync directory is set.
+func loadSyncOptions(cmd *cobra.Command) (*api.SyncOptions, error) {
+	dir, err := cmd.Flags().GetString("sync-dir")
+	if err != nil || len(dir) == 0 {
+		return nil, err
+	}
+	opts := &api.SyncOptions{Dir: dir}
+	if opts.PollInterval, err = cmd.Flags().GetDuration("sync-poll-interval"); err != nil {
+		return nil, err
+	}
+	if opts.PollInterval <= 0 {
+		return nil, fmt.Errorf("sync-poll-interval must be positive")
+	}
+	if opts.Interval, err = cmd.Flags().GetDuration("sync-interval"); err != nil {
+		return nil, err
+	}
+	if opts.Prune, err = cmd.Flags().GetBool("sync-prune"); err != nil {
+		return nil, err
+	}
+	if opts.CorrectDrift, err = cmd.Flags().GetBool("sync-correct-drift"); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
 func init() {
-	RootCmd.PersistentFlags().StringP("socket", "s", defaultSocket(), "Socket to connect to the Swarm manager")
+	RootCmd.PersistentFlags().StringSliceP("socket", "s", []string{defaultSocket()}, "Addresses of the Swarm managers, tried in order (unix:///path/to/socket or tcp://host:port)")
+	RootCmd.PersistentFlags().Duration("health-interval", 10*time.Second, "interval between health checks of the current Swarm manager (0 = disabled)")
+	RootCmd.PersistentFlags().Duration("health-timeout", 5*time.Second, "timeout of a Swarm manager health check")
+	RootCmd.PersistentFlags().Int("read-retries", 2, "number of times a read is retried on another Swarm manager when unavailable")
 	RootCmd.PersistentFlags().BoolP("api-enable-cors", "c", false, "enable CORS headers in the remote API (default false)")
+	RootCmd.PersistentFlags().String("manager-tlscert", "", "path to the client certificate presented to the Swarm manager")
+	RootCmd.PersistentFlags().String("manager-tlskey", "", "path to the client key presented to the Swarm manager")
+	RootCmd.PersistentFlags().String("manager-tlscacert", "", "verify the Swarm manager certificate with this CA")
+	RootCmd.PersistentFlags().String("manager-server-name", "", "expected name in the Swarm manager certificate (default: manager host)")
+	RootCmd.PersistentFlags().Bool("manager-tls-skip-verify", false, "do not verify the Swarm manager certificate")
+	RootCmd.PersistentFlags().Duration("dial-timeout", 10*time.Second, "timeout to connect to the Swarm manager (0 = no timeout)")
+	RootCmd.PersistentFlags().Duration("keepalive", 30*time.Second, "TCP keepalive period for remote Swarm managers (0 = disabled)")
 	RootCmd.PersistentFlags().StringP("advertise", "a", ":8888", "advertise for http server")
+	RootCmd.PersistentFlags().String("auth-file", "", "json file of api tokens and client certificate names with their roles, enables authentication")
+	RootCmd.PersistentFlags().String("audit-file", "", "json lines file recording every POST and DELETE api call, enables the audit log")
+	RootCmd.PersistentFlags().Int64("audit-max-size", 100, "size in megabytes at which the audit file is rotated (0 = never)")
+	RootCmd.PersistentFlags().Int("audit-max-backups", 5, "number of rotated audit files to keep")
+	RootCmd.PersistentFlags().String("data-dir", "", "directory of the state kept by the client (webhooks, event history, ...), features needing it are disabled when empty")
+	RootCmd.PersistentFlags().Int("history-max-events", 1000000, "number of events kept in the event history (0 = unlimited)")
+	RootCmd.PersistentFlags().Duration("history-max-age", 30*24*time.Hour, "age after which events are removed from the event history (0 = never)")
+	RootCmd.PersistentFlags().Int("deployments-max-kept", 100, "number of finished deployments kept (0 = unlimited)")
+	RootCmd.PersistentFlags().Duration("deployments-max-age", 30*24*time.Hour, "age after which finished deployments are forgotten (0 = never)")
+	RootCmd.PersistentFlags().Duration("events-interval", 2*time.Second, "interval between the cluster listings compared to produce events (0 = events disabled)")
+	RootCmd.PersistentFlags().Int("events-history", 1000, "number of recent events kept for replay with since")
+	RootCmd.PersistentFlags().Int("events-queue-size", 256, "number of events queued for a slow listener before it is disconnected")
+	RootCmd.PersistentFlags().StringSlice("ws-allowed-origin", nil, "origin allowed to open the events websocket besides the api host, e.g. \"https://dashboard.example.com\"")
+	RootCmd.PersistentFlags().String("sync-dir", "", "directory of service and network specs applied to the cluster when they change, enables the sync controller")
+	RootCmd.PersistentFlags().Duration("sync-poll-interval", 5*time.Second, "interval between the checks of the sync directory for changes")
+	RootCmd.PersistentFlags().Duration("sync-interval", time.Minute, "interval between the comparisons of the cluster with the sync directory (0 = only on change)")
+	RootCmd.PersistentFlags().Bool("sync-prune", false, "delete the services and networks created by the sync controller that are removed from the sync directory")
+	RootCmd.PersistentFlags().Bool("sync-correct-drift", false, "apply the sync directory again when the cluster drifted from it, instead of only reporting the drift")
+	RootCmd.PersistentFlags().Duration("request-timeout", 30*time.Second, "timeout of the Swarm manager calls made by an api request (0 = no timeout)")
+	RootCmd.PersistentFlags().StringSlice("route-timeout", nil, "per route request timeout, e.g. \"POST /services/create=1m\"")
+	RootCmd.PersistentFlags().String("tlscert", "", "path to TLS certificate file of the http server, enables TLS")
+	RootCmd.PersistentFlags().String("tlskey", "", "path to TLS key file of the http server")
+	RootCmd.PersistentFlags().String("tlscacert", "", "trust client certificates signed by this CA")
+	RootCmd.PersistentFlags().Bool("tlsverify", false, "require and verify client certificates (mutual TLS)")
+	RootCmd.PersistentFlags().Duration("tls-reload-interval", 30*time.Second, "interval to check TLS files for changes (0 = never)")
 }
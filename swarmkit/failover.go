@@ -0,0 +1,401 @@
+package swarmkit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/docker/swarmkit/api"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// FailoverOptions configures how FailoverClient watches and switches managers.
+type FailoverOptions struct {
+	HealthInterval time.Duration // interval between health probes of the current manager (0 = disabled)
+	ProbeTimeout   time.Duration // timeout of a single health probe
+	ReadRetries    int           // number of retries of a read that failed with Unavailable
+}
+
+// FailoverClient is an api.ControlClient connected to one manager out of a
+// list. When the manager becomes unavailable or loses contact with the raft
+// leader the client reconnects to the next manager of the list. Reads are
+// retried on another manager, writes are not, since they may have been
+// applied before the connection dropped.
+type FailoverClient struct {
+	sync.RWMutex
+	addrs    []string
+	dialOpts DialOptions
+	opts     FailoverOptions
+	current  int
+	conn     io.Closer
+	client   api.ControlClient
+
+	switchMu sync.Mutex // serializes reconnections
+	done     chan struct{}
+}
+
+// dialManager connects to the manager at addr, replaced by the tests.
+var dialManager = func(addr string, opts DialOptions) (api.ControlClient, io.Closer, error) {
+	conn, err := dial(addr, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return api.NewControlClient(conn), conn, nil
+}
+
+// DialManagers connects to the first healthy manager of addrs and returns a
+// client that fails over to the other managers.
+func DialManagers(addrs []string, dialOpts DialOptions, opts FailoverOptions) (*FailoverClient, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("at least one manager address is required")
+	}
+	if opts.ProbeTimeout <= 0 {
+		opts.ProbeTimeout = 5 * time.Second
+	}
+
+	c := &FailoverClient{
+		addrs:    addrs,
+		dialOpts: dialOpts,
+		opts:     opts,
+		done:     make(chan struct{}),
+	}
+	if err := c.connect(0); err != nil {
+		return nil, err
+	}
+	if opts.HealthInterval > 0 {
+		go c.monitor()
+	}
+	return c, nil
+}
+
+// Manager returns the address of the manager currently in use.
+func (c *FailoverClient) Manager() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.addrs[c.current]
+}
+
+// Close stops the health monitor and closes the connection.
+func (c *FailoverClient) Close() error {
+	c.switchMu.Lock()
+	defer c.switchMu.Unlock()
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+	close(c.done)
+
+	c.Lock()
+	defer c.Unlock()
+	return c.conn.Close()
+}
+
+func (c *FailoverClient) get() api.ControlClient {
+	c.RLock()
+	defer c.RUnlock()
+	return c.client
+}
+
+// connect tries the managers in order, starting at index start, and keeps
+// the first one that passes the health probe.
+func (c *FailoverClient) connect(start int) error {
+	errs := []string{}
+	for i := 0; i < len(c.addrs); i++ {
+		idx := (start + i) % len(c.addrs)
+		addr := c.addrs[idx]
+
+		client, conn, err := dialManager(addr, c.dialOpts)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if err = c.probe(client); err != nil {
+			conn.Close()
+			errs = append(errs, fmt.Sprintf("%s: %v", addr, err))
+			continue
+		}
+
+		c.Lock()
+		old := c.conn
+		c.current, c.conn, c.client = idx, conn, client
+		c.Unlock()
+		if old != nil {
+			old.Close()
+		}
+		log.WithField("manager", addr).Info("Connected to swarm manager")
+		return nil
+	}
+	return fmt.Errorf("no healthy swarm manager: %s", strings.Join(errs, "; "))
+}
+
+// failover switches to the next manager, unless another caller already
+// replaced the failed client.
+func (c *FailoverClient) failover(failed api.ControlClient) error {
+	c.switchMu.Lock()
+	defer c.switchMu.Unlock()
+
+	select {
+	case <-c.done:
+		return errors.New("client is closed")
+	default:
+	}
+
+	c.RLock()
+	current, client := c.current, c.client
+	c.RUnlock()
+	if client != failed {
+		return nil
+	}
+
+	log.WithField("manager", c.addrs[current]).Warn("Swarm manager unavailable, failing over")
+	return c.connect(current + 1)
+}
+
+// probe checks that the manager answers and that it knows a raft leader.
+// Followers forward control requests to the leader, so a manager without a
+// leader cannot serve any request.
+func (c *FailoverClient) probe(client api.ControlClient) error {
+	ctx, cancel := ct.WithTimeout(ct.Background(), c.opts.ProbeTimeout)
+	defer cancel()
+
+	resp, err := client.ListNodes(ctx, &api.ListNodesRequest{})
+	if err != nil {
+		return err
+	}
+	for _, n := range resp.Nodes {
+		if n.ManagerStatus != nil && n.ManagerStatus.Leader {
+			return nil
+		}
+	}
+	return errors.New("manager has no leader")
+}
+
+func (c *FailoverClient) monitor() {
+	ticker := time.NewTicker(c.opts.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+
+		client := c.get()
+		if err := c.probe(client); err != nil {
+			log.WithField("manager", c.Manager()).Warnf("Swarm manager health check failed: %v", err)
+			if err = c.failover(client); err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
+
+// read runs an idempotent call and retries it on another manager when the
+// current one is unavailable.
+func (c *FailoverClient) read(ctx ct.Context, fn func(api.ControlClient) error) error {
+	for attempt := 0; ; attempt++ {
+		client := c.get()
+		err := fn(client)
+		if err == nil || grpc.Code(err) != codes.Unavailable {
+			return err
+		}
+		if attempt >= c.opts.ReadRetries || ctx.Err() != nil {
+			return err
+		}
+		if ferr := c.failover(client); ferr != nil {
+			log.Error(ferr)
+			return err
+		}
+	}
+}
+
+// write runs a call once. An Unavailable error triggers a failover for the
+// next calls but is returned to the caller.
+func (c *FailoverClient) write(fn func(api.ControlClient) error) error {
+	client := c.get()
+	err := fn(client)
+	if err != nil && grpc.Code(err) == codes.Unavailable {
+		if ferr := c.failover(client); ferr != nil {
+			log.Error(ferr)
+		}
+	}
+	return err
+}
+
+// GetNode implements api.ControlClient.
+func (c *FailoverClient) GetNode(ctx ct.Context, in *api.GetNodeRequest, opts ...grpc.CallOption) (resp *api.GetNodeResponse, err error) {
+	err = c.read(ctx, func(client api.ControlClient) (err error) {
+		resp, err = client.GetNode(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// ListNodes implements api.ControlClient.
+func (c *FailoverClient) ListNodes(ctx ct.Context, in *api.ListNodesRequest, opts ...grpc.CallOption) (resp *api.ListNodesResponse, err error) {
+	err = c.read(ctx, func(client api.ControlClient) (err error) {
+		resp, err = client.ListNodes(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// UpdateNode implements api.ControlClient.
+func (c *FailoverClient) UpdateNode(ctx ct.Context, in *api.UpdateNodeRequest, opts ...grpc.CallOption) (resp *api.UpdateNodeResponse, err error) {
+	err = c.write(func(client api.ControlClient) (err error) {
+		resp, err = client.UpdateNode(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// RemoveNode implements api.ControlClient.
+func (c *FailoverClient) RemoveNode(ctx ct.Context, in *api.RemoveNodeRequest, opts ...grpc.CallOption) (resp *api.RemoveNodeResponse, err error) {
+	err = c.write(func(client api.ControlClient) (err error) {
+		resp, err = client.RemoveNode(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// GetTask implements api.ControlClient.
+func (c *FailoverClient) GetTask(ctx ct.Context, in *api.GetTaskRequest, opts ...grpc.CallOption) (resp *api.GetTaskResponse, err error) {
+	err = c.read(ctx, func(client api.ControlClient) (err error) {
+		resp, err = client.GetTask(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// ListTasks implements api.ControlClient.
+func (c *FailoverClient) ListTasks(ctx ct.Context, in *api.ListTasksRequest, opts ...grpc.CallOption) (resp *api.ListTasksResponse, err error) {
+	err = c.read(ctx, func(client api.ControlClient) (err error) {
+		resp, err = client.ListTasks(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// RemoveTask implements api.ControlClient.
+func (c *FailoverClient) RemoveTask(ctx ct.Context, in *api.RemoveTaskRequest, opts ...grpc.CallOption) (resp *api.RemoveTaskResponse, err error) {
+	err = c.write(func(client api.ControlClient) (err error) {
+		resp, err = client.RemoveTask(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// GetService implements api.ControlClient.
+func (c *FailoverClient) GetService(ctx ct.Context, in *api.GetServiceRequest, opts ...grpc.CallOption) (resp *api.GetServiceResponse, err error) {
+	err = c.read(ctx, func(client api.ControlClient) (err error) {
+		resp, err = client.GetService(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// ListServices implements api.ControlClient.
+func (c *FailoverClient) ListServices(ctx ct.Context, in *api.ListServicesRequest, opts ...grpc.CallOption) (resp *api.ListServicesResponse, err error) {
+	err = c.read(ctx, func(client api.ControlClient) (err error) {
+		resp, err = client.ListServices(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// CreateService implements api.ControlClient.
+func (c *FailoverClient) CreateService(ctx ct.Context, in *api.CreateServiceRequest, opts ...grpc.CallOption) (resp *api.CreateServiceResponse, err error) {
+	err = c.write(func(client api.ControlClient) (err error) {
+		resp, err = client.CreateService(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// UpdateService implements api.ControlClient.
+func (c *FailoverClient) UpdateService(ctx ct.Context, in *api.UpdateServiceRequest, opts ...grpc.CallOption) (resp *api.UpdateServiceResponse, err error) {
+	err = c.write(func(client api.ControlClient) (err error) {
+		resp, err = client.UpdateService(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// RemoveService implements api.ControlClient.
+func (c *FailoverClient) RemoveService(ctx ct.Context, in *api.RemoveServiceRequest, opts ...grpc.CallOption) (resp *api.RemoveServiceResponse, err error) {
+	err = c.write(func(client api.ControlClient) (err error) {
+		resp, err = client.RemoveService(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// GetNetwork implements api.ControlClient.
+func (c *FailoverClient) GetNetwork(ctx ct.Context, in *api.GetNetworkRequest, opts ...grpc.CallOption) (resp *api.GetNetworkResponse, err error) {
+	err = c.read(ctx, func(client api.ControlClient) (err error) {
+		resp, err = client.GetNetwork(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// ListNetworks implements api.ControlClient.
+func (c *FailoverClient) ListNetworks(ctx ct.Context, in *api.ListNetworksRequest, opts ...grpc.CallOption) (resp *api.ListNetworksResponse, err error) {
+	err = c.read(ctx, func(client api.ControlClient) (err error) {
+		resp, err = client.ListNetworks(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// CreateNetwork implements api.ControlClient.
+func (c *FailoverClient) CreateNetwork(ctx ct.Context, in *api.CreateNetworkRequest, opts ...grpc.CallOption) (resp *api.CreateNetworkResponse, err error) {
+	err = c.write(func(client api.ControlClient) (err error) {
+		resp, err = client.CreateNetwork(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// RemoveNetwork implements api.ControlClient.
+func (c *FailoverClient) RemoveNetwork(ctx ct.Context, in *api.RemoveNetworkRequest, opts ...grpc.CallOption) (resp *api.RemoveNetworkResponse, err error) {
+	err = c.write(func(client api.ControlClient) (err error) {
+		resp, err = client.RemoveNetwork(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// GetCluster implements api.ControlClient.
+func (c *FailoverClient) GetCluster(ctx ct.Context, in *api.GetClusterRequest, opts ...grpc.CallOption) (resp *api.GetClusterResponse, err error) {
+	err = c.read(ctx, func(client api.ControlClient) (err error) {
+		resp, err = client.GetCluster(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// ListClusters implements api.ControlClient.
+func (c *FailoverClient) ListClusters(ctx ct.Context, in *api.ListClustersRequest, opts ...grpc.CallOption) (resp *api.ListClustersResponse, err error) {
+	err = c.read(ctx, func(client api.ControlClient) (err error) {
+		resp, err = client.ListClusters(ctx, in, opts...)
+		return
+	})
+	return
+}
+
+// UpdateCluster implements api.ControlClient.
+func (c *FailoverClient) UpdateCluster(ctx ct.Context, in *api.UpdateClusterRequest, opts ...grpc.CallOption) (resp *api.UpdateClusterResponse, err error) {
+	err = c.write(func(client api.ControlClient) (err error) {
+		resp, err = client.UpdateCluster(ctx, in, opts...)
+		return
+	})
+	return
+}
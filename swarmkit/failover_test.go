@@ -0,0 +1,94 @@
+package swarmkit
+
+import (
+	"io"
+	"testing"
+
+	"github.com/docker/swarmkit/api"
+	ct "golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// managerTestClient is a manager that knows a leader and answers the service
+// calls with err.
+type managerTestClient struct {
+	api.ControlClient
+	err   error
+	calls int // service calls received
+}
+
+func (m *managerTestClient) Close() error { return nil }
+
+func (m *managerTestClient) ListNodes(ctx ct.Context, r *api.ListNodesRequest, opts ...grpc.CallOption) (*api.ListNodesResponse, error) {
+	return &api.ListNodesResponse{Nodes: []*api.Node{{ManagerStatus: &api.ManagerStatus{Leader: true}}}}, nil
+}
+
+func (m *managerTestClient) GetService(ctx ct.Context, r *api.GetServiceRequest, opts ...grpc.CallOption) (*api.GetServiceResponse, error) {
+	m.calls++
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &api.GetServiceResponse{Service: &api.Service{ID: r.ServiceID}}, nil
+}
+
+func (m *managerTestClient) UpdateService(ctx ct.Context, r *api.UpdateServiceRequest, opts ...grpc.CallOption) (*api.UpdateServiceResponse, error) {
+	m.calls++
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &api.UpdateServiceResponse{Service: &api.Service{ID: r.ServiceID}}, nil
+}
+
+func TestFailoverRetries(t *testing.T) {
+	unavailable := grpc.Errorf(codes.Unavailable, "manager down")
+	notFound := grpc.Errorf(codes.NotFound, "service web not found")
+
+	tests := []struct {
+		name    string
+		write   bool
+		retries int
+		err     error // of the first manager
+		code    codes.Code
+		calls   [2]int // service calls received by each manager
+		manager string // manager in use afterwards
+	}{
+		{"read", false, 1, nil, codes.OK, [2]int{1, 0}, "m1"},
+		{"read retried on the next manager", false, 1, unavailable, codes.OK, [2]int{1, 1}, "m2"},
+		{"read without retries", false, 0, unavailable, codes.Unavailable, [2]int{1, 0}, "m1"},
+		{"read of a missing object", false, 1, notFound, codes.NotFound, [2]int{1, 0}, "m1"},
+		{"write", true, 1, nil, codes.OK, [2]int{1, 0}, "m1"},
+		{"write not retried", true, 1, unavailable, codes.Unavailable, [2]int{1, 0}, "m2"},
+		{"write of a missing object", true, 1, notFound, codes.NotFound, [2]int{1, 0}, "m1"},
+	}
+
+	original := dialManager
+	defer func() { dialManager = original }()
+	for _, test := range tests {
+		managers := map[string]*managerTestClient{"m1": {err: test.err}, "m2": {}}
+		dialManager = func(addr string, opts DialOptions) (api.ControlClient, io.Closer, error) {
+			m := managers[addr]
+			return m, m, nil
+		}
+
+		c, err := DialManagers([]string{"m1", "m2"}, DialOptions{}, FailoverOptions{ReadRetries: test.retries})
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if test.write {
+			_, err = c.UpdateService(ct.Background(), &api.UpdateServiceRequest{ServiceID: "web"})
+		} else {
+			_, err = c.GetService(ct.Background(), &api.GetServiceRequest{ServiceID: "web"})
+		}
+		if code := grpc.Code(err); code != test.code {
+			t.Errorf("%s: got code %v (%v), want %v", test.name, code, err, test.code)
+		}
+		if calls := [2]int{managers["m1"].calls, managers["m2"].calls}; calls != test.calls {
+			t.Errorf("%s: got calls %v, want %v", test.name, calls, test.calls)
+		}
+		if manager := c.Manager(); manager != test.manager {
+			t.Errorf("%s: got manager %s, want %s", test.name, manager, test.manager)
+		}
+		c.Close()
+	}
+}
@@ -2,8 +2,11 @@ package swarmkit
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/docker/swarmkit/api"
@@ -12,17 +15,45 @@ import (
 	"google.golang.org/grpc/credentials"
 )
 
+// NotFoundError is returned by the lookup helpers when no object matches
+// the given ID or name.
+type NotFoundError struct {
+	Kind  string // object kind, e.g. service
+	Input string // ID or name that was looked up
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("%s %s not found", e.Kind, e.Input)
+}
+
+// AmbiguousError is returned by the lookup helpers when a name matches more
+// than one object.
+type AmbiguousError struct {
+	Kind    string // object kind, e.g. service
+	Input   string // ID or name that was looked up
+	Matches int    // number of objects found
+}
+
+func (e *AmbiguousError) Error() string {
+	return fmt.Sprintf("%s %s is ambiguous (%d matches found)", e.Kind, e.Input, e.Matches)
+}
+
+// DialOptions holds the connection parameters for a swarmkit manager.
+type DialOptions struct {
+	CertFile           string        // client certificate presented to the manager (PEM)
+	KeyFile            string        // client private key (PEM)
+	CAFile             string        // CA bundle used to verify the manager certificate (PEM)
+	ServerName         string        // expected name in the manager certificate, defaults to the dialed host
+	InsecureSkipVerify bool          // do not verify the manager certificate
+	Timeout            time.Duration // dial timeout (0 = no timeout)
+	KeepAlive          time.Duration // TCP keepalive period (0 = disabled)
+}
+
 // Dial establishes a connection and creates a client.
-// It infers connection parameters from CLI options.
-func Dial(socketAddr string) (api.ControlClient, error) {
-	opts := []grpc.DialOption{}
-	insecureCreds := credentials.NewTLS(&tls.Config{InsecureSkipVerify: true})
-	opts = append(opts, grpc.WithTransportCredentials(insecureCreds))
-	opts = append(opts, grpc.WithDialer(
-		func(addr string, timeout time.Duration) (net.Conn, error) {
-			return net.DialTimeout("unix", addr, timeout)
-		}))
-	conn, err := grpc.Dial(socketAddr, opts...)
+// addr is either a unix socket ("unix:///path/to/swarm.sock" or a bare path)
+// or a remote manager ("tcp://host:port").
+func Dial(addr string, opts DialOptions) (api.ControlClient, error) {
+	conn, err := dial(addr, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -31,6 +62,105 @@ func Dial(socketAddr string) (api.ControlClient, error) {
 	return client, nil
 }
 
+// dial opens a grpc connection to the manager at addr.
+func dial(addr string, opts DialOptions) (*grpc.ClientConn, error) {
+	proto, target, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig, err := clientTLSConfig(proto, target, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   opts.Timeout,
+		KeepAlive: opts.KeepAlive,
+	}
+	if proto == "unix" {
+		dialer.KeepAlive = 0
+	}
+
+	grpcOpts := []grpc.DialOption{}
+	grpcOpts = append(grpcOpts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	grpcOpts = append(grpcOpts, grpc.WithDialer(
+		func(addr string, timeout time.Duration) (net.Conn, error) {
+			d := *dialer
+			if opts.Timeout == 0 || (timeout > 0 && timeout < opts.Timeout) {
+				d.Timeout = timeout
+			}
+			return d.Dial(proto, addr)
+		}))
+	if opts.Timeout > 0 {
+		grpcOpts = append(grpcOpts, grpc.WithBlock(), grpc.WithTimeout(opts.Timeout))
+	}
+	conn, err := grpc.Dial(target, grpcOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %v", addr, err)
+	}
+	return conn, nil
+}
+
+// parseAddr splits addr into a network protocol and a dial target.
+func parseAddr(addr string) (string, string, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix://"):
+		return "unix", strings.TrimPrefix(addr, "unix://"), nil
+	case strings.HasPrefix(addr, "tcp://"):
+		hostport := strings.TrimPrefix(addr, "tcp://")
+		if _, _, err := net.SplitHostPort(hostport); err != nil {
+			return "", "", fmt.Errorf("invalid manager address %s: %v", addr, err)
+		}
+		return "tcp", hostport, nil
+	case strings.Contains(addr, "://"):
+		return "", "", fmt.Errorf("unsupported manager address %s, use unix:// or tcp://", addr)
+	}
+	return "unix", addr, nil
+}
+
+// clientTLSConfig creates the tls.Config used to talk to the manager. The
+// local control socket keeps the historical behaviour of not verifying the
+// manager unless a CA is given; remote managers must be verified unless
+// InsecureSkipVerify is set explicitly.
+func clientTLSConfig(proto, target string, opts DialOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+
+	if len(opts.CertFile) > 0 || len(opts.KeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(opts.CAFile) > 0 {
+		pem, err := ioutil.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no valid certificate found in %s", opts.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	} else if proto == "unix" {
+		tlsConfig.InsecureSkipVerify = true
+	} else if !opts.InsecureSkipVerify {
+		return nil, fmt.Errorf("a CA certificate is required to verify the manager at %s", target)
+	}
+
+	if len(tlsConfig.ServerName) == 0 && proto == "tcp" {
+		host, _, _ := net.SplitHostPort(target)
+		tlsConfig.ServerName = host
+	}
+
+	return tlsConfig, nil
+}
+
 // GetService get service with service id or service name in cluster
 func GetService(ctx ct.Context, c api.ControlClient, input string) (*api.Service, error) {
 	// GetService to match via full ID.
@@ -49,11 +179,11 @@ func GetService(ctx ct.Context, c api.ControlClient, input string) (*api.Service
 		}
 
 		if len(rl.Services) == 0 {
-			return nil, fmt.Errorf("service %s not found", input)
+			return nil, &NotFoundError{Kind: "service", Input: input}
 		}
 
 		if l := len(rl.Services); l > 1 {
-			return nil, fmt.Errorf("service %s is ambiguous (%d matches found)", input, l)
+			return nil, &AmbiguousError{Kind: "service", Input: input, Matches: l}
 		}
 
 		return rl.Services[0], nil
@@ -79,11 +209,11 @@ func GetNode(ctx ct.Context, c api.ControlClient, input string) (*api.Node, erro
 		}
 
 		if len(rl.Nodes) == 0 {
-			return nil, fmt.Errorf("node %s not found", input)
+			return nil, &NotFoundError{Kind: "node", Input: input}
 		}
 
 		if l := len(rl.Nodes); l > 1 {
-			return nil, fmt.Errorf("node %s is ambiguous (%d matches found)", input, l)
+			return nil, &AmbiguousError{Kind: "node", Input: input, Matches: l}
 		}
 
 		return rl.Nodes[0], nil
@@ -112,11 +242,11 @@ func GetNetwork(ctx ct.Context, c api.ControlClient, input string) (*api.Network
 		}
 
 		if len(rl.Networks) == 0 {
-			return nil, fmt.Errorf("network %s not found", input)
+			return nil, &NotFoundError{Kind: "network", Input: input}
 		}
 
 		if l := len(rl.Networks); l > 1 {
-			return nil, fmt.Errorf("network %s is ambiguous (%d matches found)", input, l)
+			return nil, &AmbiguousError{Kind: "network", Input: input, Matches: l}
 		}
 
 		return rl.Networks[0], nil
@@ -143,11 +273,11 @@ func GetCluster(ctx ct.Context, c api.ControlClient, input string) (*api.Cluster
 	}
 
 	if len(rl.Clusters) == 0 {
-		return nil, fmt.Errorf("cluster %s not found", input)
+		return nil, &NotFoundError{Kind: "cluster", Input: input}
 	}
 
 	if l := len(rl.Clusters); l > 1 {
-		return nil, fmt.Errorf("cluster %s is ambiguous (%d matches found)", input, l)
+		return nil, &AmbiguousError{Kind: "cluster", Input: input, Matches: l}
 	}
 
 	return rl.Clusters[0], nil